@@ -0,0 +1,218 @@
+// Command backfill_canonical_urls recomputes the canonical URL of every product and
+// merges products that turn out to share one.
+//
+// Run it once after upgrading to canonical URL dedup and whenever URL_TRACKING_PARAMS
+// or the canonicalization rules change, with the same environment as the API:
+//
+//	go run ./cmd/backfill_canonical_urls -dry-run
+//	go run ./cmd/backfill_canonical_urls
+//
+// Of each group of duplicates the active, most recently updated product survives.
+// The others are deleted after their price history, alert subscriptions and alert
+// deliveries were moved to the survivor, which keeps the earliest creation time.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"product-api/config"
+	"product-api/database"
+	"product-api/models"
+	"product-api/utils"
+	"strings"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// duplicate is a product sharing its recomputed canonical URL with others
+type duplicate struct {
+	CanonicalURL string
+	ID           string
+}
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "report the duplicates without changing anything")
+	batchSize := flag.Int("batch", 1000, "products canonicalized per statement")
+	flag.Parse()
+
+	cfg := config.Load()
+	utils.SetTrackingParams(cfg.TrackingParams)
+
+	db, err := database.Initialize(cfg.DatabaseURL)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	db.Logger = logger.Default.LogMode(logger.Warn)
+	if err := database.Migrate(db); err != nil {
+		log.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	// The recomputed URLs go to a temporary table, which lives on one connection
+	err = db.Connection(func(conn *gorm.DB) error {
+		if err := conn.Exec("CREATE TEMPORARY TABLE canonical_backfill (id varchar(255) PRIMARY KEY, canonical_url text NOT NULL)").Error; err != nil {
+			return err
+		}
+
+		total, changed, err := recompute(conn, *batchSize)
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Recomputed canonical URLs of %d products, %d differ from the stored ones", total, changed)
+
+		groups, err := findDuplicates(conn)
+		if err != nil {
+			return err
+		}
+		removed := 0
+		for _, ids := range groups {
+			removed += len(ids) - 1
+		}
+		log.Printf("[INFO] Found %d canonical URLs shared by several products, %d products to merge away", len(groups), removed)
+
+		if *dryRun {
+			for canonical, ids := range groups {
+				log.Printf("[INFO] %s: keep %s, merge %s", canonical, ids[0], strings.Join(ids[1:], ", "))
+			}
+			return nil
+		}
+
+		for canonical, ids := range groups {
+			if err := merge(conn, ids[0], ids[1:]); err != nil {
+				return fmt.Errorf("failed to merge products of %s: %w", canonical, err)
+			}
+		}
+
+		updated, err := store(conn)
+		if err != nil {
+			return err
+		}
+		log.Printf("[SUCCESS] Merged %d duplicate products, updated %d canonical URLs", removed, updated)
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("Backfill failed: %v", err)
+	}
+}
+
+// recompute canonicalizes every product URL into canonical_backfill and returns the
+// number of products and how many of them get a different canonical URL
+func recompute(conn *gorm.DB, batchSize int) (int, int, error) {
+	total, changed := 0, 0
+	lastID := ""
+	for {
+		var rows []struct {
+			ID           string
+			ProductURL   string
+			CanonicalURL string
+		}
+		if err := conn.Model(&models.Product{}).
+			Select("id, COALESCE(product_url, '') AS product_url, COALESCE(canonical_url, '') AS canonical_url").
+			Where("id > ?", lastID).Order("id").Limit(batchSize).
+			Scan(&rows).Error; err != nil {
+			return total, changed, err
+		}
+		if len(rows) == 0 {
+			return total, changed, nil
+		}
+
+		values := make([]string, len(rows))
+		args := make([]interface{}, 0, 2*len(rows))
+		for i, row := range rows {
+			canonical := utils.CanonicalURL(row.ProductURL)
+			if canonical != row.CanonicalURL {
+				changed++
+			}
+			values[i] = "(?, ?)"
+			args = append(args, row.ID, canonical)
+		}
+		if err := conn.Exec("INSERT INTO canonical_backfill (id, canonical_url) VALUES "+strings.Join(values, ", "), args...).Error; err != nil {
+			return total, changed, err
+		}
+
+		total += len(rows)
+		lastID = rows[len(rows)-1].ID
+	}
+}
+
+// findDuplicates groups the products sharing a recomputed canonical URL, survivor first:
+// active before inactive, then most recently updated
+func findDuplicates(conn *gorm.DB) (map[string][]string, error) {
+	var rows []duplicate
+	if err := conn.Raw(`SELECT b.canonical_url, p.id FROM canonical_backfill b JOIN products p ON p.id = b.id
+		WHERE b.canonical_url IN (
+			SELECT canonical_url FROM canonical_backfill WHERE canonical_url <> '' GROUP BY canonical_url HAVING count(*) > 1
+		)
+		ORDER BY b.canonical_url, p.is_active DESC, p.updated_at DESC, p.id`).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string][]string)
+	for _, row := range rows {
+		groups[row.CanonicalURL] = append(groups[row.CanonicalURL], row.ID)
+	}
+	return groups, nil
+}
+
+// merge moves everything referencing the duplicates to the survivor and deletes them
+// with their image jobs, releasing their references to stored images
+func merge(conn *gorm.DB, survivor string, duplicates []string) error {
+	return conn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.PriceHistory{}).Where("product_id IN ?", duplicates).
+			Update("product_id", survivor).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.AlertSubscription{}).Where("product_id IN ?", duplicates).
+			Update("product_id", survivor).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.AlertDelivery{}).Where("product_id IN ?", duplicates).
+			Update("product_id", survivor).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(`UPDATE products SET created_at = LEAST(created_at, (SELECT min(created_at) FROM products WHERE id IN ?))
+			WHERE id = ?`, duplicates, survivor).Error; err != nil {
+			return err
+		}
+		var images []datatypes.JSON
+		if err := tx.Model(&models.Product{}).Where("id IN ?", duplicates).Pluck("images", &images).Error; err != nil {
+			return err
+		}
+		// Their queued downloads are for images that go with them
+		if err := tx.Where("product_id IN ?", duplicates).Delete(&models.ImageJob{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("id IN ?", duplicates).Delete(&models.Product{}).Error; err != nil {
+			return err
+		}
+		refs := utils.ImageRefs{}
+		for _, deleted := range images {
+			refs.Replace(deleted, nil)
+		}
+		if err := refs.Apply(tx); err != nil {
+			return err
+		}
+		log.Printf("[DEBUG] merge: Merged %s into %s", strings.Join(duplicates, ", "), survivor)
+		return nil
+	})
+}
+
+// store writes the recomputed canonical URLs. Changed rows are cleared first, which
+// takes them out of the partial unique index, so swapped URLs cannot collide midway.
+func store(conn *gorm.DB) (int64, error) {
+	var updated int64
+	err := conn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`UPDATE products p SET canonical_url = '' FROM canonical_backfill b
+			WHERE p.id = b.id AND p.canonical_url IS DISTINCT FROM b.canonical_url`).Error; err != nil {
+			return err
+		}
+		result := tx.Exec(`UPDATE products p SET canonical_url = b.canonical_url FROM canonical_backfill b
+			WHERE p.id = b.id AND p.canonical_url IS DISTINCT FROM b.canonical_url`)
+		updated = result.RowsAffected
+		return result.Error
+	})
+	return updated, err
+}
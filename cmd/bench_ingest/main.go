@@ -0,0 +1,117 @@
+// Command bench_ingest measures POST /api/stock/add against a running server.
+//
+// It replays a product fixture (test_products_1000.json by default) several times
+// and prints per-run and summary timings. Run it once against a build of the
+// previous commit and once against the current one to compare ingestion paths:
+//
+//	go run ./cmd/bench_ingest -url http://localhost:8080 -runs 5
+//	go run ./cmd/bench_ingest -url http://localhost:8080 -runs 5 -fresh
+//
+// With -fresh every run rewrites IDs and product URLs so all products are new
+// inserts; without it the first run inserts and the following runs hit the
+// existing-URL path.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+func main() {
+	baseURL := flag.String("url", "http://localhost:8080", "base URL of the product API")
+	file := flag.String("file", "test_products_1000.json", "product fixture to replay")
+	runs := flag.Int("runs", 5, "number of times the fixture is posted")
+	fresh := flag.Bool("fresh", false, "rewrite _id and productUrl per run so every product is a new insert")
+	flag.Parse()
+
+	raw, err := os.ReadFile(*file)
+	if err != nil {
+		log.Fatalf("Failed to read fixture: %v", err)
+	}
+
+	var products []map[string]interface{}
+	if err := json.Unmarshal(raw, &products); err != nil {
+		log.Fatalf("Failed to parse fixture: %v", err)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Minute}
+	runID := time.Now().Unix()
+	durations := make([]time.Duration, 0, *runs)
+
+	for run := 1; run <= *runs; run++ {
+		body := raw
+		if *fresh {
+			body, err = rewriteFixture(products, fmt.Sprintf("bench-%d-%d", runID, run))
+			if err != nil {
+				log.Fatalf("Failed to rewrite fixture: %v", err)
+			}
+		}
+
+		start := time.Now()
+		resp, err := client.Post(*baseURL+"/api/stock/add", "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Fatalf("Run %d: request failed: %v", run, err)
+		}
+		respBody, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		elapsed := time.Since(start)
+
+		if resp.StatusCode != http.StatusCreated {
+			log.Fatalf("Run %d: unexpected status %d: %s", run, resp.StatusCode, respBody)
+		}
+
+		durations = append(durations, elapsed)
+		fmt.Printf("run %d: %d products in %v (%.0f products/s) %s\n",
+			run, len(products), elapsed, float64(len(products))/elapsed.Seconds(), bytes.TrimSpace(respBody))
+	}
+
+	printSummary(durations, len(products))
+}
+
+// rewriteFixture makes every product unique for one run by prefixing _id and productUrl
+func rewriteFixture(products []map[string]interface{}, prefix string) ([]byte, error) {
+	rewritten := make([]map[string]interface{}, len(products))
+	for i, product := range products {
+		copied := make(map[string]interface{}, len(product))
+		for key, value := range product {
+			copied[key] = value
+		}
+		if id, ok := copied["_id"].(string); ok && id != "" {
+			copied["_id"] = prefix + "-" + id
+		}
+		if productURL, ok := copied["productUrl"].(string); ok && productURL != "" {
+			copied["productUrl"] = productURL + "?" + prefix
+		}
+		rewritten[i] = copied
+	}
+	return json.Marshal(rewritten)
+}
+
+// printSummary prints min, median, mean and max over all runs
+func printSummary(durations []time.Duration, productCount int) {
+	if len(durations) == 0 {
+		return
+	}
+
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+	mean := total / time.Duration(len(sorted))
+
+	fmt.Printf("\n%d runs x %d products\n", len(sorted), productCount)
+	fmt.Printf("min %v  median %v  mean %v  max %v\n",
+		sorted[0], sorted[len(sorted)/2], mean, sorted[len(sorted)-1])
+	fmt.Printf("mean throughput: %.0f products/s\n", float64(productCount)/mean.Seconds())
+}
@@ -1,7 +1,11 @@
 package database
 
 import (
+	"fmt"
+	"log"
 	"product-api/models"
+	"product-api/utils"
+	"strings"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -35,11 +39,27 @@ func Initialize(databaseURL string) (*gorm.DB, error) {
 
 // Migrate runs database migrations
 func Migrate(db *gorm.DB) error {
-	err := db.AutoMigrate(&models.Product{})
+	err := db.AutoMigrate(&models.Product{}, &models.ImportJob{}, &models.PricingRule{}, &models.ExchangeRate{}, &models.PriceHistory{},
+		&models.AlertSubscription{}, &models.AlertDelivery{}, &models.AlertDeadLetter{}, &models.Image{}, &models.ImageJob{})
 	if err != nil {
 		return err
 	}
 
+	// Seed the pricing rules the multiplier ladder used to be hardcoded with
+	if err := seedPricingRules(db); err != nil {
+		return err
+	}
+
+	// Generated full-text search column
+	if err := createSearchColumn(db); err != nil {
+		return err
+	}
+
+	// Canonical URLs of products stored before the column existed
+	if err := backfillCanonicalURLs(db); err != nil {
+		return err
+	}
+
 	// Create indexes for better performance
 	if err := createIndexes(db); err != nil {
 		return err
@@ -48,6 +68,26 @@ func Migrate(db *gorm.DB) error {
 	return nil
 }
 
+// createSearchColumn adds the tsvector used by product search. Names are indexed with
+// both the Turkish and the Russian stemmer since stores list products in either language;
+// brands are indexed unstemmed.
+func createSearchColumn(db *gorm.DB) error {
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error; err != nil {
+		return err
+	}
+
+	return db.Exec(`ALTER TABLE products ADD COLUMN IF NOT EXISTS search_vector tsvector
+		GENERATED ALWAYS AS (
+			setweight(to_tsvector('turkish', coalesce(name, '')), 'A') ||
+			setweight(to_tsvector('russian', coalesce(name, '')), 'A') ||
+			setweight(to_tsvector('simple', coalesce(brand, '')), 'B') ||
+			setweight(to_tsvector('turkish', coalesce(category, '')), 'C') ||
+			setweight(to_tsvector('russian', coalesce(category, '')), 'C') ||
+			setweight(to_tsvector('turkish', coalesce(description, '')), 'D') ||
+			setweight(to_tsvector('russian', coalesce(description, '')), 'D')
+		) STORED`).Error
+}
+
 // createIndexes creates database indexes for performance optimization
 func createIndexes(db *gorm.DB) error {
 	// Composite index for store and is_active (most common query pattern)
@@ -80,10 +120,206 @@ func createIndexes(db *gorm.DB) error {
 		return err
 	}
 
+	// Keyset pagination over active products in (created_at, id) order, with and without a store
+	if err := db.Exec("CREATE INDEX IF NOT EXISTS idx_products_active_created_id ON products(created_at DESC, id DESC) WHERE is_active = true").Error; err != nil {
+		return err
+	}
+	if err := db.Exec("CREATE INDEX IF NOT EXISTS idx_products_active_store_created_id ON products(store, created_at DESC, id DESC) WHERE is_active = true").Error; err != nil {
+		return err
+	}
+
 	// GIN index for images field (JSONB type for array operations)
 	if err := db.Exec("CREATE INDEX IF NOT EXISTS idx_products_images_gin ON products USING gin(images)").Error; err != nil {
 		return err
 	}
 
+	// GIN indexes for the size and color filters; jsonb_path_ops serves @> containment only, at a fraction of the size
+	if err := db.Exec("CREATE INDEX IF NOT EXISTS idx_products_sizes_gin ON products USING gin(sizes jsonb_path_ops)").Error; err != nil {
+		return err
+	}
+	if err := db.Exec("CREATE INDEX IF NOT EXISTS idx_products_colors_gin ON products USING gin(colors jsonb_path_ops)").Error; err != nil {
+		return err
+	}
+
+	// Price range filters on active products
+	if err := db.Exec("CREATE INDEX IF NOT EXISTS idx_products_active_price ON products(price) WHERE is_active = true").Error; err != nil {
+		return err
+	}
+	if err := db.Exec("CREATE INDEX IF NOT EXISTS idx_products_active_discounted_price ON products(discounted_price) WHERE is_active = true AND discounted_price IS NOT NULL").Error; err != nil {
+		return err
+	}
+	if err := db.Exec("CREATE INDEX IF NOT EXISTS idx_products_active_price_in_rubles ON products(price_in_rubles) WHERE is_active = true").Error; err != nil {
+		return err
+	}
+
+	// Full-text search and trigram similarity for typo tolerant search
+	if err := db.Exec("CREATE INDEX IF NOT EXISTS idx_products_search_vector ON products USING gin(search_vector)").Error; err != nil {
+		return err
+	}
+	if err := db.Exec("CREATE INDEX IF NOT EXISTS idx_products_name_trgm ON products USING gin(name gin_trgm_ops)").Error; err != nil {
+		return err
+	}
+	if err := db.Exec("CREATE INDEX IF NOT EXISTS idx_products_brand_trgm ON products USING gin(brand gin_trgm_ops)").Error; err != nil {
+		return err
+	}
+
+	// Active products of a store by last sighting, used by full store syncs
+	if err := db.Exec("CREATE INDEX IF NOT EXISTS idx_products_store_last_seen ON products(store, last_seen_at) WHERE is_active = true").Error; err != nil {
+		return err
+	}
+
+	// Due alert deliveries, polled by the alert dispatchers
+	if err := db.Exec("CREATE INDEX IF NOT EXISTS idx_alert_deliveries_due ON alert_deliveries(next_attempt_at) WHERE state = 'pending'").Error; err != nil {
+		return err
+	}
+
+	// Unique canonical URL - conflict target of the bulk upsert. It replaces the unique
+	// product URL index: URLs differing only in tracking parameters are the same product.
+	if err := db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_products_canonical_url_unique ON products(canonical_url) WHERE canonical_url <> ''").Error; err != nil {
+		return err
+	}
+	// Lookup by the retailer's own product identifier
+	if err := db.Exec("CREATE INDEX IF NOT EXISTS idx_products_store_external_id ON products(store, external_id) WHERE external_id <> ''").Error; err != nil {
+		return err
+	}
+	if err := db.Exec("DROP INDEX IF EXISTS idx_products_product_url_unique").Error; err != nil {
+		return err
+	}
+
+	// Stored images no product references any more, for cleanup
+	if err := db.Exec("CREATE INDEX IF NOT EXISTS idx_images_unreferenced ON images(created_at) WHERE ref_count = 0").Error; err != nil {
+		return err
+	}
+
+	// Due image downloads, polled by the image workers
+	if err := db.Exec("CREATE INDEX IF NOT EXISTS idx_image_jobs_due ON image_jobs(next_attempt_at) WHERE status = 'pending'").Error; err != nil {
+		return err
+	}
+
 	return nil
-}
\ No newline at end of file
+}
+
+// canonicalBackfillBatch is the number of products canonicalized per UPDATE
+const canonicalBackfillBatch = 1000
+
+// backfillCanonicalURLs fills canonical_url for products that do not have one yet.
+// Products are visited newest first; an older product whose canonical URL is already
+// taken is a duplicate and gets an empty canonical_url, so the unique index can be built.
+func backfillCanonicalURLs(db *gorm.DB) error {
+	rows, err := db.Model(&models.Product{}).Select("id, COALESCE(product_url, '')").
+		Where("canonical_url IS NULL").Order("updated_at DESC, id DESC").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type pending struct {
+		id        string
+		canonical string
+	}
+	var batch []pending
+	updated, duplicates := 0, 0
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		// Earlier batches are committed already, only this batch needs an in-memory check
+		urls := make([]string, 0, len(batch))
+		for _, p := range batch {
+			if p.canonical != "" {
+				urls = append(urls, p.canonical)
+			}
+		}
+		var stored []string
+		if err := db.Model(&models.Product{}).Where("canonical_url IN ?", urls).Pluck("canonical_url", &stored).Error; err != nil {
+			return err
+		}
+		taken := make(map[string]bool, len(stored)+len(batch))
+		for _, url := range stored {
+			taken[url] = true
+		}
+
+		values := make([]string, len(batch))
+		args := make([]interface{}, 0, 2*len(batch))
+		for i, p := range batch {
+			if p.canonical != "" && taken[p.canonical] {
+				log.Printf("[WARN] backfillCanonicalURLs: Product %s duplicates canonical URL %s, left without canonical URL", p.id, p.canonical)
+				p.canonical = ""
+				duplicates++
+			}
+			taken[p.canonical] = true
+			values[i] = "(?, ?)"
+			args = append(args, p.id, p.canonical)
+		}
+		if err := db.Exec(fmt.Sprintf(`UPDATE products AS p SET canonical_url = v.canonical_url
+			FROM (VALUES %s) AS v(id, canonical_url) WHERE p.id = v.id`, strings.Join(values, ", ")), args...).Error; err != nil {
+			return err
+		}
+		updated += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	for rows.Next() {
+		var id, productURL string
+		if err := rows.Scan(&id, &productURL); err != nil {
+			return err
+		}
+		batch = append(batch, pending{id: id, canonical: utils.CanonicalURL(productURL)})
+		if len(batch) == canonicalBackfillBatch {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	if updated > 0 {
+		log.Printf("[INFO] backfillCanonicalURLs: Stored canonical URLs of %d products, %d duplicates left without one", updated, duplicates)
+	}
+	return nil
+}
+
+// seedPricingRules stores the default price ladder when no pricing rule exists yet.
+// The ladder's bands and multipliers are for lira prices, so its rules only match
+// TRY products; products in other currencies are left without a ruble price until
+// a rule for their currency is added.
+func seedPricingRules(db *gorm.DB) error {
+	var count int64
+	if err := db.Model(&models.PricingRule{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		// Ladders seeded before the rules were scoped priced every currency as lira
+		scoped := db.Model(&models.PricingRule{}).
+			Where("name IN ? AND (currency IS NULL OR currency = '')", defaultPricingRuleNames).
+			Update("currency", "TRY")
+		if scoped.Error != nil {
+			return scoped.Error
+		}
+		if scoped.RowsAffected > 0 {
+			log.Printf("[INFO] seedPricingRules: Scoped %d default pricing rules to TRY", scoped.RowsAffected)
+		}
+		return nil
+	}
+
+	band := func(v float64) *float64 { return &v }
+	rules := []models.PricingRule{
+		{Name: defaultPricingRuleNames[0], Currency: "TRY", MinPrice: band(0), MaxPrice: band(101), Multiplier: 120, Rounding: models.RoundingNone, IsActive: true},
+		{Name: defaultPricingRuleNames[1], Currency: "TRY", MinPrice: band(101), MaxPrice: band(151), Multiplier: 100, Rounding: models.RoundingNone, IsActive: true},
+		{Name: defaultPricingRuleNames[2], Currency: "TRY", MinPrice: band(151), MaxPrice: band(201), Multiplier: 90, Rounding: models.RoundingNone, IsActive: true},
+		{Name: defaultPricingRuleNames[3], Currency: "TRY", MinPrice: band(201), MaxPrice: band(351), Multiplier: 85, Rounding: models.RoundingNone, IsActive: true},
+		{Name: defaultPricingRuleNames[4], Currency: "TRY", MinPrice: band(351), Multiplier: 80, Rounding: models.RoundingNone, IsActive: true},
+	}
+	return db.Create(&rules).Error
+}
+
+// defaultPricingRuleNames are the names of the rules seedPricingRules creates
+var defaultPricingRuleNames = []string{"Default up to 100", "Default 101-150", "Default 151-200", "Default 201-350", "Default over 350"}
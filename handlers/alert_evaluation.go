@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"product-api/models"
+	"product-api/utils"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// alertSnapshot is the part of a product an alert payload reports
+type alertSnapshot struct {
+	Price           float64  `json:"price"`
+	DiscountedPrice *float64 `json:"discountedPrice"`
+	PriceInRubles   *float64 `json:"priceInRubles"`
+	StockStatus     string   `json:"stockStatus"`
+	InStock         bool     `json:"inStock"`
+}
+
+// alertPayload is the JSON body of an alert webhook
+type alertPayload struct {
+	Event          string        `json:"event"`
+	SubscriptionID uint          `json:"subscriptionId"`
+	ProductID      string        `json:"productId"`
+	Name           string        `json:"name"`
+	Store          string        `json:"store"`
+	ProductURL     string        `json:"productUrl"`
+	Currency       string        `json:"currency"`
+	Previous       alertSnapshot `json:"previous"`
+	Current        alertSnapshot `json:"current"`
+	DropPercent    *float64      `json:"dropPercent,omitempty"`
+	OccurredAt     time.Time     `json:"occurredAt"`
+}
+
+// productChange is a stored product that an upsert made cheaper or brought back in stock
+type productChange struct {
+	previous    *models.Product
+	current     *models.Product
+	priceDrop   bool
+	dropPercent float64
+	backInStock bool
+}
+
+// queueAlerts writes an alert delivery for every subscription matching a price drop
+// or restock in an upserted batch. It runs inside the upsert transaction, so alerts
+// are queued exactly when the change is committed.
+func queueAlerts(tx *gorm.DB, batch []models.Product, replaced []*models.Product, occurredAt time.Time) error {
+	var changes []productChange
+	for i := range batch {
+		if replaced[i] == nil {
+			continue
+		}
+		if change, ok := detectChange(replaced[i], &batch[i]); ok {
+			changes = append(changes, change)
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(changes))
+	urls := make([]string, 0, len(changes))
+	for _, change := range changes {
+		ids = append(ids, change.current.ID)
+		// Subscriptions store canonical URLs; older ones may hold the URL as sent
+		if change.current.CanonicalURL != "" {
+			urls = append(urls, change.current.CanonicalURL)
+		}
+		if change.current.ProductURL != "" && change.current.ProductURL != change.current.CanonicalURL {
+			urls = append(urls, change.current.ProductURL)
+		}
+	}
+
+	var subscriptions []models.AlertSubscription
+	if err := tx.Where("is_active = ? AND (product_id IN ? OR product_url IN ?)", true, ids, urls).
+		Find(&subscriptions).Error; err != nil {
+		return fmt.Errorf("failed to look up alert subscriptions: %w", err)
+	}
+	if len(subscriptions) == 0 {
+		return nil
+	}
+
+	var deliveries []models.AlertDelivery
+	for _, change := range changes {
+		for _, subscription := range subscriptions {
+			payload, ok := alertFor(&subscription, change, occurredAt)
+			if !ok {
+				continue
+			}
+			encoded, err := json.Marshal(payload)
+			if err != nil {
+				return err
+			}
+			deliveries = append(deliveries, models.AlertDelivery{
+				SubscriptionID: subscription.ID,
+				ProductID:      change.current.ID,
+				Event:          payload.Event,
+				Payload:        encoded,
+				State:          models.AlertDeliveryPending,
+				NextAttemptAt:  occurredAt,
+			})
+		}
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	return tx.Create(&deliveries).Error
+}
+
+// detectChange compares a stored product with its replacement
+func detectChange(previous, current *models.Product) (productChange, bool) {
+	change := productChange{previous: previous, current: current}
+
+	oldPrice, newPrice := effectivePrice(previous), effectivePrice(current)
+	if previous.Currency == current.Currency && oldPrice > 0 && newPrice > 0 && roundPrice(newPrice) < roundPrice(oldPrice) {
+		change.priceDrop = true
+		change.dropPercent = roundPrice((oldPrice - newPrice) * 100 / oldPrice)
+	}
+	change.backInStock = !isInStock(previous) && isInStock(current)
+
+	return change, change.priceDrop || change.backInStock
+}
+
+// alertFor builds the webhook payload a subscription gets for a change, if any
+func alertFor(subscription *models.AlertSubscription, change productChange, occurredAt time.Time) (alertPayload, bool) {
+	product := change.current
+	matches := subscription.ProductID == product.ID ||
+		(subscription.ProductID == "" && product.CanonicalURL != "" &&
+			utils.CanonicalURL(subscription.ProductURL) == product.CanonicalURL && subscription.Store == product.Store)
+	if !matches {
+		return alertPayload{}, false
+	}
+
+	payload := alertPayload{
+		Event:          subscription.Type,
+		SubscriptionID: subscription.ID,
+		ProductID:      product.ID,
+		Name:           product.Name,
+		Store:          product.Store,
+		ProductURL:     product.ProductURL,
+		Currency:       product.Currency,
+		Previous:       snapshotOf(change.previous),
+		Current:        snapshotOf(product),
+		OccurredAt:     occurredAt,
+	}
+
+	switch subscription.Type {
+	case models.AlertPriceDrop:
+		if !change.priceDrop || change.dropPercent < subscription.MinDropPercent {
+			return alertPayload{}, false
+		}
+		if subscription.TargetPrice != nil && effectivePrice(product) > *subscription.TargetPrice {
+			return alertPayload{}, false
+		}
+		dropPercent := change.dropPercent
+		payload.DropPercent = &dropPercent
+		return payload, true
+	case models.AlertBackInStock:
+		return payload, change.backInStock
+	}
+	return alertPayload{}, false
+}
+
+// snapshotOf captures the alert relevant fields of a product
+func snapshotOf(product *models.Product) alertSnapshot {
+	return alertSnapshot{
+		Price:           product.Price,
+		DiscountedPrice: product.DiscountedPrice,
+		PriceInRubles:   product.PriceInRubles,
+		StockStatus:     product.StockStatus,
+		InStock:         isInStock(product),
+	}
+}
+
+// effectivePrice is the price a customer pays, the discounted price when there is one
+func effectivePrice(product *models.Product) float64 {
+	if product.DiscountedPrice != nil && *product.DiscountedPrice > 0 {
+		return *product.DiscountedPrice
+	}
+	return product.Price
+}
+
+// isInStock reads availability from StockStatus and, when present, the Stock object
+func isInStock(product *models.Product) bool {
+	if product.StockStatus == models.StockStatusOutOfStock {
+		return false
+	}
+	if len(product.Stock) > 0 && string(product.Stock) != "null" {
+		var stock models.Stock
+		if err := json.Unmarshal(product.Stock, &stock); err == nil && !stock.IsInStock {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"product-api/models"
+	"product-api/utils"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	// alertPollInterval is how often idle dispatchers look for due deliveries
+	alertPollInterval = 2 * time.Second
+	// alertLease is how long a claimed delivery stays hidden from other dispatchers;
+	// a dispatcher that dies mid-send gives the delivery back once it expires
+	alertLease = time.Minute
+	// maxAlertAttempts is the number of sends before a delivery is dead-lettered
+	maxAlertAttempts = 6
+	// alertRetryBase is the first retry delay, doubled after every failed attempt
+	alertRetryBase = 30 * time.Second
+	// alertRetryMax caps the retry delay
+	alertRetryMax = time.Hour
+)
+
+type AlertHandler struct {
+	DB     *gorm.DB
+	Sender *utils.WebhookSender
+}
+
+// NewAlertHandler creates a new alert handler
+func NewAlertHandler(db *gorm.DB) *AlertHandler {
+	return &AlertHandler{
+		DB:     db,
+		Sender: utils.NewWebhookSender(),
+	}
+}
+
+// CreateAlertSubscription stores a subscription. The response carries the secret
+// used to sign the webhooks, it is not returned again afterwards.
+func (h *AlertHandler) CreateAlertSubscription(c *gin.Context) {
+	var request struct {
+		models.AlertSubscription
+		Secret string `json:"secret"`
+	}
+	request.IsActive = true
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format", "details": err.Error()})
+		return
+	}
+
+	subscription := request.AlertSubscription
+	subscription.ID = 0
+	subscription.Secret = request.Secret
+	subscription.ProductURL = utils.CanonicalURL(subscription.ProductURL)
+	if reasons := subscription.Validate(); len(reasons) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alert subscription", "reasons": reasons})
+		return
+	}
+	if subscription.Secret == "" {
+		secret, err := newWebhookSecret()
+		if err != nil {
+			log.Printf("[ERROR] CreateAlertSubscription: Failed to generate secret: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create alert subscription"})
+			return
+		}
+		subscription.Secret = secret
+	}
+
+	if err := h.DB.Create(&subscription).Error; err != nil {
+		log.Printf("[ERROR] CreateAlertSubscription: Failed to store subscription: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create alert subscription"})
+		return
+	}
+
+	log.Printf("[INFO] CreateAlertSubscription: Created %s subscription %d", subscription.Type, subscription.ID)
+	c.JSON(http.StatusCreated, gin.H{
+		"subscription": subscription,
+		"secret":       subscription.Secret,
+	})
+}
+
+// GetAlertSubscriptions lists subscriptions, optionally filtered by ?productId= or ?store=
+func (h *AlertHandler) GetAlertSubscriptions(c *gin.Context) {
+	query := h.DB.Order("id")
+	if productID := c.Query("productId"); productID != "" {
+		query = query.Where("product_id = ?", productID)
+	}
+	if store := c.Query("store"); store != "" {
+		query = query.Where("store = ?", store)
+	}
+
+	var subscriptions []models.AlertSubscription
+	if err := query.Find(&subscriptions).Error; err != nil {
+		log.Printf("[ERROR] GetAlertSubscriptions: Failed to fetch subscriptions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch alert subscriptions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"subscriptions": subscriptions,
+		"count":         len(subscriptions),
+	})
+}
+
+// DeleteAlertSubscription removes a subscription; its pending deliveries are cancelled
+func (h *AlertHandler) DeleteAlertSubscription(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid subscription ID"})
+		return
+	}
+
+	result := h.DB.Delete(&models.AlertSubscription{}, id)
+	if result.Error != nil {
+		log.Printf("[ERROR] DeleteAlertSubscription: Failed to delete subscription %d: %v", id, result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete alert subscription"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert subscription not found"})
+		return
+	}
+
+	log.Printf("[INFO] DeleteAlertSubscription: Deleted subscription %d", id)
+	c.JSON(http.StatusOK, gin.H{"message": "Alert subscription deleted", "id": id})
+}
+
+// GetAlertDeadLetters lists deliveries that used up their attempts, newest first
+func (h *AlertHandler) GetAlertDeadLetters(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+
+	var deadLetters []models.AlertDeadLetter
+	if err := h.DB.Order("id DESC").Limit(limit).Find(&deadLetters).Error; err != nil {
+		log.Printf("[ERROR] GetAlertDeadLetters: Failed to fetch dead letters: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch dead letters"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deadLetters": deadLetters,
+		"count":       len(deadLetters),
+	})
+}
+
+// RetryAlertDeadLetter puts a dead-lettered delivery back in the queue with fresh attempts
+func (h *AlertHandler) RetryAlertDeadLetter(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid dead letter ID"})
+		return
+	}
+
+	var deadLetter models.AlertDeadLetter
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&deadLetter, id).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.AlertDelivery{}).Where("id = ?", deadLetter.DeliveryID).Updates(map[string]interface{}{
+			"state":           models.AlertDeliveryPending,
+			"attempts":        0,
+			"next_attempt_at": time.Now(),
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&deadLetter).Error
+	})
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Dead letter not found"})
+			return
+		}
+		log.Printf("[ERROR] RetryAlertDeadLetter: Failed to requeue dead letter %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to requeue dead letter"})
+		return
+	}
+
+	log.Printf("[INFO] RetryAlertDeadLetter: Requeued delivery %d", deadLetter.DeliveryID)
+	c.JSON(http.StatusOK, gin.H{"message": "Delivery requeued", "deliveryId": deadLetter.DeliveryID})
+}
+
+// StartAlertDispatchers launches n goroutines that send due alert deliveries
+func (h *AlertHandler) StartAlertDispatchers(n int) {
+	if n < 1 {
+		log.Printf("[WARN] StartAlertDispatchers: Alert dispatchers disabled")
+		return
+	}
+
+	for i := 0; i < n; i++ {
+		go h.alertDispatcher(i)
+	}
+	log.Printf("[INFO] StartAlertDispatchers: Started %d alert dispatchers", n)
+}
+
+// alertDispatcher claims and sends deliveries until the process exits
+func (h *AlertHandler) alertDispatcher(worker int) {
+	for {
+		sent, err := h.DispatchNextAlert(context.Background())
+		if err != nil {
+			log.Printf("[ERROR] alertDispatcher %d: %v", worker, err)
+		}
+		if !sent {
+			time.Sleep(alertPollInterval)
+		}
+	}
+}
+
+// DispatchNextAlert sends the oldest due delivery, if any, and records the outcome.
+// It reports whether a delivery was attempted.
+func (h *AlertHandler) DispatchNextAlert(ctx context.Context) (bool, error) {
+	delivery, err := h.claimAlertDelivery()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim alert delivery: %w", err)
+	}
+	if delivery == nil {
+		return false, nil
+	}
+
+	var subscription models.AlertSubscription
+	if err := h.DB.First(&subscription, delivery.SubscriptionID).Error; err != nil {
+		if err != gorm.ErrRecordNotFound {
+			return true, fmt.Errorf("failed to load subscription %d: %w", delivery.SubscriptionID, err)
+		}
+		// The subscription was deleted after the alert was queued
+		return true, h.DB.Model(delivery).Updates(map[string]interface{}{
+			"state":      models.AlertDeliveryCancelled,
+			"last_error": "subscription deleted",
+		}).Error
+	}
+
+	statusCode, sendErr := h.Sender.Send(ctx, subscription.WebhookURL, subscription.Secret,
+		delivery.Event, strconv.FormatUint(uint64(delivery.ID), 10), delivery.Payload)
+	if sendErr == nil {
+		log.Printf("[SUCCESS] DispatchNextAlert: Delivered %s alert %d to subscription %d", delivery.Event, delivery.ID, subscription.ID)
+		return true, h.DB.Model(delivery).Updates(map[string]interface{}{
+			"state":            models.AlertDeliveryDelivered,
+			"last_status_code": statusCode,
+			"last_error":       "",
+			"delivered_at":     time.Now(),
+		}).Error
+	}
+
+	log.Printf("[WARN] DispatchNextAlert: Attempt %d of alert %d failed: %v", delivery.Attempts, delivery.ID, sendErr)
+	if delivery.Attempts < maxAlertAttempts {
+		return true, h.DB.Model(delivery).Updates(map[string]interface{}{
+			"last_status_code": statusCode,
+			"last_error":       sendErr.Error(),
+			"next_attempt_at":  time.Now().Add(alertRetryDelay(delivery.Attempts)),
+		}).Error
+	}
+
+	log.Printf("[ERROR] DispatchNextAlert: Alert %d dead-lettered after %d attempts", delivery.ID, delivery.Attempts)
+	return true, h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(delivery).Updates(map[string]interface{}{
+			"state":            models.AlertDeliveryFailed,
+			"last_status_code": statusCode,
+			"last_error":       sendErr.Error(),
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.AlertDeadLetter{
+			DeliveryID:     delivery.ID,
+			SubscriptionID: subscription.ID,
+			WebhookURL:     subscription.WebhookURL,
+			Event:          delivery.Event,
+			Payload:        delivery.Payload,
+			Attempts:       delivery.Attempts,
+			LastStatusCode: statusCode,
+			LastError:      sendErr.Error(),
+		}).Error
+	})
+}
+
+// claimAlertDelivery leases the oldest due delivery and counts the attempt.
+// SKIP LOCKED lets several dispatchers and replicas poll the same table.
+func (h *AlertHandler) claimAlertDelivery() (*models.AlertDelivery, error) {
+	var delivery models.AlertDelivery
+	err := h.DB.Raw(`UPDATE alert_deliveries
+		SET attempts = attempts + 1, next_attempt_at = ?, updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM alert_deliveries
+			WHERE state = ? AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING *`, time.Now().Add(alertLease), models.AlertDeliveryPending).Scan(&delivery).Error
+	if err != nil {
+		return nil, err
+	}
+	if delivery.ID == 0 {
+		return nil, nil
+	}
+	return &delivery, nil
+}
+
+// alertRetryDelay is the exponential backoff before the next attempt
+func alertRetryDelay(attempts int) time.Duration {
+	delay := alertRetryBase
+	for i := 1; i < attempts && delay < alertRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > alertRetryMax {
+		delay = alertRetryMax
+	}
+	return delay
+}
+
+// newWebhookSecret returns a random signing secret
+func newWebhookSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
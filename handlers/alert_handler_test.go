@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"product-api/models"
+	"product-api/utils"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testWebhookSecret = "test-secret"
+
+// alertReceiver is a webhook endpoint answering status and counting the requests
+// whose signature verifies
+type alertReceiver struct {
+	*httptest.Server
+	verified atomic.Int32
+}
+
+func newAlertReceiver(t *testing.T, status int) *alertReceiver {
+	receiver := &alertReceiver{}
+	receiver.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if utils.VerifyWebhook(testWebhookSecret, r.Header.Get(utils.WebhookTimestampHeader), body, r.Header.Get(utils.WebhookSignatureHeader)) {
+			receiver.verified.Add(1)
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(receiver.Close)
+	return receiver
+}
+
+// newAlertTestHandler serves a delivery with the given attempts, already counting
+// the current one, to the subscription of receiver
+func newAlertTestHandler(t *testing.T, receiver *alertReceiver, attempts int) (*AlertHandler, *fakeDB) {
+	db, fake := newFakeDB(t, func(query string, args []interface{}) ([]string, [][]driver.Value) {
+		switch {
+		case strings.Contains(query, "UPDATE alert_deliveries"):
+			return []string{"id", "subscription_id", "product_id", "event", "payload", "state", "attempts", "next_attempt_at"},
+				[][]driver.Value{{int64(7), int64(3), "p-1", models.AlertPriceDrop, []byte(`{"event":"price_drop"}`),
+					models.AlertDeliveryPending, int64(attempts), time.Now().Add(alertLease)}}
+		case strings.Contains(query, `FROM "alert_subscriptions"`):
+			return []string{"id", "product_id", "type", "webhook_url", "secret", "is_active"},
+				[][]driver.Value{{int64(3), "p-1", models.AlertPriceDrop, receiver.URL, testWebhookSecret, true}}
+		}
+		return nil, nil
+	})
+	h := NewAlertHandler(db)
+	h.Sender.Client = receiver.Client()
+	return h, fake
+}
+
+// deliveryUpdate returns the columns set on the delivery by its last UPDATE
+func deliveryUpdate(t *testing.T, fake *fakeDB) map[string]interface{} {
+	t.Helper()
+	updates := fake.Find(`UPDATE "alert_deliveries" SET`)
+	if len(updates) == 0 {
+		t.Fatal("the delivery was not updated")
+	}
+	return updates[len(updates)-1].Set()
+}
+
+func TestDispatchNextAlertDelivers(t *testing.T) {
+	receiver := newAlertReceiver(t, http.StatusOK)
+	h, fake := newAlertTestHandler(t, receiver, 1)
+
+	sent, err := h.DispatchNextAlert(context.Background())
+	if err != nil || !sent {
+		t.Fatalf("got sent %v, err %v", sent, err)
+	}
+	if receiver.verified.Load() != 1 {
+		t.Fatal("the receiver did not get a correctly signed webhook")
+	}
+	if set := deliveryUpdate(t, fake); set["state"] != models.AlertDeliveryDelivered {
+		t.Fatalf("delivery updated with %v", set)
+	}
+}
+
+func TestDispatchNextAlertRetriesWithBackoff(t *testing.T) {
+	receiver := newAlertReceiver(t, http.StatusBadGateway)
+	for attempts := 1; attempts < maxAlertAttempts; attempts++ {
+		h, fake := newAlertTestHandler(t, receiver, attempts)
+
+		before := time.Now()
+		sent, err := h.DispatchNextAlert(context.Background())
+		if err != nil || !sent {
+			t.Fatalf("attempt %d: got sent %v, err %v", attempts, sent, err)
+		}
+
+		set := deliveryUpdate(t, fake)
+		if _, failed := set["state"]; failed {
+			t.Fatalf("attempt %d: delivery left the queue: %v", attempts, set)
+		}
+		if set["last_status_code"] != int64(http.StatusBadGateway) && set["last_status_code"] != http.StatusBadGateway {
+			t.Fatalf("attempt %d: last_status_code %v", attempts, set["last_status_code"])
+		}
+		next, ok := set["next_attempt_at"].(time.Time)
+		if !ok {
+			t.Fatalf("attempt %d: next_attempt_at not set: %v", attempts, set)
+		}
+		delay := next.Sub(before)
+		if want := alertRetryDelay(attempts); delay < want || delay > want+5*time.Second {
+			t.Fatalf("attempt %d: retried after %v, want %v", attempts, delay, want)
+		}
+		if len(fake.Find(`INSERT INTO "alert_dead_letters"`)) != 0 {
+			t.Fatalf("attempt %d: dead-lettered too early", attempts)
+		}
+	}
+
+	if alertRetryDelay(1) != alertRetryBase || alertRetryDelay(2) != 2*alertRetryBase || alertRetryDelay(100) != alertRetryMax {
+		t.Fatalf("unexpected backoff: %v, %v, %v", alertRetryDelay(1), alertRetryDelay(2), alertRetryDelay(100))
+	}
+}
+
+func TestDispatchNextAlertDeadLetters(t *testing.T) {
+	receiver := newAlertReceiver(t, http.StatusInternalServerError)
+	h, fake := newAlertTestHandler(t, receiver, maxAlertAttempts)
+
+	sent, err := h.DispatchNextAlert(context.Background())
+	if err != nil || !sent {
+		t.Fatalf("got sent %v, err %v", sent, err)
+	}
+	if receiver.verified.Load() != 1 {
+		t.Fatal("the last attempt was not sent")
+	}
+	if set := deliveryUpdate(t, fake); set["state"] != models.AlertDeliveryFailed {
+		t.Fatalf("delivery updated with %v", set)
+	}
+	deadLetters := fake.Find(`INSERT INTO "alert_dead_letters"`)
+	if len(deadLetters) != 1 {
+		t.Fatalf("%d dead letters, want 1", len(deadLetters))
+	}
+	if !containsArg(deadLetters[0].Args, receiver.URL) || !containsArg(deadLetters[0].Args, int64(maxAlertAttempts), maxAlertAttempts) {
+		t.Fatalf("dead letter written with %v", deadLetters[0].Args)
+	}
+}
+
+// containsArg reports whether args holds any of values
+func containsArg(args []interface{}, values ...interface{}) bool {
+	for _, arg := range args {
+		for _, value := range values {
+			if arg == value {
+				return true
+			}
+		}
+	}
+	return false
+}
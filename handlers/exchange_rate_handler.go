@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"product-api/models"
+	"product-api/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// exchangeRateDateLayout is the format of effective dates in uploads and queries
+const exchangeRateDateLayout = "2006-01-02"
+
+type ExchangeRateHandler struct {
+	DB       *gorm.DB
+	Currency *utils.CurrencyConverter
+}
+
+// NewExchangeRateHandler creates a new exchange rate handler sharing the converter used by ingestion
+func NewExchangeRateHandler(db *gorm.DB, currency *utils.CurrencyConverter) *ExchangeRateHandler {
+	return &ExchangeRateHandler{
+		DB:       db,
+		Currency: currency,
+	}
+}
+
+// exchangeRateInput is one uploaded rate; effectiveDate is a YYYY-MM-DD date
+type exchangeRateInput struct {
+	BaseCurrency  string  `json:"baseCurrency"`
+	QuoteCurrency string  `json:"quoteCurrency"`
+	Rate          float64 `json:"rate"`
+	EffectiveDate string  `json:"effectiveDate"`
+	Source        string  `json:"source"`
+}
+
+// GetExchangeRates lists exchange rates, newest first.
+// ?base= and ?quote= filter the pair, ?date=YYYY-MM-DD returns only the rates in effect on that day.
+func (h *ExchangeRateHandler) GetExchangeRates(c *gin.Context) {
+	query := h.DB.Model(&models.ExchangeRate{})
+	if base := strings.ToUpper(c.Query("base")); base != "" {
+		query = query.Where("base_currency = ?", base)
+	}
+	if quote := strings.ToUpper(c.Query("quote")); quote != "" {
+		query = query.Where("quote_currency = ?", quote)
+	}
+
+	if dateStr := c.Query("date"); dateStr != "" {
+		date, err := time.Parse(exchangeRateDateLayout, dateStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "date must be formatted as YYYY-MM-DD"})
+			return
+		}
+		query = query.Select("DISTINCT ON (base_currency, quote_currency) *").
+			Where("effective_date <= ?", date).
+			Order("base_currency, quote_currency, effective_date DESC")
+	} else {
+		query = query.Order("effective_date DESC, base_currency, quote_currency")
+	}
+
+	var rates []models.ExchangeRate
+	if err := query.Find(&rates).Error; err != nil {
+		log.Printf("[ERROR] GetExchangeRates: Failed to fetch exchange rates: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch exchange rates"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"rates": rates,
+		"count": len(rates),
+	})
+}
+
+// UploadExchangeRates stores a list of exchange rates sent as a JSON array or as CSV
+// (Content-Type: text/csv, header baseCurrency,quoteCurrency,rate,effectiveDate[,source]).
+// A rate for a pair and date that already exists is replaced.
+func (h *ExchangeRateHandler) UploadExchangeRates(c *gin.Context) {
+	log.Printf("[DEBUG] UploadExchangeRates: Received POST request from %s", c.ClientIP())
+
+	var inputs []exchangeRateInput
+	var err error
+	if strings.HasPrefix(c.ContentType(), "text/csv") {
+		inputs, err = parseExchangeRatesCSV(c.Request.Body)
+	} else {
+		err = json.NewDecoder(c.Request.Body).Decode(&inputs)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid exchange rate upload", "details": err.Error()})
+		return
+	}
+	if len(inputs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No exchange rates provided"})
+		return
+	}
+
+	rates := make([]models.ExchangeRate, 0, len(inputs))
+	// A pair and date listed twice keeps its last rate, one statement cannot upsert a row twice
+	positions := make(map[string]int, len(inputs))
+	var rejected []models.ItemError
+	for i, input := range inputs {
+		rate, reasons := input.toExchangeRate()
+		if len(reasons) > 0 {
+			rejected = append(rejected, models.ItemError{Index: i, Reasons: reasons})
+			continue
+		}
+		key := rate.BaseCurrency + "/" + rate.QuoteCurrency + "/" + rate.EffectiveDate.Format(exchangeRateDateLayout)
+		if pos, ok := positions[key]; ok {
+			rates[pos] = rate
+			continue
+		}
+		positions[key] = len(rates)
+		rates = append(rates, rate)
+	}
+	if len(rejected) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    "Invalid exchange rates, nothing was stored",
+			"rejected": rejected,
+		})
+		return
+	}
+
+	if err := h.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "base_currency"}, {Name: "quote_currency"}, {Name: "effective_date"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rate", "source", "updated_at"}),
+	}).CreateInBatches(&rates, 500).Error; err != nil {
+		log.Printf("[ERROR] UploadExchangeRates: Failed to store exchange rates: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store exchange rates"})
+		return
+	}
+
+	if err := h.Currency.Reload(); err != nil {
+		log.Printf("[WARN] UploadExchangeRates: Failed to reload exchange rates: %v", err)
+	}
+
+	log.Printf("[SUCCESS] UploadExchangeRates: Stored %d exchange rates", len(rates))
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Exchange rates stored",
+		"count":   len(rates),
+	})
+}
+
+// toExchangeRate normalises an uploaded rate and returns the reasons it is invalid
+func (input exchangeRateInput) toExchangeRate() (models.ExchangeRate, []string) {
+	rate := models.ExchangeRate{
+		BaseCurrency:  strings.ToUpper(strings.TrimSpace(input.BaseCurrency)),
+		QuoteCurrency: strings.ToUpper(strings.TrimSpace(input.QuoteCurrency)),
+		Rate:          input.Rate,
+		Source:        input.Source,
+	}
+
+	if input.EffectiveDate != "" {
+		date, err := time.Parse(exchangeRateDateLayout, strings.TrimSpace(input.EffectiveDate))
+		if err != nil {
+			return rate, []string{fmt.Sprintf("effectiveDate %q must be formatted as YYYY-MM-DD", input.EffectiveDate)}
+		}
+		rate.EffectiveDate = date
+	}
+
+	return rate, rate.Validate()
+}
+
+// parseExchangeRatesCSV reads rates from CSV with a header row naming the columns
+func parseExchangeRatesCSV(r io.Reader) ([]exchangeRateInput, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, required := range []string{"baseCurrency", "quoteCurrency", "rate", "effectiveDate"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV header is missing column %q", required)
+		}
+	}
+
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var inputs []exchangeRateInput
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return inputs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		rate, err := strconv.ParseFloat(field(record, "rate"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: rate %q is not a number", line, field(record, "rate"))
+		}
+		inputs = append(inputs, exchangeRateInput{
+			BaseCurrency:  field(record, "baseCurrency"),
+			QuoteCurrency: field(record, "quoteCurrency"),
+			Rate:          rate,
+			EffectiveDate: field(record, "effectiveDate"),
+			Source:        field(record, "source"),
+		})
+	}
+}
+
+// displayCurrency reads the optional ?currency= parameter of read endpoints.
+// It writes the error response itself and returns ok=false for an unknown code.
+func displayCurrency(c *gin.Context) (string, bool) {
+	currency := strings.ToUpper(c.Query("currency"))
+	if currency != "" && !models.IsCurrencyCode(currency) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("currency %q is not an ISO 4217 code", currency)})
+		return "", false
+	}
+	return currency, true
+}
+
+// convertProducts rewrites Price, DiscountedPrice and Currency of products into currency.
+// Products without a known rate keep their source currency and are flagged converted:false.
+func (h *ProductHandler) convertProducts(products []models.Product, currency string) {
+	if currency == "" {
+		return
+	}
+
+	unconverted := 0
+	for i := range products {
+		if !h.convertProduct(&products[i], currency) {
+			unconverted++
+		}
+	}
+
+	if unconverted > 0 {
+		log.Printf("[WARN] convertProducts: No exchange rate to %s for %d of %d products, kept their source currency",
+			currency, unconverted, len(products))
+	}
+}
+
+// convertProduct rewrites the prices of one product into currency and reports
+// whether a rate was known, in the product's Converted flag as well
+func (h *ProductHandler) convertProduct(product *models.Product, currency string) bool {
+	converted, ok := h.Currency.ConvertProduct(product, currency)
+	product.Converted = &ok
+	if !ok {
+		return false
+	}
+	product.Price = converted.Price
+	product.DiscountedPrice = converted.DiscountedPrice
+	product.Currency = currency
+	return true
+}
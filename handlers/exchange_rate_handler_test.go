@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"bufio"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"product-api/models"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newConvertingProductHandler serves a TRY to RUB rate and no rate for USD
+func newConvertingProductHandler(t *testing.T) (*ProductHandler, *fakeDB) {
+	db, fake := newFakeDB(t, func(query string, args []interface{}) ([]string, [][]driver.Value) {
+		if strings.Contains(query, "FROM exchange_rates") {
+			return []string{"base_currency", "quote_currency", "rate"}, [][]driver.Value{{"TRY", "RUB", 2.5}}
+		}
+		return nil, nil
+	})
+	return newTestProductHandler(db), fake
+}
+
+// assertConverted checks the prices and Converted flag of a product after ?currency=RUB
+func assertConverted(t *testing.T, product models.Product, currency string, price float64, converted bool) {
+	t.Helper()
+	if product.Converted == nil || *product.Converted != converted {
+		t.Fatalf("product %s converted flag %v, want %v", product.ID, product.Converted, converted)
+	}
+	if product.Currency != currency || product.Price != price {
+		t.Fatalf("product %s priced %v %s, want %v %s", product.ID, product.Price, product.Currency, price, currency)
+	}
+}
+
+func TestConvertProductsFlagsProductsWithoutRate(t *testing.T) {
+	h, _ := newConvertingProductHandler(t)
+	discounted := 80.0
+	products := []models.Product{
+		{ID: "p-1", Currency: "TRY", Price: 100, DiscountedPrice: &discounted},
+		{ID: "p-2", Currency: "USD", Price: 10},
+	}
+
+	h.convertProducts(products, "RUB")
+	assertConverted(t, products[0], "RUB", 250, true)
+	if *products[0].DiscountedPrice != 200 {
+		t.Fatalf("discounted price %v, want 200", *products[0].DiscountedPrice)
+	}
+	assertConverted(t, products[1], "USD", 10, false)
+
+	// Without ?currency= the flag is left out of the response
+	products = []models.Product{{ID: "p-3", Currency: "TRY", Price: 100}}
+	h.convertProducts(products, "")
+	encoded, _ := json.Marshal(products[0])
+	if products[0].Converted != nil || strings.Contains(string(encoded), `"converted"`) {
+		t.Fatalf("unconverted response carries a converted flag: %s", encoded)
+	}
+}
+
+func TestGetProductsByStoreOrAllFlagsProductsWithoutRate(t *testing.T) {
+	h, fake := newConvertingProductHandler(t)
+	fake.Stream(func(query string, args []interface{}) ([]string, func([]driver.Value) error) {
+		if !strings.Contains(query, `FROM "products"`) {
+			return nil, nil
+		}
+		rows := [][]driver.Value{{"p-1", "TRY", 100.0}, {"p-2", "USD", 10.0}}
+		return []string{"id", "currency", "price"}, func(dest []driver.Value) error {
+			if len(rows) == 0 {
+				return io.EOF
+			}
+			copy(dest, rows[0])
+			rows = rows[1:]
+			return nil
+		}
+	})
+
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/stock/products?format=ndjson&currency=rub", nil)
+	h.GetProductsByStoreOrAll(c)
+
+	var products []models.Product
+	scanner := bufio.NewScanner(recorder.Body)
+	for scanner.Scan() {
+		var product models.Product
+		if err := json.Unmarshal(scanner.Bytes(), &product); err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+		products = append(products, product)
+	}
+	if len(products) != 2 {
+		t.Fatalf("streamed %d products, want 2: %s", len(products), recorder.Body.String())
+	}
+	assertConverted(t, products[0], "RUB", 250, true)
+	assertConverted(t, products[1], "USD", 10, false)
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"product-api/models"
+	"strconv"
+)
+
+// exportFlushEvery is the number of products written between two flushes to the client
+const exportFlushEvery = 500
+
+// exportColumns are the product columns streamed by the export, everything but the search vector
+const exportColumns = "id, name, brand, price, currency, price_in_rubles, discounted_price, discounted_price_in_rubles, converted_prices, " +
+	"description, images, sizes, colors, product_url, canonical_url, external_id, store, category, processed_at, is_active, stock_status, stock, " +
+	"created_at, updated_at, last_seen_at, deactivated_at, deactivation_reason, original_images"
+
+// Export formats
+const (
+	exportJSON   = "json"
+	exportNDJSON = "ndjson"
+)
+
+// writeProductStream writes the products returned by next to w one at a time, so
+// memory use does not depend on how many products there are. next reports false
+// once the products are exhausted. The JSON format keeps the shape of the old
+// buffered response, {"products": [...], "count": N}; a failure after the first byte
+// is reported in an "error" field. NDJSON writes one product per line and reports a
+// failure as a final {"error": ...} line.
+func writeProductStream(w io.Writer, flush func(), format string, next func(*models.Product) (bool, error)) (int, error) {
+	buffered := bufio.NewWriterSize(w, 32<<10)
+	encoder := json.NewEncoder(buffered)
+	flushAll := func() {
+		buffered.Flush()
+		flush()
+	}
+
+	if format == exportJSON {
+		buffered.WriteString(`{"products":[`)
+	}
+
+	count := 0
+	var product models.Product
+	var streamErr error
+	for {
+		product = models.Product{}
+		more, err := next(&product)
+		if err != nil {
+			streamErr = err
+			break
+		}
+		if !more {
+			break
+		}
+
+		if format == exportJSON && count > 0 {
+			buffered.WriteByte(',')
+		}
+		// Encode appends a newline, which doubles as the NDJSON line separator
+		if err := encoder.Encode(&product); err != nil {
+			streamErr = err
+			break
+		}
+		count++
+
+		if count%exportFlushEvery == 0 {
+			flushAll()
+		}
+	}
+
+	switch format {
+	case exportJSON:
+		buffered.WriteString(`],"count":` + strconv.Itoa(count))
+		if streamErr != nil {
+			buffered.WriteString(`,"error":`)
+			encoder.Encode(streamErr.Error())
+		}
+		buffered.WriteString("}")
+	case exportNDJSON:
+		if streamErr != nil {
+			encoder.Encode(map[string]string{"error": streamErr.Error()})
+		}
+	}
+
+	flushAll()
+	return count, streamErr
+}
@@ -0,0 +1,414 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"product-api/models"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/datatypes"
+)
+
+// syntheticProducts returns a next func yielding n generated products, failing with
+// err instead of the product at index failAt when failAt >= 0. sample runs every
+// sampleEvery products.
+func syntheticProducts(n, failAt int, err error, sampleEvery int, sample func()) func(*models.Product) (bool, error) {
+	i := 0
+	return func(product *models.Product) (bool, error) {
+		if i == failAt {
+			return false, err
+		}
+		if i >= n {
+			return false, nil
+		}
+		if sample != nil && sampleEvery > 0 && i%sampleEvery == 0 {
+			sample()
+		}
+		product.ID = fmt.Sprintf("product-%d", i)
+		product.Name = fmt.Sprintf("Ürün %d - Premium Kalite", i)
+		product.Brand = "Zara"
+		product.Price = float64(i%1000) + 0.99
+		product.Currency = "TRY"
+		product.Store = "zara"
+		product.ProductURL = fmt.Sprintf("https://zara.com/p-%d", i)
+		product.Images = datatypes.JSON(fmt.Sprintf(`["https://static.zara.net/%d.jpg"]`, i))
+		product.IsActive = true
+		i++
+		return true, nil
+	}
+}
+
+// retainedHeap collects garbage and returns the heap still in use
+func retainedHeap() uint64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapInuse
+}
+
+// streamRetainedHeap streams n products into io.Discard and returns the largest heap
+// growth retained while streaming
+func streamRetainedHeap(t *testing.T, n int, format string) uint64 {
+	t.Helper()
+	base := retainedHeap()
+	var peak uint64
+	sample := func() {
+		if inUse := retainedHeap(); inUse > base && inUse-base > peak {
+			peak = inUse - base
+		}
+	}
+
+	count, err := writeProductStream(io.Discard, func() {}, format, syntheticProducts(n, -1, nil, n/10, sample))
+	if err != nil {
+		t.Fatalf("stream failed: %v", err)
+	}
+	if count != n {
+		t.Fatalf("streamed %d products, want %d", count, n)
+	}
+	sample()
+	return peak
+}
+
+func TestWriteProductStreamConstantMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("streams a million products")
+	}
+	for _, format := range []string{exportJSON, exportNDJSON} {
+		t.Run(format, func(t *testing.T) {
+			small := streamRetainedHeap(t, 10_000, format)
+			large := streamRetainedHeap(t, 1_000_000, format)
+			t.Logf("retained heap growth: %d bytes for 10k products, %d bytes for 1M", small, large)
+
+			// A buffered export of 1M products would retain hundreds of MB; streaming
+			// keeps the same few buffers whatever the row count
+			const slack = 2 << 20
+			if large > small+slack {
+				t.Fatalf("heap grew by %d bytes for 1M products against %d for 10k", large, small)
+			}
+		})
+	}
+}
+
+func TestWriteProductStreamJSON(t *testing.T) {
+	var out bytes.Buffer
+	flushes := 0
+	count, err := writeProductStream(&out, func() { flushes++ }, exportJSON, syntheticProducts(3, -1, nil, 0, nil))
+	if err != nil || count != 3 {
+		t.Fatalf("got count %d, err %v", count, err)
+	}
+	if flushes == 0 {
+		t.Fatal("the stream was never flushed")
+	}
+
+	var body struct {
+		Products []models.Product `json:"products"`
+		Count    int              `json:"count"`
+		Error    *string          `json:"error"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &body); err != nil {
+		t.Fatalf("invalid JSON %q: %v", out.String(), err)
+	}
+	if body.Count != 3 || len(body.Products) != 3 || body.Error != nil {
+		t.Fatalf("unexpected body %+v", body)
+	}
+	for i, product := range body.Products {
+		if want := fmt.Sprintf("product-%d", i); product.ID != want {
+			t.Fatalf("product %d has ID %q, want %q", i, product.ID, want)
+		}
+	}
+}
+
+func TestWriteProductStreamJSONEmpty(t *testing.T) {
+	var out bytes.Buffer
+	if _, err := writeProductStream(&out, func() {}, exportJSON, syntheticProducts(0, -1, nil, 0, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != `{"products":[],"count":0}` {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestWriteProductStreamNDJSON(t *testing.T) {
+	var out bytes.Buffer
+	count, err := writeProductStream(&out, func() {}, exportNDJSON, syntheticProducts(3, -1, nil, 0, nil))
+	if err != nil || count != 3 {
+		t.Fatalf("got count %d, err %v", count, err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want 3: %q", len(lines), out.String())
+	}
+	for i, line := range lines {
+		var product models.Product
+		if err := json.Unmarshal([]byte(line), &product); err != nil {
+			t.Fatalf("line %d is not a product: %v", i+1, err)
+		}
+		if want := fmt.Sprintf("product-%d", i); product.ID != want {
+			t.Fatalf("line %d has ID %q, want %q", i+1, product.ID, want)
+		}
+	}
+}
+
+func TestWriteProductStreamErrorAfterRows(t *testing.T) {
+	failure := errors.New("connection reset")
+
+	t.Run(exportJSON, func(t *testing.T) {
+		var out bytes.Buffer
+		count, err := writeProductStream(&out, func() {}, exportJSON, syntheticProducts(5, 2, failure, 0, nil))
+		if !errors.Is(err, failure) || count != 2 {
+			t.Fatalf("got count %d, err %v", count, err)
+		}
+		var body struct {
+			Products []models.Product `json:"products"`
+			Count    int              `json:"count"`
+			Error    string           `json:"error"`
+		}
+		if err := json.Unmarshal(out.Bytes(), &body); err != nil {
+			t.Fatalf("invalid JSON %q: %v", out.String(), err)
+		}
+		if len(body.Products) != 2 || body.Count != 2 || body.Error != failure.Error() {
+			t.Fatalf("unexpected body %+v", body)
+		}
+	})
+
+	t.Run(exportNDJSON, func(t *testing.T) {
+		var out bytes.Buffer
+		count, err := writeProductStream(&out, func() {}, exportNDJSON, syntheticProducts(5, 2, failure, 0, nil))
+		if !errors.Is(err, failure) || count != 2 {
+			t.Fatalf("got count %d, err %v", count, err)
+		}
+		scanner := bufio.NewScanner(&out)
+		var lines []string
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		if len(lines) != 3 {
+			t.Fatalf("got %d lines, want 2 products and an error: %q", len(lines), lines)
+		}
+		var product models.Product
+		if err := json.Unmarshal([]byte(lines[1]), &product); err != nil || product.ID != "product-1" {
+			t.Fatalf("line 2 is not the second product: %q", lines[1])
+		}
+		var last map[string]string
+		if err := json.Unmarshal([]byte(lines[2]), &last); err != nil || last["error"] != failure.Error() {
+			t.Fatalf("last line is not the error: %q", lines[2])
+		}
+	})
+}
+
+// productRowColumns are the columns productRows fills in, a subset of exportColumns
+var productRowColumns = []string{"id", "name", "brand", "price", "currency", "images", "product_url", "store", "is_active"}
+
+// productRows streams n product rows of the products table, failing with err instead
+// of the row at index failAt when failAt >= 0. sample runs every sampleEvery rows.
+func productRows(n, failAt int, err error, sampleEvery int, sample func()) fakeStreamer {
+	return func(query string, args []interface{}) ([]string, func([]driver.Value) error) {
+		if !strings.Contains(query, `FROM "products"`) {
+			return nil, nil
+		}
+		i := 0
+		return productRowColumns, func(dest []driver.Value) error {
+			if i == failAt {
+				return err
+			}
+			if i >= n {
+				return io.EOF
+			}
+			if sample != nil && sampleEvery > 0 && i%sampleEvery == 0 {
+				sample()
+			}
+			dest[0] = fmt.Sprintf("product-%d", i)
+			dest[1] = fmt.Sprintf("Ürün %d - Premium Kalite", i)
+			dest[2] = "Zara"
+			dest[3] = float64(i%1000) + 0.99
+			dest[4] = "TRY"
+			dest[5] = []byte(fmt.Sprintf(`["https://static.zara.net/%d.jpg"]`, i))
+			dest[6] = fmt.Sprintf("https://zara.com/p-%d", i)
+			dest[7] = "zara"
+			dest[8] = true
+			i++
+			return nil
+		}
+	}
+}
+
+// exportResponse is a response writer keeping only the first and last bytes of the
+// body, so exports of any size can be checked without buffering them
+type exportResponse struct {
+	header http.Header
+	status int
+	size   int64
+	head   []byte
+	tail   []byte
+}
+
+const exportResponseKeep = 1024
+
+func newExportResponse() *exportResponse {
+	return &exportResponse{header: make(http.Header), tail: make([]byte, 0, exportResponseKeep)}
+}
+
+func (r *exportResponse) Header() http.Header { return r.header }
+
+func (r *exportResponse) WriteHeader(status int) { r.status = status }
+
+func (r *exportResponse) Flush() {}
+
+func (r *exportResponse) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.size += int64(len(p))
+	if room := exportResponseKeep - len(r.head); room > 0 {
+		r.head = append(r.head, p[:min(room, len(p))]...)
+	}
+	if len(p) >= exportResponseKeep {
+		r.tail = append(r.tail[:0], p[len(p)-exportResponseKeep:]...)
+	} else {
+		if drop := len(r.tail) + len(p) - exportResponseKeep; drop > 0 {
+			r.tail = append(r.tail[:0], r.tail[drop:]...)
+		}
+		r.tail = append(r.tail, p...)
+	}
+	return len(p), nil
+}
+
+// getProducts runs GetProductsByStoreOrAll for query against a fake database
+// streaming rows, writing the response to w
+func getProducts(t *testing.T, w http.ResponseWriter, query string, rows fakeStreamer) *fakeDB {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	db, fake := newFakeDB(t, nil)
+	fake.Stream(rows)
+	h := newTestProductHandler(db)
+
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/stock/products?"+query, nil)
+	h.GetProductsByStoreOrAll(c)
+	c.Writer.WriteHeaderNow()
+	return fake
+}
+
+func TestGetProductsByStoreOrAllStreamsRows(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	fake := getProducts(t, recorder, "store=zara", productRows(2500, -1, nil, 0, nil))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var body struct {
+		Products []models.Product `json:"products"`
+		Count    int              `json:"count"`
+		Error    *string          `json:"error"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if body.Count != 2500 || len(body.Products) != 2500 || body.Error != nil {
+		t.Fatalf("got %d products, count %d, error %v", len(body.Products), body.Count, body.Error)
+	}
+	for _, i := range []int{0, 1234, 2499} {
+		if want := fmt.Sprintf("product-%d", i); body.Products[i].ID != want || body.Products[i].Store != "zara" {
+			t.Fatalf("product %d is %+v, want %s", i, body.Products[i], want)
+		}
+	}
+
+	selects := fake.Find(`FROM "products"`)
+	if len(selects) != 1 {
+		t.Fatalf("%d product queries, want a single streamed one", len(selects))
+	}
+	if !containsArg(selects[0].Args, "zara") || !strings.Contains(selects[0].Query, "ORDER BY") {
+		t.Fatalf("products queried with %s %v", selects[0].Query, selects[0].Args)
+	}
+}
+
+func TestGetProductsByStoreOrAllErrorAfterRows(t *testing.T) {
+	failure := errors.New("connection reset")
+
+	t.Run(exportJSON, func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		getProducts(t, recorder, "", productRows(10, 3, failure, 0, nil))
+
+		var body struct {
+			Products []models.Product `json:"products"`
+			Count    int              `json:"count"`
+			Error    string           `json:"error"`
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+			t.Fatalf("invalid JSON %q: %v", recorder.Body.String(), err)
+		}
+		if len(body.Products) != 3 || body.Count != 3 || body.Error != failure.Error() {
+			t.Fatalf("got %d products, count %d, error %q", len(body.Products), body.Count, body.Error)
+		}
+	})
+
+	t.Run(exportNDJSON, func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		getProducts(t, recorder, "format=ndjson", productRows(10, 3, failure, 0, nil))
+
+		if contentType := recorder.Header().Get("Content-Type"); contentType != "application/x-ndjson" {
+			t.Fatalf("Content-Type %q", contentType)
+		}
+		lines := strings.Split(strings.TrimSuffix(recorder.Body.String(), "\n"), "\n")
+		if len(lines) != 4 {
+			t.Fatalf("got %d lines, want 3 products and an error: %q", len(lines), lines)
+		}
+		var last map[string]string
+		if err := json.Unmarshal([]byte(lines[3]), &last); err != nil || last["error"] != failure.Error() {
+			t.Fatalf("last line is not the error: %q", lines[3])
+		}
+	})
+}
+
+// handlerRetainedHeap exports n streamed rows through the handler and returns the
+// largest heap growth retained while streaming, with the response
+func handlerRetainedHeap(t *testing.T, n int) (uint64, *exportResponse) {
+	t.Helper()
+	base := retainedHeap()
+	var peak uint64
+	sample := func() {
+		if inUse := retainedHeap(); inUse > base && inUse-base > peak {
+			peak = inUse - base
+		}
+	}
+
+	response := newExportResponse()
+	getProducts(t, response, "", productRows(n, -1, nil, n/10, sample))
+	sample()
+	return peak, response
+}
+
+func TestGetProductsByStoreOrAllConstantMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("exports 250k rows")
+	}
+	small, _ := handlerRetainedHeap(t, 10_000)
+	large, response := handlerRetainedHeap(t, 250_000)
+	t.Logf("retained heap growth: %d bytes for 10k rows, %d bytes for 250k (%d byte body)", small, large, response.size)
+
+	// Buffering the rows or the body would retain well over 100 MB
+	const slack = 2 << 20
+	if large > small+slack {
+		t.Fatalf("heap grew by %d bytes for 250k rows against %d for 10k", large, small)
+	}
+	if response.status != http.StatusOK {
+		t.Fatalf("status %d", response.status)
+	}
+	if head := string(response.head); !strings.HasPrefix(head, `{"products":[{"_id":"product-0",`) {
+		t.Fatalf("body starts with %q", head)
+	}
+	if tail := string(response.tail); !strings.HasSuffix(tail, "}\n],\"count\":250000}") || !strings.Contains(tail, `"product-249999"`) {
+		t.Fatalf("body ends with %q", tail)
+	}
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"product-api/models"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// maxFacetCacheEntries bounds the facet cache, distinct filter combinations are unbounded
+const maxFacetCacheEntries = 1000
+
+// facetValue is one value of a facet and the number of matching products
+type facetValue struct {
+	Value string `json:"value"`
+	Hex   string `json:"hex,omitempty"`
+	Count int64  `json:"count"`
+}
+
+// facetCache keeps facet responses for a short time, keyed by the query string
+type facetCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]facetCacheEntry
+}
+
+// facetCacheEntry is a cached facet response
+type facetCacheEntry struct {
+	response gin.H
+	expires  time.Time
+}
+
+// newFacetCache creates a facet cache; a zero ttl disables it
+func newFacetCache(ttl time.Duration) *facetCache {
+	return &facetCache{ttl: ttl, entries: make(map[string]facetCacheEntry)}
+}
+
+// get returns a cached response that has not expired
+func (fc *facetCache) get(key string) (gin.H, bool) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	entry, ok := fc.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.response, true
+}
+
+// put caches a response, dropping expired entries once the cache is full
+func (fc *facetCache) put(key string, response gin.H) {
+	if fc.ttl <= 0 {
+		return
+	}
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	now := time.Now()
+	if len(fc.entries) >= maxFacetCacheEntries {
+		for k, entry := range fc.entries {
+			if now.After(entry.expires) {
+				delete(fc.entries, k)
+			}
+		}
+		if len(fc.entries) >= maxFacetCacheEntries {
+			fc.entries = make(map[string]facetCacheEntry)
+		}
+	}
+	fc.entries[key] = facetCacheEntry{response: response, expires: now.Add(fc.ttl)}
+}
+
+// GetFacets counts active products per store, brand, category, stock status, size and
+// color, and returns their price range, for filter sidebars. Accepts ?store= and the
+// list endpoint filters; counts cover the products those filters match. Responses are
+// cached in memory and may be cached by clients for FACET_CACHE_SECONDS.
+func (h *ProductHandler) GetFacets(c *gin.Context) {
+	filter, err := parseProductFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	facetLimit, _ := strconv.Atoi(c.DefaultQuery("facetLimit", "100"))
+	if facetLimit < 1 || facetLimit > 1000 {
+		facetLimit = 100
+	}
+
+	if h.facets.ttl > 0 {
+		c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(h.facets.ttl.Seconds())))
+	}
+
+	// Query().Encode() sorts the parameters, equivalent requests share one entry
+	key := c.Request.URL.Query().Encode()
+	if response, ok := h.facets.get(key); ok {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	store := c.Query("store")
+	matching := func(db *gorm.DB) *gorm.DB {
+		db = db.Where("is_active = ?", true)
+		if store != "" {
+			db = db.Where("store = ?", store)
+		}
+		return filter.apply(db)
+	}
+
+	var summary struct {
+		Total    int64
+		MinPrice *float64
+		MaxPrice *float64
+	}
+	if err := h.DB.Model(&models.Product{}).Scopes(matching).
+		Select("count(*) AS total, min(" + filter.PriceColumn + ") AS min_price, max(" + filter.PriceColumn + ") AS max_price").
+		Scan(&summary).Error; err != nil {
+		log.Printf("[ERROR] GetFacets: Failed to count products: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count facets"})
+		return
+	}
+
+	// One pass over the matching products counts all column facets
+	var grouped []struct {
+		Facet        string
+		Value        *string
+		ProductCount int64
+	}
+	if err := h.DB.Model(&models.Product{}).Scopes(matching).
+		Select(`CASE WHEN GROUPING(store) = 0 THEN 'stores'
+			WHEN GROUPING(brand) = 0 THEN 'brands'
+			WHEN GROUPING(category) = 0 THEN 'categories'
+			ELSE 'stockStatuses' END AS facet,
+			COALESCE(store, brand, category, stock_status) AS value,
+			count(*) AS product_count`).
+		Group("GROUPING SETS ((store), (brand), (category), (stock_status))").
+		Order("facet, product_count DESC, value").
+		Scan(&grouped).Error; err != nil {
+		log.Printf("[ERROR] GetFacets: Failed to count column facets: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count facets"})
+		return
+	}
+
+	facets := map[string][]facetValue{
+		"stores":        {},
+		"brands":        {},
+		"categories":    {},
+		"stockStatuses": {},
+	}
+	for _, row := range grouped {
+		if row.Value == nil || *row.Value == "" || len(facets[row.Facet]) >= facetLimit {
+			continue
+		}
+		facets[row.Facet] = append(facets[row.Facet], facetValue{Value: *row.Value, Count: row.ProductCount})
+	}
+
+	// Sizes count like the size filter, in stock sizes only
+	sizes := []facetValue{}
+	if err := h.DB.Model(&models.Product{}).Scopes(matching).
+		Joins("CROSS JOIN LATERAL jsonb_array_elements(CASE WHEN jsonb_typeof(sizes) = 'array' THEN sizes ELSE '[]'::jsonb END) AS size(entry)").
+		Where("size.entry->>'onStock' = 'true' AND COALESCE(size.entry->>'sizeName', '') <> ''").
+		Select("size.entry->>'sizeName' AS value, count(DISTINCT products.id) AS count").
+		Group("size.entry->>'sizeName'").
+		Order("count DESC, value").
+		Limit(facetLimit).
+		Scan(&sizes).Error; err != nil {
+		log.Printf("[ERROR] GetFacets: Failed to count sizes: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count facets"})
+		return
+	}
+	facets["sizes"] = sizes
+
+	colors := []facetValue{}
+	if err := h.DB.Model(&models.Product{}).Scopes(matching).
+		Joins("CROSS JOIN LATERAL jsonb_array_elements(CASE WHEN jsonb_typeof(colors) = 'array' THEN colors ELSE '[]'::jsonb END) AS color(entry)").
+		Where("COALESCE(color.entry->>'name', '') <> ''").
+		Select("color.entry->>'name' AS value, COALESCE(max(color.entry->>'hex'), '') AS hex, count(DISTINCT products.id) AS count").
+		Group("color.entry->>'name'").
+		Order("count DESC, value").
+		Limit(facetLimit).
+		Scan(&colors).Error; err != nil {
+		log.Printf("[ERROR] GetFacets: Failed to count colors: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count facets"})
+		return
+	}
+	facets["colors"] = colors
+
+	response := gin.H{
+		"total":  summary.Total,
+		"facets": facets,
+		"price": gin.H{
+			"field": c.DefaultQuery("priceField", "price"),
+			"min":   summary.MinPrice,
+			"max":   summary.MaxPrice,
+		},
+	}
+	h.facets.put(key, response)
+
+	log.Printf("[DEBUG] GetFacets: Counted facets over %d products", summary.Total)
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakeStatement is one statement sent to a fakeDB
+type fakeStatement struct {
+	Query string
+	Args  []interface{}
+}
+
+// fakeResponder answers a query with column names and rows; nil columns is an empty result
+type fakeResponder func(query string, args []interface{}) (columns []string, rows [][]driver.Value)
+
+// fakeStreamer answers a query with column names and a func filling in one row per
+// call, io.EOF after the last one, so row sets need not fit in memory. nil columns
+// leaves the query to the responder.
+type fakeStreamer func(query string, args []interface{}) (columns []string, next func(dest []driver.Value) error)
+
+// fakeDB is a database/sql driver that records every statement, BEGIN and COMMIT
+// included, so tests can count round trips and inspect writes without Postgres.
+// Queries are answered by stream, respond, or with no rows.
+type fakeDB struct {
+	mu         sync.Mutex
+	statements []fakeStatement
+	respond    fakeResponder
+	stream     fakeStreamer
+}
+
+var (
+	fakeDrivers   sync.Once
+	fakeDatabases sync.Map
+)
+
+// newFakeDB opens a gorm connection, with the Postgres dialect, to a new fakeDB
+func newFakeDB(tb testing.TB, respond fakeResponder) (*gorm.DB, *fakeDB) {
+	tb.Helper()
+	fakeDrivers.Do(func() { sql.Register("fakedb", fakeDriver{}) })
+
+	fake := &fakeDB{respond: respond}
+	dsn := fmt.Sprintf("%s-%p", tb.Name(), fake)
+	fakeDatabases.Store(dsn, fake)
+	tb.Cleanup(func() { fakeDatabases.Delete(dsn) })
+
+	db, err := gorm.Open(postgres.New(postgres.Config{DriverName: "fakedb", DSN: dsn}), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		tb.Fatalf("failed to open fake database: %v", err)
+	}
+	return db, fake
+}
+
+// Stream answers the queries streamer takes with rows generated one at a time
+func (f *fakeDB) Stream(streamer fakeStreamer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stream = streamer
+}
+
+// Statements returns the statements received so far
+func (f *fakeDB) Statements() []fakeStatement {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]fakeStatement(nil), f.statements...)
+}
+
+// Count returns the number of statements received so far
+func (f *fakeDB) Count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.statements)
+}
+
+// Reset forgets the statements received so far
+func (f *fakeDB) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.statements = nil
+}
+
+// Find returns the statements containing every fragment
+func (f *fakeDB) Find(fragments ...string) []fakeStatement {
+	var found []fakeStatement
+	for _, statement := range f.Statements() {
+		matches := true
+		for _, fragment := range fragments {
+			if !strings.Contains(statement.Query, fragment) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			found = append(found, statement)
+		}
+	}
+	return found
+}
+
+func (f *fakeDB) record(query string, named []driver.NamedValue) []interface{} {
+	args := make([]interface{}, len(named))
+	for i, arg := range named {
+		args[i] = arg.Value
+	}
+	f.mu.Lock()
+	f.statements = append(f.statements, fakeStatement{Query: query, Args: args})
+	f.mu.Unlock()
+	return args
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(dsn string) (driver.Conn, error) {
+	fake, ok := fakeDatabases.Load(dsn)
+	if !ok {
+		return nil, fmt.Errorf("unknown fake database %q", dsn)
+	}
+	return &fakeConn{db: fake.(*fakeDB)}, nil
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("prepared statements are not supported")
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.db.record("BEGIN", nil)
+	return fakeTx{db: c.db}, nil
+}
+
+// CheckNamedValue passes arguments through unconverted so tests see what gorm sent
+func (c *fakeConn) CheckNamedValue(*driver.NamedValue) error { return nil }
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.record(query, args)
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	values := c.db.record(query, args)
+	rows := &fakeRows{}
+	c.db.mu.Lock()
+	stream := c.db.stream
+	c.db.mu.Unlock()
+	if stream != nil {
+		rows.columns, rows.stream = stream(query, values)
+	}
+	if rows.columns == nil && c.db.respond != nil {
+		rows.columns, rows.values = c.db.respond(query, values)
+	}
+	return rows, nil
+}
+
+type fakeTx struct {
+	db *fakeDB
+}
+
+func (tx fakeTx) Commit() error {
+	tx.db.record("COMMIT", nil)
+	return nil
+}
+
+func (tx fakeTx) Rollback() error {
+	tx.db.record("ROLLBACK", nil)
+	return nil
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+	next    int
+	stream  func(dest []driver.Value) error
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.stream != nil {
+		return r.stream(dest)
+	}
+	if r.next >= len(r.values) {
+		return io.EOF
+	}
+	copy(dest, r.values[r.next])
+	r.next++
+	return nil
+}
+
+// Set returns the columns an UPDATE statement assigns, with their values
+func (s fakeStatement) Set() map[string]interface{} {
+	set := make(map[string]interface{})
+	start := strings.Index(s.Query, " SET ")
+	if start < 0 {
+		return set
+	}
+	assignments := s.Query[start+len(" SET "):]
+	if end := strings.Index(assignments, " WHERE "); end >= 0 {
+		assignments = assignments[:end]
+	}
+	for _, assignment := range strings.Split(assignments, ",") {
+		column, placeholder, ok := strings.Cut(assignment, "=")
+		if !ok || !strings.HasPrefix(placeholder, "$") {
+			continue
+		}
+		var index int
+		if _, err := fmt.Sscanf(placeholder, "$%d", &index); err != nil || index < 1 || index > len(s.Args) {
+			continue
+		}
+		set[strings.Trim(strings.TrimSpace(column), `"`)] = s.Args[index-1]
+	}
+	return set
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"product-api/models"
+	"product-api/utils"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// imageCacheControl is sent with uploaded files. Stored image names are derived
+// from their content, so a URL never changes what it points to.
+const imageCacheControl = "public, max-age=31536000, immutable"
+
+type ImageHandler struct {
+	DB     *gorm.DB
+	Images *utils.ImageProcessor
+}
+
+// NewImageHandler creates a new image handler sharing the processor used by ingestion
+func NewImageHandler(db *gorm.DB, images *utils.ImageProcessor) *ImageHandler {
+	return &ImageHandler{DB: db, Images: images}
+}
+
+// GetImage returns the record of a stored image by its SHA-256 hash
+func (h *ImageHandler) GetImage(c *gin.Context) {
+	hash := strings.ToLower(c.Param("hash"))
+
+	var image models.Image
+	if err := h.DB.Where("hash = ?", hash).First(&image).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+			return
+		}
+		log.Printf("[ERROR] GetImage: Failed to fetch image %s: %v", hash, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch image"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"image": image,
+		"url":   h.Images.ImageURL(image.Path),
+	})
+}
+
+// ServeUpload serves files of the image storage under /uploads. Images under
+// /uploads/images accept ?w= and are then served as the resized variant closest to
+// that width, which is generated on first request when it does not exist yet.
+// Storages with a public URL answer with a redirect to it.
+func (h *ImageHandler) ServeUpload(c *gin.Context) {
+	// Cleaning a rooted path drops every "..", so keys stay inside the storage
+	key := strings.TrimPrefix(path.Clean("/"+c.Param("filepath")), "/")
+
+	if width := c.Query("w"); width != "" {
+		requested, err := strconv.Atoi(width)
+		if err != nil || requested <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "w must be a positive integer"})
+			return
+		}
+		image := strings.TrimPrefix(key, utils.ImagesKeyPrefix+"/")
+		if image == key || strings.HasPrefix(image, utils.VariantsDir+"/") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "w is only supported for stored images under /uploads/images"})
+			return
+		}
+
+		variant, err := h.Images.Variant(c.Request.Context(), image, requested)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+			return
+		case err != nil:
+			// Not a decodable image, the original is still better than nothing
+			log.Printf("[WARN] ServeUpload: Failed to get %dpx variant of %s, serving the original: %v", requested, image, err)
+		default:
+			key = variant
+		}
+	}
+
+	if url := h.Images.Storage.URL(key); url != utils.UploadsURLPrefix+key {
+		c.Header("Cache-Control", imageCacheControl)
+		c.Redirect(http.StatusFound, url)
+		return
+	}
+
+	reader, info, err := h.Images.Storage.Get(c.Request.Context(), key)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+		log.Printf("[ERROR] ServeUpload: Failed to read %s: %v", key, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to read file"})
+		return
+	}
+	defer reader.Close()
+
+	// ServeContent needs to seek for ranges; remote objects are small enough to buffer
+	content, ok := reader.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			log.Printf("[ERROR] ServeUpload: Failed to read %s: %v", key, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to read file"})
+			return
+		}
+		content = bytes.NewReader(data)
+	}
+
+	if info.ContentType != "" {
+		c.Header("Content-Type", info.ContentType)
+	}
+	c.Header("Cache-Control", imageCacheControl)
+	http.ServeContent(c.Writer, c.Request, path.Base(key), info.ModTime, content)
+}
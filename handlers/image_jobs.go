@@ -0,0 +1,411 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"product-api/models"
+	"product-api/utils"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// imageJobPollInterval is how often idle image workers look for due jobs
+	imageJobPollInterval = 2 * time.Second
+	// imageJobLease is how long a claimed job stays hidden from other workers. It is
+	// extended while the job runs, so a worker that dies mid-download gives the job
+	// back once it expires, but a slow download is never claimed twice.
+	imageJobLease = 2 * time.Minute
+	// imageHostWait bounds the wait for a free slot of the image's host
+	imageHostWait = 30 * time.Second
+	// imageHostBusyDelay is how long a job that got no slot of its host waits
+	imageHostBusyDelay = 10 * time.Second
+	// maxImageJobAttempts is the number of downloads before a job fails for good
+	maxImageJobAttempts = 5
+	// imageRetryBase is the first retry delay, doubled after every failed attempt
+	imageRetryBase = 30 * time.Second
+	// imageRetryMax caps the retry delay
+	imageRetryMax = time.Hour
+	// imageJobInsertBatch is the number of jobs written per INSERT statement
+	imageJobInsertBatch = 1000
+	// maxListedImageFailures caps the failed jobs returned by the stats endpoint
+	maxListedImageFailures = 100
+)
+
+// queueImageJobs queues a download for every remote image of the batch's products.
+// An image already queued for a product keeps its job, whatever its status.
+func queueImageJobs(tx *gorm.DB, batch []models.Product) error {
+	now := time.Now()
+	var jobs []models.ImageJob
+	for i := range batch {
+		var images []interface{}
+		if len(batch[i].Images) == 0 || json.Unmarshal(batch[i].Images, &images) != nil {
+			continue
+		}
+		seen := make(map[string]bool, len(images))
+		for _, image := range images {
+			source, ok := image.(string)
+			if !ok || seen[source] || !(strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")) {
+				continue
+			}
+			// Stored copies served from the storage's public URL are not downloaded again
+			if _, stored := utils.ImageHash(source); stored {
+				continue
+			}
+			seen[source] = true
+			host := ""
+			if parsed, err := url.Parse(source); err == nil {
+				host = strings.ToLower(parsed.Hostname())
+			}
+			jobs = append(jobs, models.ImageJob{
+				ProductID:     batch[i].ID,
+				Store:         batch[i].Store,
+				SourceURL:     source,
+				Host:          host,
+				Status:        models.ImageJobPending,
+				NextAttemptAt: now,
+			})
+		}
+	}
+	if len(jobs) == 0 {
+		return nil
+	}
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "product_id"}, {Name: "source_url"}},
+		DoNothing: true,
+	}).CreateInBatches(&jobs, imageJobInsertBatch).Error
+}
+
+// wakeImageWorkers nudges an idle image worker, the poll interval picks jobs up otherwise
+func (h *ProductHandler) wakeImageWorkers() {
+	select {
+	case h.imageWake <- struct{}{}:
+	default:
+	}
+}
+
+// StartImageWorkers launches n goroutines that download queued product images
+func (h *ProductHandler) StartImageWorkers(n int) {
+	if n < 1 {
+		log.Printf("[WARN] StartImageWorkers: Image workers disabled")
+		return
+	}
+	for i := 0; i < n; i++ {
+		go h.imageWorker(i)
+	}
+	log.Printf("[INFO] StartImageWorkers: Started %d image workers", n)
+}
+
+// imageWorker claims and runs image jobs until the process exits
+func (h *ProductHandler) imageWorker(worker int) {
+	for {
+		job, err := h.claimImageJob()
+		if err != nil {
+			log.Printf("[ERROR] imageWorker %d: Failed to claim image job: %v", worker, err)
+		}
+		if job == nil {
+			select {
+			case <-h.imageWake:
+			case <-time.After(imageJobPollInterval):
+			}
+			continue
+		}
+		h.runImageJob(context.Background(), job)
+	}
+}
+
+// claimImageJob leases the oldest due job and counts the attempt. Jobs of hosts
+// already downloading at their concurrency limit in this process are left to
+// others. SKIP LOCKED lets several workers and replicas poll the same table.
+func (h *ProductHandler) claimImageJob() (*models.ImageJob, error) {
+	hostFilter := ""
+	args := []interface{}{time.Now().Add(imageJobLease), models.ImageJobPending}
+	if busy := h.hostLimits.Saturated(); len(busy) > 0 {
+		hostFilter = "AND host NOT IN ?"
+		args = append(args, busy)
+	}
+
+	var job models.ImageJob
+	err := h.DB.Raw(`UPDATE image_jobs
+		SET attempts = attempts + 1, next_attempt_at = ?, updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM image_jobs
+			WHERE status = ? AND next_attempt_at <= NOW() `+hostFilter+`
+			ORDER BY next_attempt_at
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING *`, args...).Scan(&job).Error
+	if err != nil {
+		return nil, err
+	}
+	if job.ID == 0 {
+		return nil, nil
+	}
+	return &job, nil
+}
+
+// runImageJob downloads the image of a claimed job and records the outcome. ctx
+// bounds the wait for a free slot of the job's host, on top of imageHostWait.
+func (h *ProductHandler) runImageJob(ctx context.Context, job *models.ImageJob) {
+	stopLease := h.keepImageJobLease(job, imageJobLease/2)
+
+	ctx, cancel := context.WithTimeout(ctx, imageHostWait)
+	err := h.hostLimits.Acquire(ctx, job.Host)
+	cancel()
+	if err != nil {
+		stopLease()
+		// The host is busy in this process, which says nothing about the image
+		h.postponeImageJob(job, err)
+		return
+	}
+	imageURL, err := h.Images.DownloadAndSaveImage(job.Store, job.SourceURL)
+	h.hostLimits.Release(job.Host)
+	// Stopped before recording, so a renewal cannot overwrite the retry time
+	stopLease()
+
+	if err != nil {
+		h.failImageJob(job, err)
+		return
+	}
+	if err := h.completeImageJob(job, imageURL); err != nil {
+		// The lease runs out and the job is downloaded again, which is cheap once stored
+		log.Printf("[ERROR] runImageJob: Failed to record image job %d: %v", job.ID, err)
+		return
+	}
+	log.Printf("[SUCCESS] runImageJob: Stored image of product %s as %s", job.ProductID, imageURL)
+}
+
+// postponeImageJob gives back a job that never got a slot of its host, after
+// imageHostBusyDelay and without counting the attempt its claim took
+func (h *ProductHandler) postponeImageJob(job *models.ImageJob, cause error) {
+	log.Printf("[DEBUG] postponeImageJob: Host %s of image job %d is busy (%v), postponing it", job.Host, job.ID, cause)
+	err := h.DB.Model(&models.ImageJob{}).Where("id = ? AND status = ?", job.ID, models.ImageJobPending).
+		Updates(map[string]interface{}{
+			"attempts":        gorm.Expr("attempts - 1"),
+			"next_attempt_at": time.Now().Add(imageHostBusyDelay),
+		}).Error
+	if err != nil {
+		log.Printf("[ERROR] postponeImageJob: Failed to postpone image job %d: %v", job.ID, err)
+	}
+}
+
+// failImageJob records a failed download: the job is retried after imageRetryDelay,
+// or fails for good when the error is permanent or its attempts are used up
+func (h *ProductHandler) failImageJob(job *models.ImageJob, cause error) {
+	updates := map[string]interface{}{"last_error": cause.Error()}
+	switch {
+	case utils.IsPermanentFetchError(cause) || job.Attempts >= maxImageJobAttempts:
+		updates["status"] = models.ImageJobFailed
+		log.Printf("[ERROR] failImageJob: Image job %d of store %s failed after %d attempts: %v", job.ID, job.Store, job.Attempts, cause)
+	default:
+		updates["next_attempt_at"] = time.Now().Add(imageRetryDelay(job.Attempts))
+		log.Printf("[WARN] failImageJob: Attempt %d of image job %d failed: %v", job.Attempts, job.ID, cause)
+	}
+	if err := h.DB.Model(job).Updates(updates).Error; err != nil {
+		log.Printf("[ERROR] failImageJob: Failed to record image job %d: %v", job.ID, err)
+	}
+}
+
+// keepImageJobLease extends the lease of a running job every interval until the
+// returned func is called, which waits for any renewal in flight
+func (h *ProductHandler) keepImageJobLease(job *models.ImageJob, interval time.Duration) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := h.DB.Model(&models.ImageJob{}).Where("id = ? AND status = ?", job.ID, models.ImageJobPending).
+					Update("next_attempt_at", time.Now().Add(imageJobLease)).Error
+				if err != nil {
+					log.Printf("[WARN] keepImageJobLease: Failed to extend the lease of image job %d: %v", job.ID, err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// completeImageJob replaces the source URL with the stored copy in the images of
+// the job's product, and of every other product of the store waiting for the same URL
+func (h *ProductHandler) completeImageJob(job *models.ImageJob, imageURL string) error {
+	return h.DB.Transaction(func(tx *gorm.DB) error {
+		var jobs []models.ImageJob
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("store = ? AND source_url = ? AND status = ? AND id <> ?", job.Store, job.SourceURL, models.ImageJobPending, job.ID).
+			Find(&jobs).Error; err != nil {
+			return err
+		}
+		jobs = append(jobs, *job)
+		// Locking products in a fixed order keeps concurrent completions from deadlocking
+		sort.Slice(jobs, func(a, b int) bool { return jobs[a].ProductID < jobs[b].ProductID })
+
+		refs := utils.ImageRefs{}
+		ids := make([]uint, 0, len(jobs))
+		for _, queued := range jobs {
+			ids = append(ids, queued.ID)
+
+			var product models.Product
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id, images").
+				Where("id = ?", queued.ProductID).Take(&product).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			images, changed := replaceImage(product.Images, queued.SourceURL, imageURL)
+			if !changed {
+				continue
+			}
+			if err := tx.Model(&models.Product{}).Where("id = ?", product.ID).Update("images", images).Error; err != nil {
+				return err
+			}
+			refs.Replace(product.Images, images)
+		}
+		if err := refs.Apply(tx); err != nil {
+			return err
+		}
+
+		return tx.Model(&models.ImageJob{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+			"status":     models.ImageJobDone,
+			"image_url":  imageURL,
+			"last_error": "",
+		}).Error
+	})
+}
+
+// replaceImage swaps every occurrence of from in a product's images for to
+func replaceImage(images datatypes.JSON, from, to string) (datatypes.JSON, bool) {
+	var list []interface{}
+	if len(images) == 0 || json.Unmarshal(images, &list) != nil {
+		return images, false
+	}
+	changed := false
+	for i, image := range list {
+		if source, ok := image.(string); ok && source == from {
+			list[i] = to
+			changed = true
+		}
+	}
+	if !changed {
+		return images, false
+	}
+	encoded, err := json.Marshal(list)
+	if err != nil {
+		return images, false
+	}
+	return datatypes.JSON(encoded), true
+}
+
+// imageRetryDelay is the exponential backoff before the next attempt
+func imageRetryDelay(attempts int) time.Duration {
+	delay := imageRetryBase
+	for i := 1; i < attempts && delay < imageRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > imageRetryMax {
+		delay = imageRetryMax
+	}
+	return delay
+}
+
+// imageJobStoreStats counts the image jobs of one store by status
+type imageJobStoreStats struct {
+	Store    string `json:"store"`
+	Pending  int64  `json:"pending"`
+	Retrying int64  `json:"retrying"`
+	Done     int64  `json:"done"`
+	Failed   int64  `json:"failed"`
+}
+
+// GetImageJobStats reports the image download queue: jobs per store and status,
+// and the latest failures. ?store= narrows it to one store, ?failures= sets how
+// many failures are listed (default 20).
+func (h *ProductHandler) GetImageJobStats(c *gin.Context) {
+	limit := 20
+	if value := c.Query("failures"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failures must be a non-negative integer"})
+			return
+		}
+		limit = parsed
+	}
+	if limit > maxListedImageFailures {
+		limit = maxListedImageFailures
+	}
+
+	scope := func() *gorm.DB {
+		query := h.DB.Model(&models.ImageJob{})
+		if store := c.Query("store"); store != "" {
+			query = query.Where("store = ?", store)
+		}
+		return query
+	}
+
+	var stores []imageJobStoreStats
+	if err := scope().Select(`store,
+			count(*) FILTER (WHERE status = ?) AS pending,
+			count(*) FILTER (WHERE status = ? AND attempts > 0) AS retrying,
+			count(*) FILTER (WHERE status = ?) AS done,
+			count(*) FILTER (WHERE status = ?) AS failed`,
+		models.ImageJobPending, models.ImageJobPending, models.ImageJobDone, models.ImageJobFailed).
+		Group("store").Order("store").Scan(&stores).Error; err != nil {
+		log.Printf("[ERROR] GetImageJobStats: Failed to count image jobs: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch image job stats"})
+		return
+	}
+
+	var due int64
+	if err := scope().Where("status = ? AND next_attempt_at <= NOW()", models.ImageJobPending).
+		Count(&due).Error; err != nil {
+		log.Printf("[ERROR] GetImageJobStats: Failed to count due image jobs: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch image job stats"})
+		return
+	}
+
+	failures := []models.ImageJob{}
+	if limit > 0 {
+		if err := scope().Where("status = ?", models.ImageJobFailed).
+			Order("updated_at DESC").Limit(limit).Find(&failures).Error; err != nil {
+			log.Printf("[ERROR] GetImageJobStats: Failed to fetch failed image jobs: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch image job stats"})
+			return
+		}
+	}
+
+	var depth, failed int64
+	for _, store := range stores {
+		depth += store.Pending
+		failed += store.Failed
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"queueDepth":     depth,
+		"due":            due,
+		"failed":         failed,
+		"stores":         stores,
+		"recentFailures": failures,
+	})
+}
@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/http"
+	"product-api/models"
+	"product-api/utils"
+	"strings"
+	"testing"
+	"time"
+)
+
+// imageJobUpdates returns the UPDATE statements of image_jobs, in order
+func imageJobUpdates(fake *fakeDB) []fakeStatement {
+	return fake.Find(`UPDATE "image_jobs" SET`)
+}
+
+// assertDelay checks that at lies delay after before, give or take a few seconds
+func assertDelay(t *testing.T, before time.Time, at interface{}, delay time.Duration) {
+	t.Helper()
+	next, ok := at.(time.Time)
+	if !ok {
+		t.Fatalf("next_attempt_at not set: %v", at)
+	}
+	if got := next.Sub(before); got < delay || got > delay+5*time.Second {
+		t.Fatalf("next attempt after %v, want %v", got, delay)
+	}
+}
+
+func TestClaimImageJobLeasesAndCountsAttempt(t *testing.T) {
+	db, fake := newFakeDB(t, func(query string, args []interface{}) ([]string, [][]driver.Value) {
+		if strings.Contains(query, "UPDATE image_jobs") {
+			return []string{"id", "product_id", "store", "source_url", "host", "status", "attempts"},
+				[][]driver.Value{{int64(9), "p-1", "zara", "https://static.zara.net/a.jpg", "static.zara.net", models.ImageJobPending, int64(1)}}
+		}
+		return nil, nil
+	})
+	h := newTestProductHandler(db)
+
+	before := time.Now()
+	job, err := h.claimImageJob()
+	if err != nil || job == nil || job.ID != 9 || job.Attempts != 1 {
+		t.Fatalf("claimed %+v, %v", job, err)
+	}
+
+	claims := fake.Find("UPDATE image_jobs", "attempts = attempts + 1", "next_attempt_at <= NOW()", "FOR UPDATE SKIP LOCKED")
+	if len(claims) != 1 {
+		t.Fatalf("%d claim statements, want 1", len(claims))
+	}
+	// The lease hides the job from other workers while it runs
+	assertDelay(t, before, claims[0].Args[0], imageJobLease)
+	if strings.Contains(claims[0].Query, "host NOT IN") {
+		t.Fatal("claim skipped hosts although none is busy")
+	}
+}
+
+func TestClaimImageJobSkipsBusyHosts(t *testing.T) {
+	db, fake := newFakeDB(t, nil)
+	h := newTestProductHandler(db)
+	h.hostLimits = utils.NewHostLimiter(1, 0)
+	if err := h.hostLimits.Acquire(context.Background(), "static.zara.net"); err != nil {
+		t.Fatal(err)
+	}
+	defer h.hostLimits.Release("static.zara.net")
+
+	if job, err := h.claimImageJob(); err != nil || job != nil {
+		t.Fatalf("got %+v, %v, want no job", job, err)
+	}
+	claims := fake.Find("UPDATE image_jobs", "host NOT IN")
+	if len(claims) != 1 {
+		t.Fatalf("%d claims skipping busy hosts, want 1", len(claims))
+	}
+	if !containsArg(claims[0].Args, "static.zara.net") {
+		t.Fatalf("claim sent with %v", claims[0].Args)
+	}
+}
+
+func TestRunImageJobPostponesBusyHost(t *testing.T) {
+	db, fake := newFakeDB(t, nil)
+	h := newTestProductHandler(db)
+	h.hostLimits = utils.NewHostLimiter(1, 0)
+	if err := h.hostLimits.Acquire(context.Background(), "static.zara.net"); err != nil {
+		t.Fatal(err)
+	}
+	defer h.hostLimits.Release("static.zara.net")
+
+	// Cancelled, so the wait for the held slot gives up at once
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	before := time.Now()
+	h.runImageJob(ctx, &models.ImageJob{ID: 9, Store: "zara", SourceURL: "https://static.zara.net/a.jpg", Host: "static.zara.net", Attempts: 2})
+
+	updates := imageJobUpdates(fake)
+	if len(updates) != 1 {
+		t.Fatalf("%d updates, want 1", len(updates))
+	}
+	if !strings.Contains(updates[0].Query, `"attempts"=attempts - 1`) {
+		t.Fatalf("the attempt of a job that never ran was kept: %s", updates[0].Query)
+	}
+	set := updates[0].Set()
+	if _, ok := set["status"]; ok {
+		t.Fatalf("busy host changed the status: %v", set)
+	}
+	if _, ok := set["last_error"]; ok {
+		t.Fatalf("busy host recorded as a download error: %v", set)
+	}
+	assertDelay(t, before, set["next_attempt_at"], imageHostBusyDelay)
+	if !containsArg(updates[0].Args, models.ImageJobPending) {
+		t.Fatalf("postponed without checking the job is still pending: %v", updates[0].Args)
+	}
+}
+
+func TestRunImageJobFailsRefusedURL(t *testing.T) {
+	db, fake := newFakeDB(t, nil)
+	h := newTestProductHandler(db)
+
+	h.runImageJob(context.Background(), &models.ImageJob{ID: 9, Store: "zara", SourceURL: "http://127.0.0.1/a.jpg", Host: "127.0.0.1", Attempts: 1})
+
+	updates := imageJobUpdates(fake)
+	if len(updates) != 1 {
+		t.Fatalf("%d updates, want 1", len(updates))
+	}
+	set := updates[0].Set()
+	if set["status"] != models.ImageJobFailed {
+		t.Fatalf("refused URL recorded with %v", set)
+	}
+	if message, _ := set["last_error"].(string); !strings.Contains(message, utils.ErrBlockedAddress.Error()) {
+		t.Fatalf("last_error %q", message)
+	}
+}
+
+func TestFailImageJobRetriesWithBackoff(t *testing.T) {
+	for attempts := 1; attempts < maxImageJobAttempts; attempts++ {
+		db, fake := newFakeDB(t, nil)
+		h := newTestProductHandler(db)
+
+		before := time.Now()
+		h.failImageJob(&models.ImageJob{ID: 9, Attempts: attempts}, &utils.HTTPStatusError{StatusCode: http.StatusServiceUnavailable})
+
+		updates := imageJobUpdates(fake)
+		if len(updates) != 1 {
+			t.Fatalf("attempt %d: %d updates, want 1", attempts, len(updates))
+		}
+		set := updates[0].Set()
+		if _, ok := set["status"]; ok {
+			t.Fatalf("attempt %d: job left the queue: %v", attempts, set)
+		}
+		if set["last_error"] == "" {
+			t.Fatalf("attempt %d: error not recorded", attempts)
+		}
+		assertDelay(t, before, set["next_attempt_at"], imageRetryDelay(attempts))
+	}
+
+	if imageRetryDelay(1) != imageRetryBase || imageRetryDelay(2) != 2*imageRetryBase || imageRetryDelay(100) != imageRetryMax {
+		t.Fatalf("unexpected backoff: %v, %v, %v", imageRetryDelay(1), imageRetryDelay(2), imageRetryDelay(100))
+	}
+}
+
+func TestFailImageJobFails(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempts int
+		err      error
+	}{
+		{"attempts used up", maxImageJobAttempts, &utils.HTTPStatusError{StatusCode: http.StatusServiceUnavailable}},
+		{"not found", 1, &utils.HTTPStatusError{StatusCode: http.StatusNotFound}},
+		{"not an image", 1, utils.ErrNotAnImage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, fake := newFakeDB(t, nil)
+			h := newTestProductHandler(db)
+
+			h.failImageJob(&models.ImageJob{ID: 9, Attempts: tt.attempts}, tt.err)
+
+			updates := imageJobUpdates(fake)
+			if len(updates) != 1 {
+				t.Fatalf("%d updates, want 1", len(updates))
+			}
+			set := updates[0].Set()
+			if set["status"] != models.ImageJobFailed {
+				t.Fatalf("job updated with %v", set)
+			}
+			if _, ok := set["next_attempt_at"]; ok {
+				t.Fatalf("failed job rescheduled: %v", set)
+			}
+		})
+	}
+}
+
+func TestKeepImageJobLease(t *testing.T) {
+	db, fake := newFakeDB(t, nil)
+	h := newTestProductHandler(db)
+
+	before := time.Now()
+	stop := h.keepImageJobLease(&models.ImageJob{ID: 9}, 10*time.Millisecond)
+	time.Sleep(55 * time.Millisecond)
+	stop()
+
+	renewals := imageJobUpdates(fake)
+	if len(renewals) < 2 {
+		t.Fatalf("%d lease renewals in 55ms, want one every 10ms", len(renewals))
+	}
+	for _, renewal := range renewals {
+		assertDelay(t, before, renewal.Set()["next_attempt_at"], imageJobLease)
+		// A job recorded as done or failed meanwhile keeps its state
+		if !containsArg(renewal.Args, models.ImageJobPending) {
+			t.Fatalf("lease renewed without checking the job is pending: %v", renewal.Args)
+		}
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if after := len(imageJobUpdates(fake)); after != len(renewals) {
+		t.Fatalf("%d lease renewals after stop", after-len(renewals))
+	}
+}
+
+func TestCompleteImageJob(t *testing.T) {
+	const source = "https://static.zara.net/a.jpg"
+	const stored = "/uploads/images/ab/cd/abcd.jpg"
+	db, fake := newFakeDB(t, func(query string, args []interface{}) ([]string, [][]driver.Value) {
+		if strings.Contains(query, `FROM "products"`) {
+			return []string{"id", "images"}, [][]driver.Value{{"p-1", []byte(`["` + source + `","https://static.zara.net/b.jpg"]`)}}
+		}
+		return nil, nil
+	})
+	h := newTestProductHandler(db)
+
+	if err := h.completeImageJob(&models.ImageJob{ID: 9, ProductID: "p-1", Store: "zara", SourceURL: source}, stored); err != nil {
+		t.Fatalf("complete failed: %v", err)
+	}
+
+	products := fake.Find(`UPDATE "products" SET "images"`)
+	if len(products) != 1 {
+		t.Fatalf("%d product updates, want 1", len(products))
+	}
+	images, _ := products[0].Set()["images"].(string)
+	if !strings.Contains(images, stored) || strings.Contains(images, source) {
+		t.Fatalf("product images set to %v", products[0].Set()["images"])
+	}
+
+	updates := imageJobUpdates(fake)
+	if len(updates) != 1 {
+		t.Fatalf("%d image job updates, want 1", len(updates))
+	}
+	if set := updates[0].Set(); set["status"] != models.ImageJobDone || set["image_url"] != stored {
+		t.Fatalf("job updated with %v", set)
+	}
+}
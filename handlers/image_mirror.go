@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"product-api/models"
+	"product-api/utils"
+	"strings"
+	"sync"
+
+	"gorm.io/datatypes"
+)
+
+// mirrorConcurrency is the number of embedded images of a batch stored at the same time
+const mirrorConcurrency = 8
+
+// mirrorSource is an embedded image to store, with the store it belongs to
+type mirrorSource struct {
+	store string
+	url   string
+}
+
+// mirrorTask is one image of a batch to store locally, source indexes the batch's sources
+type mirrorTask struct {
+	product int
+	index   int
+	source  int
+}
+
+// mirrorImages replaces the images of a batch with copies in the image storage,
+// referenced by their storage URL, when MIRROR_IMAGES is enabled. The images as received
+// are kept in OriginalImages. Products whose images did not change since the last
+// ingestion reuse the stored copies. Embedded data:image URIs are stored right away;
+// remote images already downloaded by the image workers are swapped for their
+// copies, the others keep their URL and are queued by upsertBatch. Copies are
+// content addressed, so an image shared by several products or stores is stored once.
+func (h *ProductHandler) mirrorImages(batch []models.Product) {
+	if !h.Config.MirrorImages {
+		return
+	}
+
+	stored, err := h.storedImages(batch)
+	if err != nil {
+		log.Printf("[ERROR] mirrorImages: Failed to load stored images, storing all: %v", err)
+	}
+
+	local := make(map[int][]interface{})
+	var tasks []mirrorTask
+	// Products of the same model often share images between colors, store each once
+	fetch := make(map[mirrorSource]int)
+	var sources []mirrorSource
+	remote := make(map[mirrorSource]string)
+	for i := range batch {
+		if len(batch[i].Images) == 0 || string(batch[i].Images) == "null" {
+			continue
+		}
+		if previous, ok := stored[batch[i].CanonicalURL]; ok && jsonEqual(previous.OriginalImages, batch[i].Images) {
+			batch[i].OriginalImages = batch[i].Images
+			batch[i].Images = previous.Images
+			continue
+		}
+
+		var images []interface{}
+		if err := json.Unmarshal(batch[i].Images, &images); err != nil {
+			log.Printf("[WARN] mirrorImages: Images of product %s are not an array, keeping them as is", batch[i].ID)
+			continue
+		}
+		local[i] = images
+		for k, image := range images {
+			source, ok := image.(string)
+			if !ok {
+				continue
+			}
+			if _, stored := utils.ImageHash(source); stored {
+				continue
+			}
+			if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+				remote[mirrorSource{store: batch[i].Store, url: source}] = ""
+				continue
+			}
+			if strings.HasPrefix(source, "data:image/") {
+				key := mirrorSource{store: batch[i].Store, url: source}
+				if _, seen := fetch[key]; !seen {
+					fetch[key] = len(sources)
+					sources = append(sources, key)
+				}
+				tasks = append(tasks, mirrorTask{product: i, index: k, source: fetch[key]})
+			}
+		}
+	}
+
+	results := make([]string, len(sources))
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < mirrorConcurrency && w < len(sources); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range queue {
+				path, err := h.Images.ProcessSingleImage(sources[t].store, sources[t].url)
+				if err != nil {
+					log.Printf("[WARN] mirrorImages: Keeping original image %.100s of store %s: %v", sources[t].url, sources[t].store, err)
+					continue
+				}
+				results[t] = path
+			}
+		}()
+	}
+	for t := range sources {
+		queue <- t
+	}
+	close(queue)
+	wg.Wait()
+
+	if err := h.downloadedImages(remote); err != nil {
+		log.Printf("[ERROR] mirrorImages: Failed to load downloaded images, leaving them to the queue: %v", err)
+	}
+
+	originals := make(map[int]datatypes.JSON, len(local))
+	for i := range local {
+		originals[i] = batch[i].Images
+	}
+	for _, task := range tasks {
+		if path := results[task.source]; path != "" {
+			local[task.product][task.index] = path
+		}
+	}
+	reused := 0
+	for i, images := range local {
+		for k, image := range images {
+			source, _ := image.(string)
+			if path := remote[mirrorSource{store: batch[i].Store, url: source}]; path != "" {
+				images[k] = path
+				reused++
+			}
+		}
+	}
+	mirrored := 0
+	for i, images := range local {
+		encoded, err := json.Marshal(images)
+		if err != nil {
+			continue
+		}
+		batch[i].Images = datatypes.JSON(encoded)
+		batch[i].OriginalImages = originals[i]
+		mirrored++
+	}
+
+	if len(tasks) > 0 || reused > 0 {
+		log.Printf("[DEBUG] mirrorImages: Stored %d embedded images (%d distinct) and reused %d downloaded images of %d products", len(tasks), len(sources), reused, mirrored)
+	}
+}
+
+// downloadedImages fills remote with the copies stored by finished image jobs.
+// Only jobs of the same store count, their allowed hosts may differ.
+func (h *ProductHandler) downloadedImages(remote map[mirrorSource]string) error {
+	if len(remote) == 0 {
+		return nil
+	}
+	urls := make([]string, 0, len(remote))
+	for source := range remote {
+		urls = append(urls, source.url)
+	}
+
+	var jobs []models.ImageJob
+	if err := h.DB.Select("store, source_url, image_url").
+		Where("status = ? AND source_url IN ?", models.ImageJobDone, urls).
+		Find(&jobs).Error; err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		key := mirrorSource{store: job.Store, url: job.SourceURL}
+		if _, ok := remote[key]; ok {
+			remote[key] = job.ImageURL
+		}
+	}
+	return nil
+}
+
+// storedImages loads the stored images of the batch's products that were mirrored before
+func (h *ProductHandler) storedImages(batch []models.Product) (map[string]models.Product, error) {
+	urls := make([]string, 0, len(batch))
+	for i := range batch {
+		if batch[i].CanonicalURL != "" {
+			urls = append(urls, batch[i].CanonicalURL)
+		}
+	}
+	stored := make(map[string]models.Product, len(urls))
+	if len(urls) == 0 {
+		return stored, nil
+	}
+
+	var rows []models.Product
+	if err := h.DB.Select("canonical_url, images, original_images").
+		Where("canonical_url IN ? AND original_images IS NOT NULL", urls).
+		Find(&rows).Error; err != nil {
+		return stored, err
+	}
+	for _, row := range rows {
+		stored[row.CanonicalURL] = row
+	}
+	return stored, nil
+}
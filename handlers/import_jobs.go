@@ -0,0 +1,344 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"product-api/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+const (
+	// importJobPollInterval is how often idle workers look for pending jobs
+	importJobPollInterval = 2 * time.Second
+	// importJobStaleAfter is how long a running job may go without progress before it is requeued
+	importJobStaleAfter = 5 * time.Minute
+	// maxImportJobAttempts bounds how often a crashed job is picked up again
+	maxImportJobAttempts = 3
+	// maxImportJobItemErrors caps the per-item errors kept on a job
+	maxImportJobItemErrors = 1000
+	// importRetryBase is the delay before a job whose batch failed is picked up again,
+	// doubled for every further attempt up to importRetryMax
+	importRetryBase = 30 * time.Second
+	importRetryMax  = 10 * time.Minute
+)
+
+// SubmitImportJob stores a bulk insert payload as an import job and answers 202 with its ID.
+// The payload has the same shape as POST /api/stock/add.
+func (h *ProductHandler) SubmitImportJob(c *gin.Context) {
+	log.Printf("[DEBUG] SubmitImportJob: Received POST request from %s", c.ClientIP())
+
+	rawBody, err := c.GetRawData()
+	if err != nil {
+		log.Printf("[ERROR] SubmitImportJob: Failed to read raw body: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+
+	products, err := parseProducts(rawBody)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid JSON format - must be either a product object or array of products",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if len(products) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No products provided"})
+		return
+	}
+
+	job := models.ImportJob{
+		ID:      uuid.NewString(),
+		State:   models.ImportJobPending,
+		Total:   len(products),
+		Payload: datatypes.JSON(rawBody),
+	}
+	if err := h.DB.Create(&job).Error; err != nil {
+		log.Printf("[ERROR] SubmitImportJob: Failed to store import job: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create import job"})
+		return
+	}
+
+	// Wake an idle worker, the poll interval picks the job up otherwise
+	select {
+	case h.importWake <- struct{}{}:
+	default:
+	}
+
+	log.Printf("[INFO] SubmitImportJob: Queued import job %s with %d products", job.ID, job.Total)
+	c.JSON(http.StatusAccepted, gin.H{
+		"jobId":     job.ID,
+		"state":     job.State,
+		"total":     job.Total,
+		"statusUrl": "/api/stock/jobs/" + job.ID,
+	})
+}
+
+// GetImportJob returns the state, counters and per-item errors of an import job
+func (h *ProductHandler) GetImportJob(c *gin.Context) {
+	jobID := c.Param("id")
+
+	var job models.ImportJob
+	if err := h.DB.Omit("payload").Where("id = ?", jobID).First(&job).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Import job not found"})
+			return
+		}
+		log.Printf("[ERROR] GetImportJob: Failed to fetch import job %s: %v", jobID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch import job"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// StartImportWorkers launches n goroutines that drain pending import jobs.
+// Jobs left running by a crashed or restarted process are requeued once stale.
+func (h *ProductHandler) StartImportWorkers(n int) {
+	if n < 1 {
+		log.Printf("[WARN] StartImportWorkers: Import workers disabled")
+		return
+	}
+
+	h.requeueStaleImportJobs()
+	for i := 0; i < n; i++ {
+		go h.importWorker(i)
+	}
+	log.Printf("[INFO] StartImportWorkers: Started %d import workers", n)
+}
+
+// importWorker claims and runs jobs until the process exits
+func (h *ProductHandler) importWorker(worker int) {
+	lastRequeue := time.Now()
+
+	for {
+		// One worker takes care of recovering jobs abandoned by dead processes
+		if worker == 0 && time.Since(lastRequeue) > importJobStaleAfter {
+			h.requeueStaleImportJobs()
+			lastRequeue = time.Now()
+		}
+
+		job, err := h.claimImportJob()
+		if err != nil {
+			log.Printf("[ERROR] importWorker %d: Failed to claim import job: %v", worker, err)
+		}
+		if job == nil {
+			select {
+			case <-h.importWake:
+			case <-time.After(importJobPollInterval):
+			}
+			continue
+		}
+
+		h.runImportJob(job)
+	}
+}
+
+// claimImportJob atomically moves the oldest due pending job to running. Jobs
+// waiting out a retry delay are skipped. SKIP LOCKED lets several workers and
+// replicas poll the same table.
+func (h *ProductHandler) claimImportJob() (*models.ImportJob, error) {
+	var job models.ImportJob
+	err := h.DB.Raw(`UPDATE import_jobs
+		SET state = ?, attempts = attempts + 1, started_at = COALESCE(started_at, NOW()), updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM import_jobs
+			WHERE state = ? AND (next_attempt_at IS NULL OR next_attempt_at <= NOW())
+			ORDER BY created_at
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING *`, models.ImportJobRunning, models.ImportJobPending).Scan(&job).Error
+	if err != nil {
+		return nil, err
+	}
+	if job.ID == "" {
+		return nil, nil
+	}
+	return &job, nil
+}
+
+// requeueStaleImportJobs puts running jobs without recent progress back in the queue,
+// or fails them once they have used up their attempts
+func (h *ProductHandler) requeueStaleImportJobs() {
+	staleBefore := time.Now().Add(-importJobStaleAfter)
+
+	requeued := h.DB.Model(&models.ImportJob{}).
+		Where("state = ? AND updated_at < ? AND attempts < ?", models.ImportJobRunning, staleBefore, maxImportJobAttempts).
+		Update("state", models.ImportJobPending)
+	if requeued.Error != nil {
+		log.Printf("[ERROR] requeueStaleImportJobs: Failed to requeue stale jobs: %v", requeued.Error)
+	} else if requeued.RowsAffected > 0 {
+		log.Printf("[WARN] requeueStaleImportJobs: Requeued %d stale import jobs", requeued.RowsAffected)
+	}
+
+	failed := h.DB.Model(&models.ImportJob{}).
+		Where("state = ? AND updated_at < ? AND attempts >= ?", models.ImportJobRunning, staleBefore, maxImportJobAttempts).
+		Updates(map[string]interface{}{
+			"state":       models.ImportJobFailed,
+			"error":       "Job stopped making progress too many times",
+			"finished_at": time.Now(),
+		})
+	if failed.Error != nil {
+		log.Printf("[ERROR] requeueStaleImportJobs: Failed to fail stale jobs: %v", failed.Error)
+	}
+}
+
+// runImportJob ingests the payload of a claimed job batch by batch, persisting
+// progress after every batch so a restarted job resumes where it stopped
+func (h *ProductHandler) runImportJob(job *models.ImportJob) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[ERROR] runImportJob: Job %s panicked: %v", job.ID, r)
+			h.finishImportJob(job, models.ImportJobFailed, fmt.Sprintf("internal error: %v", r))
+		}
+	}()
+
+	log.Printf("[INFO] runImportJob: Running import job %s (attempt %d, %d/%d processed)",
+		job.ID, job.Attempts, job.Processed, job.Total)
+
+	products, err := parseProducts(job.Payload)
+	if err != nil {
+		h.finishImportJob(job, models.ImportJobFailed, "Invalid payload: "+err.Error())
+		return
+	}
+
+	var itemErrors []models.ItemError
+	if len(job.Errors) > 0 {
+		if err := json.Unmarshal(job.Errors, &itemErrors); err != nil {
+			log.Printf("[WARN] runImportJob: Discarding unreadable errors of job %s: %v", job.ID, err)
+		}
+	}
+
+	// Validation and dedup are deterministic, so a resumed job maps Processed back onto the same list
+	unique, indexes, duplicates, rejected := prepareProducts(products)
+	skippedUpfront := len(duplicates) + len(rejected)
+	start := 0
+	if job.Processed == 0 {
+		job.Skipped = skippedUpfront
+		itemErrors = appendItemErrors(itemErrors, rejected)
+	} else {
+		start = job.Processed - skippedUpfront
+	}
+
+	for i := start; i < len(unique); i += ingestBatchSize {
+		end := i + ingestBatchSize
+		if end > len(unique) {
+			end = len(unique)
+		}
+
+		batch := unique[i:end]
+		stats, batchRejected, err := h.writeBatch(batch, indexes[i:end], i)
+		if err != nil {
+			// writeBatch reports bad rows as rejections, so this is the database itself
+			// failing; the batch is retried rather than counted against its rows
+			log.Printf("[ERROR] runImportJob: Job %s batch %d-%d failed: %v", job.ID, i, end-1, err)
+			h.retryImportJob(job, err)
+			return
+		}
+		itemErrors = appendItemErrors(itemErrors, batchRejected)
+		job.Inserted += stats.Inserted
+		job.Updated += stats.Updated
+		job.Skipped += len(batchRejected)
+		job.Processed = skippedUpfront + end
+
+		if err := h.saveImportJobProgress(job, itemErrors); err != nil {
+			log.Printf("[ERROR] runImportJob: Failed to save progress of job %s: %v", job.ID, err)
+		}
+	}
+
+	job.Processed = job.Total
+	if err := h.saveImportJobProgress(job, itemErrors); err != nil {
+		log.Printf("[ERROR] runImportJob: Failed to save progress of job %s: %v", job.ID, err)
+	}
+	h.finishImportJob(job, models.ImportJobCompleted, "")
+
+	log.Printf("[SUCCESS] runImportJob: Job %s completed (%d inserted, %d updated, %d skipped)",
+		job.ID, job.Inserted, job.Updated, job.Skipped)
+}
+
+// appendItemErrors adds item errors to a job report without exceeding maxImportJobItemErrors
+func appendItemErrors(itemErrors, more []models.ItemError) []models.ItemError {
+	room := maxImportJobItemErrors - len(itemErrors)
+	if room <= 0 {
+		return itemErrors
+	}
+	if len(more) > room {
+		more = more[:room]
+	}
+	return append(itemErrors, more...)
+}
+
+// saveImportJobProgress persists the counters and item errors of a running job
+func (h *ProductHandler) saveImportJobProgress(job *models.ImportJob, itemErrors []models.ItemError) error {
+	updates := map[string]interface{}{
+		"processed": job.Processed,
+		"inserted":  job.Inserted,
+		"updated":   job.Updated,
+		"skipped":   job.Skipped,
+	}
+	if len(itemErrors) > 0 {
+		errorsJSON, err := json.Marshal(itemErrors)
+		if err != nil {
+			return err
+		}
+		updates["errors"] = datatypes.JSON(errorsJSON)
+	}
+	return h.DB.Model(&models.ImportJob{}).Where("id = ?", job.ID).Updates(updates).Error
+}
+
+// retryImportJob puts a job whose batch failed for a reason other than its data
+// back in the queue, to resume from its last saved progress after importRetryDelay,
+// or fails it once it has used up its attempts. When the database cannot be reached
+// to do either, the job stays running and is requeued once stale.
+func (h *ProductHandler) retryImportJob(job *models.ImportJob, cause error) {
+	if job.Attempts >= maxImportJobAttempts {
+		h.finishImportJob(job, models.ImportJobFailed, "Database error: "+cause.Error())
+		return
+	}
+
+	delay := importRetryDelay(job.Attempts)
+	err := h.DB.Model(&models.ImportJob{}).Where("id = ? AND state = ?", job.ID, models.ImportJobRunning).
+		Updates(map[string]interface{}{
+			"state":           models.ImportJobPending,
+			"next_attempt_at": time.Now().Add(delay),
+		}).Error
+	if err != nil {
+		log.Printf("[ERROR] retryImportJob: Failed to requeue job %s, leaving it to the stale job check: %v", job.ID, err)
+		return
+	}
+	log.Printf("[WARN] retryImportJob: Requeued job %s after attempt %d, retrying in %v", job.ID, job.Attempts, delay)
+}
+
+// importRetryDelay is the exponential backoff before the attempt after attempts
+func importRetryDelay(attempts int) time.Duration {
+	delay := importRetryBase
+	for i := 1; i < attempts && delay < importRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > importRetryMax {
+		delay = importRetryMax
+	}
+	return delay
+}
+
+// finishImportJob moves a job to a final state and drops its payload
+func (h *ProductHandler) finishImportJob(job *models.ImportJob, state, message string) {
+	err := h.DB.Model(&models.ImportJob{}).Where("id = ?", job.ID).Updates(map[string]interface{}{
+		"state":       state,
+		"error":       message,
+		"finished_at": time.Now(),
+		"payload":     nil,
+	}).Error
+	if err != nil {
+		log.Printf("[ERROR] finishImportJob: Failed to finish job %s: %v", job.ID, err)
+	}
+}
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"product-api/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+// importJobUpdates returns the columns set by every UPDATE of import_jobs, in order
+func importJobUpdates(fake *fakeDB) []map[string]interface{} {
+	var updates []map[string]interface{}
+	for _, statement := range fake.Find(`UPDATE "import_jobs" SET`) {
+		updates = append(updates, statement.Set())
+	}
+	return updates
+}
+
+// importProducts returns n distinct products made from the fixture
+func importProducts(t *testing.T, n int) []models.Product {
+	t.Helper()
+	fixture := loadFixture(t)
+	products := make([]models.Product, n)
+	for i := range products {
+		products[i] = fixture[i%len(fixture)]
+		products[i].ID = fmt.Sprintf("import-%d", i)
+		products[i].ProductURL = fmt.Sprintf("https://example.com/p/%d", i)
+	}
+	return products
+}
+
+func TestClaimImportJob(t *testing.T) {
+	db, fake := newFakeDB(t, func(query string, args []interface{}) ([]string, [][]driver.Value) {
+		if strings.Contains(query, "UPDATE import_jobs") {
+			return []string{"id", "state", "total", "processed", "attempts"},
+				[][]driver.Value{{"job-1", models.ImportJobRunning, int64(10), int64(4), int64(2)}}
+		}
+		return nil, nil
+	})
+	h := newTestProductHandler(db)
+
+	job, err := h.claimImportJob()
+	if err != nil {
+		t.Fatalf("claim failed: %v", err)
+	}
+	if job == nil || job.ID != "job-1" || job.Processed != 4 || job.Attempts != 2 {
+		t.Fatalf("claimed %+v", job)
+	}
+
+	claims := fake.Find("UPDATE import_jobs", "attempts = attempts + 1", "FOR UPDATE SKIP LOCKED")
+	if len(claims) != 1 {
+		t.Fatalf("%d claim statements, want 1", len(claims))
+	}
+	if !strings.Contains(claims[0].Query, "next_attempt_at IS NULL OR next_attempt_at <= NOW()") {
+		t.Fatalf("claim does not skip jobs waiting to be retried: %s", claims[0].Query)
+	}
+	if !containsArg(claims[0].Args, models.ImportJobRunning) || !containsArg(claims[0].Args, models.ImportJobPending) {
+		t.Fatalf("claim sent with %v", claims[0].Args)
+	}
+}
+
+func TestClaimImportJobEmptyQueue(t *testing.T) {
+	db, _ := newFakeDB(t, nil)
+	h := newTestProductHandler(db)
+
+	job, err := h.claimImportJob()
+	if err != nil || job != nil {
+		t.Fatalf("got %+v, %v, want no job", job, err)
+	}
+}
+
+func TestRunImportJobResumesFromProcessed(t *testing.T) {
+	products := importProducts(t, 2*ingestBatchSize+500)
+	// Rejected upfront, counted in Processed before the first batch
+	products[0].Name = ""
+	payload, err := json.Marshal(products)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, fake := newFakeDB(t, nil)
+	h := newTestProductHandler(db)
+	job := &models.ImportJob{
+		ID:        "job-1",
+		State:     models.ImportJobRunning,
+		Total:     len(products),
+		Processed: 1 + ingestBatchSize,
+		Inserted:  ingestBatchSize,
+		Skipped:   1,
+		Attempts:  2,
+		Payload:   payload,
+	}
+	h.runImportJob(job)
+
+	upserts := fake.Find(`INSERT INTO "products"`, "ON CONFLICT")
+	if len(upserts) != 2 {
+		t.Fatalf("%d upserts, want the 2 batches left", len(upserts))
+	}
+	if containsArg(upserts[0].Args, products[1].ID, products[ingestBatchSize].ID) {
+		t.Fatal("the resumed job wrote a batch it had already finished")
+	}
+	if !containsArg(upserts[0].Args, products[ingestBatchSize+1].ID) || !containsArg(upserts[1].Args, products[len(products)-1].ID) {
+		t.Fatal("the resumed job did not write the remaining products")
+	}
+
+	updates := importJobUpdates(fake)
+	if len(updates) == 0 {
+		t.Fatal("job progress was not saved")
+	}
+	last := updates[len(updates)-1]
+	if last["state"] != models.ImportJobCompleted {
+		t.Fatalf("job finished with %v", last)
+	}
+	if job.Processed != job.Total || job.Skipped != 1 {
+		t.Fatalf("job ended with processed %d of %d, skipped %d", job.Processed, job.Total, job.Skipped)
+	}
+}
+
+func TestRetryImportJobBacksOff(t *testing.T) {
+	for attempts := 1; attempts < maxImportJobAttempts; attempts++ {
+		db, fake := newFakeDB(t, nil)
+		h := newTestProductHandler(db)
+
+		before := time.Now()
+		h.retryImportJob(&models.ImportJob{ID: "job-1", Attempts: attempts}, fmt.Errorf("connection reset"))
+
+		updates := importJobUpdates(fake)
+		if len(updates) != 1 {
+			t.Fatalf("attempt %d: %d updates, want 1", attempts, len(updates))
+		}
+		if updates[0]["state"] != models.ImportJobPending {
+			t.Fatalf("attempt %d: job updated with %v", attempts, updates[0])
+		}
+		next, ok := updates[0]["next_attempt_at"].(time.Time)
+		if !ok {
+			t.Fatalf("attempt %d: next_attempt_at not set: %v", attempts, updates[0])
+		}
+		delay := next.Sub(before)
+		if want := importRetryDelay(attempts); delay < want || delay > want+5*time.Second {
+			t.Fatalf("attempt %d: retried after %v, want %v", attempts, delay, want)
+		}
+	}
+
+	if importRetryDelay(1) != importRetryBase || importRetryDelay(2) != 2*importRetryBase || importRetryDelay(100) != importRetryMax {
+		t.Fatalf("unexpected backoff: %v, %v, %v", importRetryDelay(1), importRetryDelay(2), importRetryDelay(100))
+	}
+}
+
+func TestRetryImportJobFailsAfterMaxAttempts(t *testing.T) {
+	db, fake := newFakeDB(t, nil)
+	h := newTestProductHandler(db)
+
+	h.retryImportJob(&models.ImportJob{ID: "job-1", Attempts: maxImportJobAttempts}, fmt.Errorf("connection reset"))
+
+	updates := importJobUpdates(fake)
+	if len(updates) != 1 || updates[0]["state"] != models.ImportJobFailed {
+		t.Fatalf("job updated with %v", updates)
+	}
+	if message, _ := updates[0]["error"].(string); !strings.Contains(message, "connection reset") {
+		t.Fatalf("job failed with %q", message)
+	}
+}
+
+func TestRequeueStaleImportJobs(t *testing.T) {
+	db, fake := newFakeDB(t, nil)
+	h := newTestProductHandler(db)
+
+	before := time.Now()
+	h.requeueStaleImportJobs()
+	after := time.Now()
+
+	requeued := fake.Find(`UPDATE "import_jobs" SET "state"`, "attempts <")
+	failed := fake.Find(`UPDATE "import_jobs" SET`, "attempts >=")
+	if len(requeued) != 1 || len(failed) != 1 {
+		t.Fatalf("%d requeue and %d fail statements, want 1 each", len(requeued), len(failed))
+	}
+	if state := requeued[0].Set()["state"]; state != models.ImportJobPending {
+		t.Fatalf("stale jobs requeued as %v", state)
+	}
+	if state := failed[0].Set()["state"]; state != models.ImportJobFailed {
+		t.Fatalf("exhausted stale jobs moved to %v", state)
+	}
+	for _, statement := range append(requeued, failed...) {
+		if !containsArg(statement.Args, models.ImportJobRunning) {
+			t.Fatalf("stale check not limited to running jobs: %v", statement.Args)
+		}
+		var staleBefore time.Time
+		for _, arg := range statement.Args {
+			if at, ok := arg.(time.Time); ok && at.Before(before) {
+				staleBefore = at
+			}
+		}
+		if staleBefore.Before(before.Add(-importJobStaleAfter)) || staleBefore.After(after.Add(-importJobStaleAfter)) {
+			t.Fatalf("stale jobs are the ones idle since %v, want %v before now", staleBefore, importJobStaleAfter)
+		}
+	}
+}
+
+func TestAppendItemErrorsCaps(t *testing.T) {
+	batch := func(n int) []models.ItemError {
+		errs := make([]models.ItemError, n)
+		for i := range errs {
+			errs[i] = models.ItemError{Index: i, Reasons: []string{"name is required"}}
+		}
+		return errs
+	}
+
+	errs := appendItemErrors(nil, batch(maxImportJobItemErrors-10))
+	if len(errs) != maxImportJobItemErrors-10 {
+		t.Fatalf("kept %d errors", len(errs))
+	}
+	errs = appendItemErrors(errs, batch(25))
+	if len(errs) != maxImportJobItemErrors {
+		t.Fatalf("kept %d errors, want the cap %d", len(errs), maxImportJobItemErrors)
+	}
+	if errs[len(errs)-1].Index != 9 {
+		t.Fatalf("kept the wrong errors, last index %d", errs[len(errs)-1].Index)
+	}
+	if errs = appendItemErrors(errs, batch(1)); len(errs) != maxImportJobItemErrors {
+		t.Fatalf("kept %d errors past the cap", len(errs))
+	}
+}
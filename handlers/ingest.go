@@ -0,0 +1,411 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"product-api/models"
+	"product-api/utils"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ingestBatchSize is the number of products written per upsert statement
+const ingestBatchSize = 1000
+
+// upsertColumns are overwritten when an incoming product matches an existing product URL.
+// id and created_at are deliberately left out so the stored product keeps its identity.
+var upsertColumns = []string{
+	"name", "brand", "price", "currency", "price_in_rubles", "discounted_price",
+	"description", "images", "sizes", "colors", "product_url", "store", "category",
+	"processed_at", "is_active", "stock_status", "stock", "updated_at",
+	"last_seen_at", "deactivated_at", "deactivation_reason", "discounted_price_in_rubles",
+	"converted_prices", "canonical_url", "original_images", "external_id",
+}
+
+// ingestStats summarises what an ingestion run did
+type ingestStats struct {
+	Received   int
+	Inserted   int
+	Updated    int
+	Duplicates int
+	Rejected   int
+}
+
+// add accumulates the counters of another run into s
+func (s *ingestStats) add(other ingestStats) {
+	s.Received += other.Received
+	s.Inserted += other.Inserted
+	s.Updated += other.Updated
+	s.Duplicates += other.Duplicates
+	s.Rejected += other.Rejected
+}
+
+// ingestProducts validates, dedups, prices and upserts products in batches of ingestBatchSize.
+// Invalid products and rows the database refuses are reported per item while the
+// rest of the payload is still stored.
+func (h *ProductHandler) ingestProducts(products []models.Product) (ingestStats, []models.ItemError, error) {
+	stats := ingestStats{Received: len(products)}
+
+	unique, indexes, duplicates, rejected := prepareProducts(products)
+	stats.Duplicates = len(duplicates)
+
+	for i := 0; i < len(unique); i += ingestBatchSize {
+		end := i + ingestBatchSize
+		if end > len(unique) {
+			end = len(unique)
+		}
+
+		batchStats, batchRejected, err := h.writeBatch(unique[i:end], indexes[i:end], i)
+		rejected = append(rejected, batchRejected...)
+		if err != nil {
+			stats.Rejected = len(rejected)
+			return stats, rejected, fmt.Errorf("batch %d-%d: %w", i, end-1, err)
+		}
+		stats.Inserted += batchStats.Inserted
+		stats.Updated += batchStats.Updated
+		log.Printf("[DEBUG] ingestProducts: Upserted batch %d-%d (%d inserted, %d updated, %d rejected)",
+			i, end-1, batchStats.Inserted, batchStats.Updated, len(batchRejected))
+	}
+
+	stats.Rejected = len(rejected)
+	return stats, rejected, nil
+}
+
+// prepareProducts validates products and drops duplicate URLs.
+// It returns the products to write, their positions in the input, the positions
+// of the dropped duplicates and the rejected items.
+func prepareProducts(products []models.Product) ([]models.Product, []int, []int, []models.ItemError) {
+	valid := make([]models.Product, 0, len(products))
+	validIndexes := make([]int, 0, len(products))
+	var rejected []models.ItemError
+
+	for i := range products {
+		if reasons := products[i].Validate(); len(reasons) > 0 {
+			rejected = append(rejected, models.ItemError{Index: i, ID: products[i].ID, Reasons: reasons})
+			continue
+		}
+		products[i].CanonicalURL = utils.CanonicalURL(products[i].ProductURL)
+		valid = append(valid, products[i])
+		validIndexes = append(validIndexes, i)
+	}
+	if len(rejected) > 0 {
+		log.Printf("[WARN] prepareProducts: Rejected %d of %d products during validation", len(rejected), len(products))
+	}
+
+	unique, kept, dropped := dedupProducts(valid)
+	indexes := make([]int, len(kept))
+	for k, j := range kept {
+		indexes[k] = validIndexes[j]
+	}
+	duplicates := make([]int, len(dropped))
+	for k, j := range dropped {
+		duplicates[k] = validIndexes[j]
+	}
+
+	return unique, indexes, duplicates, rejected
+}
+
+// writeBatch upserts a batch and, when the database rejects it because of bad data,
+// retries the rows one by one so only the offending rows are reported.
+// Errors that are not caused by the data (connection loss, ...) are returned as is.
+func (h *ProductHandler) writeBatch(batch []models.Product, indexes []int, offset int) (ingestStats, []models.ItemError, error) {
+	// Images are fetched before the transaction, downloads must not hold row locks
+	h.mirrorImages(batch)
+
+	stats, err := h.upsertBatch(batch, offset)
+	if err == nil {
+		return stats, nil, nil
+	}
+	if !isDataError(err) {
+		return ingestStats{}, nil, err
+	}
+
+	log.Printf("[WARN] writeBatch: Batch rejected by the database (%v), retrying %d rows individually", err, len(batch))
+	stats = ingestStats{}
+	var rejected []models.ItemError
+	for k := range batch {
+		rowStats, rowErr := h.upsertBatch(batch[k:k+1], offset+k)
+		if rowErr != nil {
+			if !isDataError(rowErr) {
+				return stats, rejected, rowErr
+			}
+			rejected = append(rejected, models.ItemError{
+				Index:   indexes[k],
+				ID:      batch[k].ID,
+				Reasons: []string{rowErr.Error()},
+			})
+			continue
+		}
+		stats.Inserted += rowStats.Inserted
+		stats.Updated += rowStats.Updated
+	}
+
+	return stats, rejected, nil
+}
+
+// isDataError reports whether err is a Postgres data exception (class 22) or
+// integrity constraint violation (class 23), i.e. caused by the rows themselves
+func isDataError(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return strings.HasPrefix(pgErr.Code, "22") || strings.HasPrefix(pgErr.Code, "23")
+}
+
+// dedupProducts drops products whose CanonicalURL already appeared earlier in the slice.
+// The first occurrence wins, matching the order scrapers emit products in.
+// The returned indexes give the position of every kept and every dropped product in the input.
+func dedupProducts(products []models.Product) ([]models.Product, []int, []int) {
+	seenURLs := make(map[string]bool, len(products))
+	unique := make([]models.Product, 0, len(products))
+	indexes := make([]int, 0, len(products))
+	var duplicates []int
+
+	for i, product := range products {
+		if product.CanonicalURL != "" {
+			if seenURLs[product.CanonicalURL] {
+				log.Printf("[WARN] dedupProducts: Duplicate productUrl found in batch: %s, skipping", product.ProductURL)
+				duplicates = append(duplicates, i)
+				continue
+			}
+			seenURLs[product.CanonicalURL] = true
+		}
+		unique = append(unique, product)
+		indexes = append(indexes, i)
+	}
+
+	return unique, indexes, duplicates
+}
+
+// upsertBatch writes one batch of already deduplicated products.
+// It costs one lookup query for IDs and URLs that are already stored plus one
+// INSERT ... ON CONFLICT statement, both inside a single transaction, plus the
+// price_history and alert delivery rows for products whose prices or stock changed,
+// the reference counts of stored images the batch starts or stops using and, with
+// MIRROR_IMAGES, the download jobs of remote images.
+// offset is the position of the batch in the request and only feeds generated IDs.
+func (h *ProductHandler) upsertBatch(batch []models.Product, offset int) (ingestStats, error) {
+	var stats ingestStats
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		replaced, err := h.resolveBatch(tx, batch, offset, nil)
+		if err != nil {
+			return err
+		}
+
+		for i := range batch {
+			if replaced[i] != nil {
+				stats.Updated++
+			} else {
+				stats.Inserted++
+			}
+		}
+
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:     []clause.Column{{Name: "canonical_url"}},
+			TargetWhere: clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "canonical_url <> ''"}}},
+			DoUpdates:   clause.AssignmentColumns(upsertColumns),
+		}).Create(&batch).Error; err != nil {
+			return err
+		}
+
+		refs := utils.ImageRefs{}
+		for i := range batch {
+			if replaced[i] != nil {
+				refs.Replace(replaced[i].Images, batch[i].Images)
+			} else {
+				refs.Replace(nil, batch[i].Images)
+			}
+		}
+		if err := refs.Apply(tx); err != nil {
+			return err
+		}
+		if h.Config.MirrorImages {
+			if err := queueImageJobs(tx, batch); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		if history := priceHistoryEntries(batch, replaced, now); len(history) > 0 {
+			if err := tx.Create(&history).Error; err != nil {
+				return err
+			}
+		}
+		return queueAlerts(tx, batch, replaced, now)
+	})
+	if err == nil && h.Config.MirrorImages {
+		h.wakeImageWorkers()
+	}
+
+	return stats, err
+}
+
+// resolveBatch matches a batch against stored products, assigns unique IDs and
+// computes derived prices without writing anything. The result holds, per product,
+// the stored product it replaces or nil for a new product. IDs in reserved are
+// treated as taken, which lets a dry run span several batches.
+func (h *ProductHandler) resolveBatch(tx *gorm.DB, batch []models.Product, offset int, reserved map[string]bool) ([]*models.Product, error) {
+	candidates := make([]string, len(batch))
+	for i := range batch {
+		candidates[i] = candidateID(batch[i], offset+i)
+	}
+
+	existingByURL, takenIDs, err := lookupExisting(tx, batch, candidates)
+	if err != nil {
+		return nil, err
+	}
+	for id := range reserved {
+		takenIDs[id] = true
+	}
+
+	if err := assignIDs(tx, batch, candidates, existingByURL, takenIDs); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	replaced := make([]*models.Product, len(batch))
+	for i := range batch {
+		if existing, ok := existingByURL[batch[i].CanonicalURL]; ok && batch[i].CanonicalURL != "" {
+			replaced[i] = &existing
+		}
+		h.Pricing.Apply(&batch[i])
+		h.Currency.Apply(&batch[i])
+
+		// Seen again, so a product deactivated by an earlier sync comes back
+		batch[i].LastSeenAt = &now
+		batch[i].DeactivatedAt = nil
+		batch[i].DeactivationReason = ""
+	}
+
+	return replaced, nil
+}
+
+// lookupColumns are loaded for stored products matching an incoming batch
+const lookupColumns = "id, product_url, canonical_url, name, currency, price, discounted_price, price_in_rubles, discounted_price_in_rubles, stock_status, stock, images"
+
+// lookupExisting loads the stored products sharing a URL or a candidate ID with the batch
+func lookupExisting(tx *gorm.DB, batch []models.Product, candidates []string) (map[string]models.Product, map[string]bool, error) {
+	urls := make([]string, 0, len(batch))
+	for _, product := range batch {
+		if product.CanonicalURL != "" {
+			urls = append(urls, product.CanonicalURL)
+		}
+	}
+
+	var rows []models.Product
+	if err := tx.Model(&models.Product{}).Select(lookupColumns).
+		Where("canonical_url IN ? OR id IN ?", urls, candidates).
+		Find(&rows).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to look up existing products: %w", err)
+	}
+
+	existingByURL := make(map[string]models.Product, len(rows))
+	takenIDs := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		if row.CanonicalURL != "" {
+			existingByURL[row.CanonicalURL] = row
+		}
+		takenIDs[row.ID] = true
+	}
+
+	return existingByURL, takenIDs, nil
+}
+
+// assignIDs gives every product of the batch a unique ID.
+// Products matching a stored URL keep the stored ID; the rest get their own or a
+// generated ID, suffixed with -N when it is already used by another product.
+func assignIDs(tx *gorm.DB, batch []models.Product, candidates []string, existingByURL map[string]models.Product, takenIDs map[string]bool) error {
+	assigned := make(map[string]bool, len(batch))
+
+	// Stored products claim their IDs first so new products cannot take them
+	for i := range batch {
+		if existing, ok := existingByURL[batch[i].CanonicalURL]; ok && batch[i].CanonicalURL != "" {
+			batch[i].ID = existing.ID
+			assigned[existing.ID] = true
+		}
+	}
+
+	var collided []int
+	for i := range batch {
+		if _, ok := existingByURL[batch[i].CanonicalURL]; ok && batch[i].CanonicalURL != "" {
+			continue
+		}
+		id := candidates[i]
+		if takenIDs[id] || assigned[id] {
+			collided = append(collided, i)
+			batch[i].ID = id
+			continue
+		}
+		batch[i].ID = id
+		assigned[id] = true
+	}
+
+	if len(collided) == 0 {
+		return nil
+	}
+
+	// Only colliding IDs need a second query, to learn which -N suffixes are in use
+	patterns := make([]string, 0, len(collided))
+	for _, i := range collided {
+		patterns = append(patterns, escapeLike(batch[i].ID)+"-%")
+	}
+	var suffixed []string
+	if err := tx.Model(&models.Product{}).Where("id LIKE ANY (ARRAY[?])", patterns).
+		Pluck("id", &suffixed).Error; err != nil {
+		return fmt.Errorf("failed to look up suffixed IDs: %w", err)
+	}
+	for _, id := range suffixed {
+		takenIDs[id] = true
+	}
+
+	for _, i := range collided {
+		baseID := batch[i].ID
+		counter := 1
+		newID := fmt.Sprintf("%s-%d", baseID, counter)
+		for takenIDs[newID] || assigned[newID] {
+			counter++
+			newID = fmt.Sprintf("%s-%d", baseID, counter)
+		}
+		log.Printf("[WARN] assignIDs: Duplicate ID found (batch or DB): %s, using %s", baseID, newID)
+		batch[i].ID = newID
+		assigned[newID] = true
+	}
+
+	return nil
+}
+
+// candidateID returns the ID a product would get before uniqueness is enforced
+func candidateID(product models.Product, index int) string {
+	if product.ID != "" {
+		return product.ID
+	}
+	if product.Name != "" {
+		return slugify(product.Name)
+	}
+	return fmt.Sprintf("product-%d-%d", time.Now().Unix(), index)
+}
+
+// slugify builds a URL friendly ID from a product name
+func slugify(name string) string {
+	// Clean Turkish characters and create base ID
+	baseID := strings.ToLower(name)
+	baseID = strings.NewReplacer(
+		"ç", "c", "ğ", "g", "ı", "i", "ö", "o", "ş", "s", "ü", "u",
+	).Replace(baseID)
+	// Replace spaces and special characters
+	return strings.NewReplacer(
+		" ", "-", ".", "", ",", "", "(", "", ")", "", "/", "-", "\\", "-",
+	).Replace(baseID)
+}
+
+// escapeLike escapes LIKE wildcards so an ID can be used as a literal prefix
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"math"
+	"product-api/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dryRunItem describes what ingestion would do with one product of the payload
+type dryRunItem struct {
+	Index         int                    `json:"index"`
+	ID            string                 `json:"id"`
+	Name          string                 `json:"name"`
+	ProductURL    string                 `json:"productUrl,omitempty"`
+	PriceInRubles *float64               `json:"priceInRubles,omitempty"`
+	Changes       map[string]fieldChange `json:"changes,omitempty"`
+	DuplicateOf   *int                   `json:"duplicateOf,omitempty"`
+}
+
+// fieldChange is one field that differs between a stored product and its replacement
+type fieldChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// dryRunProducts runs validation, dedup, ID assignment and pricing exactly like
+// ingestProducts, but only reads from the database. It returns a report of the
+// products that would be inserted, replaced, skipped as duplicates or rejected.
+func (h *ProductHandler) dryRunProducts(products []models.Product) (gin.H, error) {
+	unique, indexes, duplicates, rejected := prepareProducts(products)
+
+	inserts := make([]dryRunItem, 0, len(unique))
+	replaces := make([]dryRunItem, 0)
+	// IDs handed out in earlier batches, a real run would have stored them already
+	reserved := make(map[string]bool, len(unique))
+
+	for i := 0; i < len(unique); i += ingestBatchSize {
+		end := i + ingestBatchSize
+		if end > len(unique) {
+			end = len(unique)
+		}
+
+		batch := unique[i:end]
+		replaced, err := h.resolveBatch(h.DB, batch, i, reserved)
+		if err != nil {
+			return nil, err
+		}
+
+		for k := range batch {
+			reserved[batch[k].ID] = true
+			item := dryRunItem{
+				Index:         indexes[i+k],
+				ID:            batch[k].ID,
+				Name:          batch[k].Name,
+				ProductURL:    batch[k].ProductURL,
+				PriceInRubles: batch[k].PriceInRubles,
+			}
+			if replaced[k] == nil {
+				inserts = append(inserts, item)
+				continue
+			}
+			item.Changes = diffProducts(replaced[k], &batch[k])
+			replaces = append(replaces, item)
+		}
+	}
+
+	// Point every skipped duplicate at the product that wins its URL
+	firstByURL := make(map[string]int, len(unique))
+	for k := range unique {
+		if unique[k].CanonicalURL != "" {
+			firstByURL[unique[k].CanonicalURL] = indexes[k]
+		}
+	}
+	skipped := make([]dryRunItem, 0, len(duplicates))
+	for _, index := range duplicates {
+		first := firstByURL[products[index].CanonicalURL]
+		skipped = append(skipped, dryRunItem{
+			Index:       index,
+			ID:          products[index].ID,
+			Name:        products[index].Name,
+			ProductURL:  products[index].ProductURL,
+			DuplicateOf: &first,
+		})
+	}
+
+	return gin.H{
+		"dryRun": true,
+		"summary": gin.H{
+			"received": len(products),
+			"insert":   len(inserts),
+			"replace":  len(replaces),
+			"skipped":  len(skipped),
+			"rejected": len(rejected),
+		},
+		"insert":   inserts,
+		"replace":  replaces,
+		"skipped":  skipped,
+		"rejected": rejected,
+	}, nil
+}
+
+// diffProducts lists the fields of a stored product that a replacement would change
+func diffProducts(stored, incoming *models.Product) map[string]fieldChange {
+	changes := make(map[string]fieldChange)
+
+	if stored.Name != incoming.Name {
+		changes["name"] = fieldChange{From: stored.Name, To: incoming.Name}
+	}
+	if stored.Currency != incoming.Currency {
+		changes["currency"] = fieldChange{From: stored.Currency, To: incoming.Currency}
+	}
+	if roundPrice(stored.Price) != roundPrice(incoming.Price) {
+		changes["price"] = fieldChange{From: stored.Price, To: incoming.Price}
+	}
+	if !pricesEqual(stored.DiscountedPrice, incoming.DiscountedPrice) {
+		changes["discountedPrice"] = fieldChange{From: stored.DiscountedPrice, To: incoming.DiscountedPrice}
+	}
+	if !pricesEqual(stored.PriceInRubles, incoming.PriceInRubles) {
+		changes["priceInRubles"] = fieldChange{From: stored.PriceInRubles, To: incoming.PriceInRubles}
+	}
+	if !pricesEqual(stored.DiscountedPriceInRubles, incoming.DiscountedPriceInRubles) {
+		changes["discountedPriceInRubles"] = fieldChange{From: stored.DiscountedPriceInRubles, To: incoming.DiscountedPriceInRubles}
+	}
+	if stored.StockStatus != incoming.StockStatus {
+		changes["stockStatus"] = fieldChange{From: stored.StockStatus, To: incoming.StockStatus}
+	}
+
+	return changes
+}
+
+// roundPrice rounds a price to the two decimals stored by the decimal(10,2) columns
+func roundPrice(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+// pricesEqual compares optional prices at stored precision
+func pricesEqual(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return roundPrice(*a) == roundPrice(*b)
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"product-api/models"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// ndjsonContentType is the media type accepted by the streaming ingestion endpoint
+	ndjsonContentType = "application/x-ndjson"
+	// maxReportedDecodeErrors caps the per-line errors echoed in the summary line
+	maxReportedDecodeErrors = 100
+	// maxNDJSONLineSize bounds a single line, room for a product with inline base64 images
+	maxNDJSONLineSize = 32 << 20
+)
+
+// BulkCreateProductsNDJSON ingests a stream of products, one JSON object per line.
+// Products are decoded line by line and upserted in bounded batches, so memory use
+// does not grow with the size of the push. Progress is streamed back as NDJSON:
+// one line per flushed batch followed by a final summary line. Errors refer to the
+// 1-based physical line of the body; blank lines are skipped.
+func (h *ProductHandler) BulkCreateProductsNDJSON(c *gin.Context) {
+	log.Printf("[DEBUG] BulkCreateProductsNDJSON: Received POST request from %s", c.ClientIP())
+
+	mediaType, _, _ := mime.ParseMediaType(c.ContentType())
+	if mediaType != ndjsonContentType {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be " + ndjsonContentType})
+		return
+	}
+
+	batchSize := ingestBatchSize
+	if batchSizeStr := c.Query("batchSize"); batchSizeStr != "" {
+		if parsed, err := strconv.Atoi(batchSizeStr); err == nil && parsed > 0 && parsed < ingestBatchSize {
+			batchSize = parsed
+		}
+	}
+
+	// Progress lines are written while the body is still being read
+	if err := http.NewResponseController(c.Writer).EnableFullDuplex(); err != nil {
+		log.Printf("[WARN] BulkCreateProductsNDJSON: Full duplex not supported: %v", err)
+	}
+
+	c.Header("Content-Type", ndjsonContentType)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Status(http.StatusOK)
+
+	encoder := json.NewEncoder(c.Writer)
+	writeLine := func(line gin.H) {
+		if err := encoder.Encode(line); err != nil {
+			log.Printf("[ERROR] BulkCreateProductsNDJSON: Failed to write progress: %v", err)
+			return
+		}
+		c.Writer.Flush()
+	}
+
+	scanner := bufio.NewScanner(c.Request.Body)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxNDJSONLineSize)
+	var totals ingestStats
+	var decodeErrors []gin.H
+	decodeErrorCount := 0
+	batch := make([]models.Product, 0, batchSize)
+	batchLines := make([]int, 0, batchSize)
+	batchNumber := 0
+	line := 0
+
+	flush := func() bool {
+		if len(batch) == 0 {
+			return true
+		}
+		batchNumber++
+
+		unique, indexes, duplicates, rejected := prepareProducts(batch)
+		stats, writeRejected, err := h.writeBatch(unique, indexes, batchLines[0])
+		rejected = append(rejected, writeRejected...)
+		stats.Received = len(batch)
+		stats.Duplicates = len(duplicates)
+		stats.Rejected = len(rejected)
+
+		// Report rejected items by their 1-based line in the stream
+		rejectedLines := make([]gin.H, 0, len(rejected))
+		for _, item := range rejected {
+			rejectedLines = append(rejectedLines, gin.H{
+				"line":    batchLines[item.Index],
+				"id":      item.ID,
+				"reasons": item.Reasons,
+			})
+		}
+		batch = batch[:0]
+		batchLines = batchLines[:0]
+
+		if err != nil {
+			log.Printf("[ERROR] BulkCreateProductsNDJSON: Failed to upsert batch %d: %v", batchNumber, err)
+			writeLine(gin.H{
+				"batch":   batchNumber,
+				"error":   "Failed to upsert products batch",
+				"details": err.Error(),
+			})
+			return false
+		}
+
+		totals.add(stats)
+		writeLine(gin.H{
+			"batch":      batchNumber,
+			"received":   stats.Received,
+			"inserted":   stats.Inserted,
+			"updated":    stats.Updated,
+			"duplicates": stats.Duplicates,
+			"rejected":   rejectedLines,
+			"processed":  line,
+		})
+		return true
+	}
+
+	for scanner.Scan() {
+		line++
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+
+		var product models.Product
+		if err := json.Unmarshal(raw, &product); err != nil {
+			// A type mismatch only spoils the current product
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				decodeErrorCount++
+				if len(decodeErrors) < maxReportedDecodeErrors {
+					decodeErrors = append(decodeErrors, gin.H{"line": line, "error": err.Error()})
+				}
+				continue
+			}
+
+			log.Printf("[ERROR] BulkCreateProductsNDJSON: Invalid NDJSON at line %d: %v", line, err)
+			if flush() {
+				writeLine(gin.H{"line": line, "error": "Invalid NDJSON", "details": err.Error()})
+			}
+			return
+		}
+
+		batch = append(batch, product)
+		batchLines = append(batchLines, line)
+		if len(batch) >= batchSize {
+			if !flush() {
+				return
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		line++
+		message := "Failed to read request body"
+		if errors.Is(err, bufio.ErrTooLong) {
+			message, err = "Invalid NDJSON", fmt.Errorf("line is longer than %d bytes", maxNDJSONLineSize)
+		}
+		log.Printf("[ERROR] BulkCreateProductsNDJSON: Failed to read line %d: %v", line, err)
+		if flush() {
+			writeLine(gin.H{"line": line, "error": message, "details": err.Error()})
+		}
+		return
+	}
+
+	if !flush() {
+		return
+	}
+
+	log.Printf("[SUCCESS] BulkCreateProductsNDJSON: Streamed %d products in %d batches (%d inserted, %d updated, %d duplicates, %d rejected, %d undecodable)",
+		totals.Received, batchNumber, totals.Inserted, totals.Updated, totals.Duplicates, totals.Rejected, decodeErrorCount)
+	writeLine(gin.H{
+		"done":          true,
+		"message":       "Products upserted successfully",
+		"batches":       batchNumber,
+		"received":      totals.Received,
+		"inserted":      totals.Inserted,
+		"updated":       totals.Updated,
+		"duplicates":    totals.Duplicates,
+		"rejected":      totals.Rejected,
+		"decode_errors": decodeErrorCount,
+		"errors":        decodeErrors,
+	})
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// postNDJSON sends body to BulkCreateProductsNDJSON and returns the response lines
+func postNDJSON(t *testing.T, body string) []map[string]interface{} {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	db, _ := newFakeDB(t, nil)
+	h := newTestProductHandler(db)
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/stock/add/ndjson", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", ndjsonContentType)
+	h.BulkCreateProductsNDJSON(c)
+
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(recorder.Body)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("response line %q is not JSON: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestBulkCreateProductsNDJSONReportsPhysicalLines(t *testing.T) {
+	body := `{"_id": "p-1", "name": "Ürün 1", "price": 99.99, "currency": "TRY", "productUrl": "https://example.com/p-1", "store": "zara"}
+
+{"_id": "p-2", "name": "Ürün 2", "price": "expensive", "currency": "TRY", "store": "zara"}
+{"_id": "p-3", "price": 10, "currency": "TRY", "productUrl": "https://example.com/p-3", "store": "zara"}
+`
+	lines := postNDJSON(t, body)
+	if len(lines) != 2 {
+		t.Fatalf("got %d response lines, want a batch and a summary: %v", len(lines), lines)
+	}
+
+	rejected, _ := lines[0]["rejected"].([]interface{})
+	if len(rejected) != 1 || rejected[0].(map[string]interface{})["line"] != float64(4) {
+		t.Fatalf("rejected %v, want the product without a name on line 4", lines[0]["rejected"])
+	}
+
+	summary := lines[1]
+	errs, _ := summary["errors"].([]interface{})
+	if summary["done"] != true || len(errs) != 1 || errs[0].(map[string]interface{})["line"] != float64(3) {
+		t.Fatalf("summary %v, want the undecodable price on line 3", summary)
+	}
+}
+
+func TestBulkCreateProductsNDJSONInvalidLine(t *testing.T) {
+	body := "\n\n{\"_id\": \"p-1\", \"name\": \"Ürün 1\", \"price\": 1, \"currency\": \"TRY\", \"store\": \"zara\"}\n{not json}\n"
+	lines := postNDJSON(t, body)
+	last := lines[len(lines)-1]
+	if last["error"] != "Invalid NDJSON" || last["line"] != float64(4) {
+		t.Fatalf("last response line %v, want an NDJSON error on line 4", last)
+	}
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"product-api/config"
+	"product-api/database"
+	"product-api/models"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// loadFixture reads the products of test_products_1000.json at the repository root
+func loadFixture(tb testing.TB) []models.Product {
+	tb.Helper()
+	raw, err := os.ReadFile("../test_products_1000.json")
+	if err != nil {
+		tb.Fatalf("failed to read fixture: %v", err)
+	}
+	var products []models.Product
+	if err := json.Unmarshal(raw, &products); err != nil {
+		tb.Fatalf("failed to parse fixture: %v", err)
+	}
+	return products
+}
+
+// newTestProductHandler creates a product handler with the default configuration
+func newTestProductHandler(db *gorm.DB) *ProductHandler {
+	return NewProductHandler(db, &config.Config{TargetCurrencies: []string{"RUB"}}, nil)
+}
+
+// benchmarkIngest ingests the fixture b.N times; fresh rewrites URLs every run so
+// all products are inserts, otherwise every run after the first updates them
+func benchmarkIngest(b *testing.B, h *ProductHandler, fresh bool) {
+	fixture := loadFixture(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		products := make([]models.Product, len(fixture))
+		copy(products, fixture)
+		if fresh {
+			for k := range products {
+				products[k].ID = fmt.Sprintf("%s-%d", products[k].ID, i)
+				products[k].ProductURL = fmt.Sprintf("%s?run=%d", products[k].ProductURL, i)
+			}
+		}
+		stats, _, err := h.ingestProducts(products)
+		if err != nil {
+			b.Fatalf("ingestion failed: %v", err)
+		}
+		if stats.Inserted+stats.Updated == 0 {
+			b.Fatalf("nothing was written: %+v", stats)
+		}
+	}
+}
+
+// BenchmarkIngestProducts ingests the 1000 product fixture against a fake database
+// and reports the statements sent per run, BEGIN and COMMIT included
+func BenchmarkIngestProducts(b *testing.B) {
+	db, fake := newFakeDB(b, nil)
+	h := newTestProductHandler(db)
+	benchmarkIngest(b, h, false)
+	b.ReportMetric(float64(fake.Count())/float64(b.N), "queries/op")
+}
+
+// BenchmarkIngestProductsPostgres ingests the fixture against the database of
+// TEST_DATABASE_URL, which it migrates and writes to
+func BenchmarkIngestProductsPostgres(b *testing.B) {
+	databaseURL := os.Getenv("TEST_DATABASE_URL")
+	if databaseURL == "" {
+		b.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := database.Initialize(databaseURL)
+	if err != nil {
+		b.Fatalf("failed to connect: %v", err)
+	}
+	db.Logger = db.Logger.LogMode(0)
+	if err := database.Migrate(db); err != nil {
+		b.Fatalf("failed to migrate: %v", err)
+	}
+	h := newTestProductHandler(db)
+	if err := h.Pricing.Reload(); err != nil {
+		b.Fatalf("failed to load pricing rules: %v", err)
+	}
+
+	b.Run("insert", func(b *testing.B) { benchmarkIngest(b, h, true) })
+	b.Run("update", func(b *testing.B) { benchmarkIngest(b, h, false) })
+}
+
+// TestIngestProductsRoundTrips checks that a batch costs a constant number of
+// statements instead of several per product
+func TestIngestProductsRoundTrips(t *testing.T) {
+	db, fake := newFakeDB(t, nil)
+	h := newTestProductHandler(db)
+
+	stats, rejected, err := h.ingestProducts(loadFixture(t))
+	if err != nil {
+		t.Fatalf("ingestion failed: %v", err)
+	}
+	if len(rejected) != 0 {
+		t.Fatalf("unexpected rejections: %+v", rejected)
+	}
+	if stats.Inserted == 0 {
+		t.Fatalf("nothing inserted: %+v", stats)
+	}
+	if n := fake.Count(); n > 10 {
+		for _, statement := range fake.Statements() {
+			t.Log(statement.Query)
+		}
+		t.Fatalf("%d statements for %d products, want at most 10", n, stats.Received)
+	}
+	if upserts := fake.Find("INSERT INTO \"products\"", "ON CONFLICT"); len(upserts) != 1 {
+		t.Fatalf("%d upsert statements, want 1", len(upserts))
+	}
+}
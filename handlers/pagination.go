@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"product-api/models"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// pageCursor is the position after the last product of a page: the values of its
+// sort keys, as Postgres returned them, and its ID. Clients receive it base64
+// encoded and must treat it as opaque.
+type pageCursor struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+	ID     string   `json:"i"`
+}
+
+// errInvalidCursor is returned for a cursor that was not issued by this API
+var errInvalidCursor = errors.New("cursor is invalid")
+
+// errCursorSortMismatch is returned for a cursor issued for another sort order
+var errCursorSortMismatch = errors.New("cursor was issued for a different sort order")
+
+// parseCursor decodes the ?cursor= parameter; an empty value means offset pagination
+func parseCursor(c *gin.Context, order sortOrder) (*pageCursor, error) {
+	raw := c.Query("cursor")
+	if raw == "" {
+		return nil, nil
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var cursor pageCursor
+	if err := json.Unmarshal(decoded, &cursor); err != nil || cursor.ID == "" || len(cursor.Values) != len(order) {
+		return nil, errInvalidCursor
+	}
+	if cursor.Sort != order.String() {
+		return nil, errCursorSortMismatch
+	}
+	return &cursor, nil
+}
+
+// pageRow is a product of a page with the sort values of its row, selected as
+// text by the page query so a cursor compares exactly like the rows themselves
+type pageRow struct {
+	models.Product
+	SortValues string `gorm:"column:sort_values"`
+}
+
+// sortValuesColumn selects the sort values of a row as a JSON array of their text form
+func sortValuesColumn(order sortOrder) string {
+	exprs := order.expressions()
+	for i, expr := range exprs {
+		exprs[i] = "(" + expr + ")::text"
+	}
+	return "json_build_array(" + strings.Join(exprs, ", ") + ")::text AS sort_values"
+}
+
+// paginate selects columns of one sorted page of a products query, together with
+// the sort values nextPage builds the next cursor from. With a cursor the page
+// starts right after it, a seek that uses the sort indexes and is not thrown off
+// by rows inserted meanwhile; otherwise offset is used. One extra row is fetched
+// so nextPage can tell whether another page follows.
+func paginate(db *gorm.DB, columns string, order sortOrder, cursor *pageCursor, limit, offset int) *gorm.DB {
+	db = db.Table("products").Select(columns + ", " + sortValuesColumn(order)).
+		Order(order.orderBy()).Limit(limit + 1)
+	if cursor == nil {
+		return db.Offset(offset)
+	}
+
+	exprs := order.expressions()
+	args := make([]interface{}, 0, len(exprs)+1)
+	for _, value := range cursor.Values {
+		args = append(args, value)
+	}
+	args = append(args, cursor.ID)
+
+	// A single direction allows a row comparison, which maps straight onto an index
+	uniform := true
+	for _, key := range order {
+		uniform = uniform && key.Desc == order.idDesc()
+	}
+	if uniform {
+		operator := ">"
+		if order.idDesc() {
+			operator = "<"
+		}
+		return db.Where("("+strings.Join(exprs, ", ")+", id) "+operator+" ("+placeholders(len(args))+")", args...)
+	}
+
+	// Mixed directions: (k1 after v1) OR (k1 = v1 AND k2 after v2) OR ... OR (all equal AND id after)
+	var terms []string
+	var termArgs []interface{}
+	for i := 0; i <= len(exprs); i++ {
+		var conditions []string
+		for j := 0; j < i; j++ {
+			conditions = append(conditions, exprs[j]+" = ?")
+			termArgs = append(termArgs, args[j])
+		}
+		expr, desc := "id", order.idDesc()
+		if i < len(exprs) {
+			expr, desc = exprs[i], order[i].Desc
+		}
+		operator := " > ?"
+		if desc {
+			operator = " < ?"
+		}
+		conditions = append(conditions, expr+operator)
+		termArgs = append(termArgs, args[i])
+		terms = append(terms, "("+strings.Join(conditions, " AND ")+")")
+	}
+	return db.Where("("+strings.Join(terms, " OR ")+")", termArgs...)
+}
+
+// nextPage drops the extra row fetched by paginate and returns the products of the
+// page with the cursor of the next page, empty on the last page
+func nextPage(rows []pageRow, limit int, order sortOrder) ([]models.Product, string, error) {
+	more := len(rows) > limit
+	if more {
+		rows = rows[:limit]
+	}
+	products := make([]models.Product, len(rows))
+	for i := range rows {
+		products[i] = rows[i].Product
+	}
+	if !more {
+		return products, "", nil
+	}
+
+	last := rows[limit-1]
+	var values []string
+	if err := json.Unmarshal([]byte(last.SortValues), &values); err != nil || len(values) != len(order) {
+		return products, "", fmt.Errorf("unreadable sort values %q of product %s", last.SortValues, last.ID)
+	}
+	return products, encodeCursor(pageCursor{Sort: order.String(), Values: values, ID: last.ID}), nil
+}
+
+// encodeCursor returns the opaque ?cursor= form of a cursor
+func encodeCursor(cursor pageCursor) string {
+	encoded, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// paginationResponse describes the page in list responses. Cursor pages skip the
+// total count, counting a large table would cost more than the page itself.
+func paginationResponse(page, limit int, total int64, cursor *pageCursor, nextCursor string) gin.H {
+	response := gin.H{
+		"limit":      limit,
+		"nextCursor": nil,
+	}
+	if nextCursor != "" {
+		response["nextCursor"] = nextCursor
+	}
+	if cursor == nil {
+		response["page"] = page
+		response["total"] = total
+		response["totalPages"] = (total + int64(limit) - 1) / int64(limit)
+	}
+	return response
+}
+
+// placeholders returns n comma separated bind placeholders
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
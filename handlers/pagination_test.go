@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"product-api/models"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cursorContext returns a request context with the given query string
+func cursorContext(query url.Values) *gin.Context {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/stock/products?"+query.Encode(), nil)
+	return c
+}
+
+// pageRows returns n rows whose sort values are those of a price,-createdAt order
+func pageRows(n int) []pageRow {
+	rows := make([]pageRow, n)
+	for i := range rows {
+		rows[i].ID = fmt.Sprintf("p-%d", i)
+		rows[i].SortValues = fmt.Sprintf(`["%d.90", "2025-10-03 19:32:%02d.123456+00"]`, i, i)
+	}
+	return rows
+}
+
+func TestNextPageCursorRoundTrip(t *testing.T) {
+	order := sortOrder{{Field: "price"}, {Field: "createdAt", Desc: true}}
+
+	products, next, err := nextPage(pageRows(4), 3, order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(products) != 3 || products[2].ID != "p-2" {
+		t.Fatalf("page holds %d products, the last %q", len(products), products[len(products)-1].ID)
+	}
+	if next == "" {
+		t.Fatal("no cursor although another page follows")
+	}
+
+	cursor, err := parseCursor(cursorContext(url.Values{"cursor": {next}}), order)
+	if err != nil {
+		t.Fatalf("cursor issued by nextPage rejected: %v", err)
+	}
+	want := []string{"2.90", "2025-10-03 19:32:02.123456+00"}
+	if cursor.ID != "p-2" || cursor.Sort != "price,-createdAt" || strings.Join(cursor.Values, "|") != strings.Join(want, "|") {
+		t.Fatalf("cursor decoded to %+v", cursor)
+	}
+}
+
+func TestNextPageLastPage(t *testing.T) {
+	products, next, err := nextPage(pageRows(3), 3, defaultSortOrder)
+	if err != nil || next != "" || len(products) != 3 {
+		t.Fatalf("got %d products, cursor %q, err %v", len(products), next, err)
+	}
+}
+
+func TestNextPageUnreadableSortValues(t *testing.T) {
+	rows := pageRows(2)
+	rows[0].SortValues = ""
+	if _, _, err := nextPage(rows, 1, defaultSortOrder); err == nil {
+		t.Fatal("built a cursor without sort values")
+	}
+}
+
+func TestParseCursor(t *testing.T) {
+	order := sortOrder{{Field: "price"}, {Field: "createdAt", Desc: true}}
+	valid := encodeCursor(pageCursor{Sort: order.String(), Values: []string{"9.90", "2025-10-03 19:32:27+00"}, ID: "p-1"})
+	encode := func(raw string) string { return base64.RawURLEncoding.EncodeToString([]byte(raw)) }
+
+	if cursor, err := parseCursor(cursorContext(url.Values{}), order); cursor != nil || err != nil {
+		t.Fatalf("no cursor parameter gave %+v, %v", cursor, err)
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+		want   error
+	}{
+		{"not base64", "%%%", errInvalidCursor},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte(`{"s":"price,-createdAt","v":["1","2"],"i":"p-1"}`)) + "=", errInvalidCursor},
+		{"not JSON", encode("price=9.90"), errInvalidCursor},
+		{"truncated", valid[:len(valid)-6], errInvalidCursor},
+		{"missing ID", encode(`{"s":"price,-createdAt","v":["1","2"]}`), errInvalidCursor},
+		{"too few values", encode(`{"s":"price,-createdAt","v":["1"],"i":"p-1"}`), errInvalidCursor},
+		{"too many values", encode(`{"s":"price,-createdAt","v":["1","2","3"],"i":"p-1"}`), errInvalidCursor},
+		{"other sort order", encode(`{"s":"-price,-createdAt","v":["1","2"],"i":"p-1"}`), errCursorSortMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor, err := parseCursor(cursorContext(url.Values{"cursor": {tt.cursor}}), order)
+			if !errors.Is(err, tt.want) || cursor != nil {
+				t.Fatalf("got %+v, %v, want %v", cursor, err, tt.want)
+			}
+		})
+	}
+}
+
+// pageQuery runs paginate against a fake database and returns the statement sent
+func pageQuery(t *testing.T, order sortOrder, cursor *pageCursor) fakeStatement {
+	t.Helper()
+	db, fake := newFakeDB(t, nil)
+	var rows []pageRow
+	if err := paginate(db.Where("is_active = ?", true), "id", order, cursor, 20, 40).Find(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	statements := fake.Statements()
+	if len(statements) != 1 {
+		t.Fatalf("%d statements, want 1", len(statements))
+	}
+	return statements[0]
+}
+
+func TestPaginateOffset(t *testing.T) {
+	statement := pageQuery(t, defaultSortOrder, nil)
+	for _, fragment := range []string{
+		`SELECT id, json_build_array((created_at)::text)::text AS sort_values FROM "products"`,
+		"ORDER BY created_at DESC, id DESC",
+		"LIMIT $2 OFFSET $3",
+	} {
+		if !strings.Contains(statement.Query, fragment) {
+			t.Fatalf("query %q lacks %q", statement.Query, fragment)
+		}
+	}
+	if !containsArg(statement.Args, 21, int64(21)) {
+		t.Fatalf("page does not fetch one extra row: %v", statement.Args)
+	}
+}
+
+func TestPaginateSingleDirectionSeeks(t *testing.T) {
+	order := sortOrder{{Field: "price", Desc: true}, {Field: "createdAt", Desc: true}}
+	statement := pageQuery(t, order, &pageCursor{Sort: order.String(), Values: []string{"9.90", "2025-10-03 19:32:27+00"}, ID: "p-1"})
+
+	if !strings.Contains(statement.Query, "(price, created_at, id) < ($2, $3, $4)") {
+		t.Fatalf("query %q does not seek with a row comparison", statement.Query)
+	}
+	if strings.Contains(statement.Query, "OFFSET") {
+		t.Fatalf("cursor page still uses OFFSET: %q", statement.Query)
+	}
+	if fmt.Sprint(statement.Args[1:4]) != "[9.90 2025-10-03 19:32:27+00 p-1]" {
+		t.Fatalf("seek arguments %v", statement.Args)
+	}
+}
+
+func TestPaginateMixedDirectionsExpandsToOr(t *testing.T) {
+	order := sortOrder{{Field: "price"}, {Field: "createdAt", Desc: true}, {Field: "name"}}
+	statement := pageQuery(t, order, &pageCursor{Sort: order.String(), Values: []string{"9.90", "2025-10-03 19:32:27+00", "Dress"}, ID: "p-1"})
+
+	// The ID follows the direction of the first key, ascending here
+	want := "((price > $2) OR (price = $3 AND created_at < $4) OR (price = $5 AND created_at = $6 AND COALESCE(name, '') > $7) OR " +
+		"(price = $8 AND created_at = $9 AND COALESCE(name, '') = $10 AND id > $11))"
+	if !strings.Contains(statement.Query, want) {
+		t.Fatalf("query %q lacks %q", statement.Query, want)
+	}
+	wantArgs := "[true 9.90 9.90 2025-10-03 19:32:27+00 9.90 2025-10-03 19:32:27+00 Dress 9.90 2025-10-03 19:32:27+00 Dress p-1]"
+	if got := fmt.Sprint(statement.Args[:11]); got != wantArgs {
+		t.Fatalf("arguments %s, want %s", got, wantArgs)
+	}
+	if !strings.Contains(statement.Query, "ORDER BY price ASC, created_at DESC, COALESCE(name, '') ASC, id ASC") {
+		t.Fatalf("query %q is not sorted like the cursor compares", statement.Query)
+	}
+}
+
+func TestGetAllProductsBuildsCursorFromPageQuery(t *testing.T) {
+	const limit = 2
+	db, fake := newFakeDB(t, func(query string, args []interface{}) ([]string, [][]driver.Value) {
+		if !strings.Contains(query, `FROM "products"`) {
+			return nil, nil
+		}
+		var rows [][]driver.Value
+		for i, row := range pageRows(limit + 1) {
+			rows = append(rows, []driver.Value{row.ID, fmt.Sprintf("Ürün %d", i), "zara", []byte(row.SortValues)})
+		}
+		return []string{"id", "name", "store", "sort_values"}, rows
+	})
+	h := newTestProductHandler(db)
+
+	order := sortOrder{{Field: "price"}, {Field: "createdAt", Desc: true}}
+	first := encodeCursor(pageCursor{Sort: order.String(), Values: []string{"0.90", "2025-10-03 19:32:00+00"}, ID: "p-0"})
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/stock/products?"+url.Values{
+		"sort":   {order.String()},
+		"limit":  {fmt.Sprint(limit)},
+		"cursor": {first},
+	}.Encode(), nil)
+	h.GetAllProducts(c)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if n := fake.Count(); n != 1 {
+		for _, statement := range fake.Statements() {
+			t.Log(statement.Query)
+		}
+		t.Fatalf("%d statements for a cursor page, want the page query only", n)
+	}
+
+	var body struct {
+		Products   []models.Product `json:"products"`
+		Pagination struct {
+			NextCursor string `json:"nextCursor"`
+		} `json:"pagination"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Products) != limit {
+		t.Fatalf("page holds %d products, want %d", len(body.Products), limit)
+	}
+	cursor, err := parseCursor(cursorContext(url.Values{"cursor": {body.Pagination.NextCursor}}), order)
+	if err != nil {
+		t.Fatalf("next cursor %q: %v", body.Pagination.NextCursor, err)
+	}
+	if cursor.ID != "p-1" || cursor.Values[0] != "1.90" || cursor.Values[1] != "2025-10-03 19:32:01.123456+00" {
+		t.Fatalf("next cursor points after %+v, want the last product of the page", cursor)
+	}
+}
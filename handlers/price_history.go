@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"product-api/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// priceHistoryStats summarises a price timeline on the effective (discounted if any) price
+type priceHistoryStats struct {
+	Entries       int      `json:"entries"`
+	Current       float64  `json:"current"`
+	Previous      *float64 `json:"previous"`
+	ChangePercent *float64 `json:"changePercent"`
+	Min           float64  `json:"min"`
+	Max           float64  `json:"max"`
+	MinInRubles   *float64 `json:"minInRubles"`
+	MaxInRubles   *float64 `json:"maxInRubles"`
+}
+
+// GetPriceHistory returns the price timeline of a product, oldest first, with
+// min/max/current statistics
+func (h *ProductHandler) GetPriceHistory(c *gin.Context) {
+	productID := c.Param("id")
+
+	var product models.Product
+	if err := h.DB.Select("id, currency").Where("id = ?", productID).First(&product).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+			return
+		}
+		log.Printf("[ERROR] GetPriceHistory: Failed to fetch product %s: %v", productID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch product"})
+		return
+	}
+
+	var history []models.PriceHistory
+	if err := h.DB.Where("product_id = ?", productID).Order("recorded_at, id").Find(&history).Error; err != nil {
+		log.Printf("[ERROR] GetPriceHistory: Failed to fetch price history of %s: %v", productID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch price history"})
+		return
+	}
+
+	log.Printf("[DEBUG] GetPriceHistory: Fetched %d price history entries for product %s", len(history), productID)
+	c.JSON(http.StatusOK, gin.H{
+		"productId": product.ID,
+		"currency":  product.Currency,
+		"history":   history,
+		"stats":     summarisePriceHistory(history),
+	})
+}
+
+// summarisePriceHistory computes the stats of a timeline ordered oldest first.
+// Min and max only cover entries in the current currency, a retailer switching
+// currency would otherwise compare unrelated numbers.
+func summarisePriceHistory(history []models.PriceHistory) *priceHistoryStats {
+	if len(history) == 0 {
+		return nil
+	}
+
+	last := history[len(history)-1]
+	stats := &priceHistoryStats{
+		Entries: len(history),
+		Current: last.EffectivePrice(),
+		Min:     last.EffectivePrice(),
+		Max:     last.EffectivePrice(),
+	}
+
+	if len(history) > 1 {
+		previous := history[len(history)-2]
+		if previous.Currency == last.Currency {
+			previousPrice := previous.EffectivePrice()
+			stats.Previous = &previousPrice
+			if previousPrice > 0 {
+				change := roundPrice((stats.Current - previousPrice) * 100 / previousPrice)
+				stats.ChangePercent = &change
+			}
+		}
+	}
+
+	for i := range history {
+		entry := &history[i]
+		if entry.Currency == last.Currency {
+			if price := entry.EffectivePrice(); price < stats.Min {
+				stats.Min = price
+			} else if price > stats.Max {
+				stats.Max = price
+			}
+		}
+
+		rubles := entry.DiscountedPriceInRubles
+		if rubles == nil {
+			rubles = entry.PriceInRubles
+		}
+		if rubles == nil {
+			continue
+		}
+		if stats.MinInRubles == nil || *rubles < *stats.MinInRubles {
+			stats.MinInRubles = rubles
+		}
+		if stats.MaxInRubles == nil || *rubles > *stats.MaxInRubles {
+			stats.MaxInRubles = rubles
+		}
+	}
+
+	return stats
+}
+
+// priceHistoryEntries returns the history entries an upserted batch produces: one for
+// every new product and one for every stored product whose prices changed
+func priceHistoryEntries(batch []models.Product, replaced []*models.Product, recordedAt time.Time) []models.PriceHistory {
+	entries := make([]models.PriceHistory, 0, len(batch))
+	for i := range batch {
+		if replaced[i] != nil && !pricesChanged(replaced[i], &batch[i]) {
+			continue
+		}
+		entries = append(entries, models.NewPriceHistory(&batch[i], recordedAt))
+	}
+	return entries
+}
+
+// pricesChanged reports whether an incoming product changes any stored price
+func pricesChanged(stored, incoming *models.Product) bool {
+	return stored.Currency != incoming.Currency ||
+		roundPrice(stored.Price) != roundPrice(incoming.Price) ||
+		!pricesEqual(stored.DiscountedPrice, incoming.DiscountedPrice) ||
+		!pricesEqual(stored.PriceInRubles, incoming.PriceInRubles) ||
+		!pricesEqual(stored.DiscountedPriceInRubles, incoming.DiscountedPriceInRubles)
+}
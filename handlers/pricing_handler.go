@@ -0,0 +1,368 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"product-api/models"
+	"product-api/utils"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// repriceBatchSize is the number of products repriced per UPDATE statement
+const repriceBatchSize = 1000
+
+// Reprice run states
+const (
+	repriceRunning   = "running"
+	repriceCompleted = "completed"
+	repriceFailed    = "failed"
+)
+
+// repriceRun is the progress of a price recalculation running in the background
+type repriceRun struct {
+	ID         string     `json:"id"`
+	Store      string     `json:"store"`
+	State      string     `json:"state"`
+	Scanned    int        `json:"scanned"`
+	Updated    int        `json:"updated"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt"`
+}
+
+type PricingHandler struct {
+	DB       *gorm.DB
+	Pricing  *utils.PricingEngine
+	Currency *utils.CurrencyConverter
+
+	// run is the latest price recalculation of this instance
+	mu  sync.Mutex
+	run *repriceRun
+}
+
+// NewPricingHandler creates a new pricing handler sharing the engine and converter used by ingestion
+func NewPricingHandler(db *gorm.DB, pricing *utils.PricingEngine, currency *utils.CurrencyConverter) *PricingHandler {
+	return &PricingHandler{
+		DB:       db,
+		Pricing:  pricing,
+		Currency: currency,
+	}
+}
+
+// GetPricingRules lists all pricing rules, highest priority first
+func (h *PricingHandler) GetPricingRules(c *gin.Context) {
+	var rules []models.PricingRule
+	if err := h.DB.Order("priority DESC, id").Find(&rules).Error; err != nil {
+		log.Printf("[ERROR] GetPricingRules: Failed to fetch pricing rules: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch pricing rules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"rules": rules,
+		"count": len(rules),
+	})
+}
+
+// GetPricingRule returns a single pricing rule
+func (h *PricingHandler) GetPricingRule(c *gin.Context) {
+	rule, ok := h.findRule(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+// CreatePricingRule stores a new pricing rule
+func (h *PricingHandler) CreatePricingRule(c *gin.Context) {
+	rule := models.PricingRule{IsActive: true}
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format", "details": err.Error()})
+		return
+	}
+	rule.ID = 0
+
+	if !h.saveRule(c, &rule, "CreatePricingRule") {
+		return
+	}
+
+	log.Printf("[INFO] CreatePricingRule: Created pricing rule %d (%s)", rule.ID, rule.Name)
+	c.JSON(http.StatusCreated, rule)
+}
+
+// UpdatePricingRule replaces a pricing rule
+func (h *PricingHandler) UpdatePricingRule(c *gin.Context) {
+	stored, ok := h.findRule(c)
+	if !ok {
+		return
+	}
+
+	rule := models.PricingRule{IsActive: true}
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format", "details": err.Error()})
+		return
+	}
+	rule.ID, rule.CreatedAt = stored.ID, stored.CreatedAt
+
+	if !h.saveRule(c, &rule, "UpdatePricingRule") {
+		return
+	}
+
+	log.Printf("[INFO] UpdatePricingRule: Updated pricing rule %d (%s)", rule.ID, rule.Name)
+	c.JSON(http.StatusOK, rule)
+}
+
+// DeletePricingRule removes a pricing rule
+func (h *PricingHandler) DeletePricingRule(c *gin.Context) {
+	rule, ok := h.findRule(c)
+	if !ok {
+		return
+	}
+
+	if err := h.DB.Delete(&rule).Error; err != nil {
+		log.Printf("[ERROR] DeletePricingRule: Failed to delete pricing rule %d: %v", rule.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete pricing rule"})
+		return
+	}
+	h.reloadRules("DeletePricingRule")
+
+	log.Printf("[INFO] DeletePricingRule: Deleted pricing rule %d (%s)", rule.ID, rule.Name)
+	c.JSON(http.StatusOK, gin.H{"message": "Pricing rule deleted", "id": rule.ID})
+}
+
+// RecalculatePrices starts repricing stored products with the current rules and exchange
+// rates in the background and answers 202; GetRecalculation reports its progress.
+// ?store= limits the run to one store. Only products whose derived prices change are written.
+func (h *PricingHandler) RecalculatePrices(c *gin.Context) {
+	store := c.Query("store")
+	log.Printf("[DEBUG] RecalculatePrices: Repricing products (store: %q)", store)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.run != nil && h.run.State == repriceRunning {
+		c.JSON(http.StatusConflict, gin.H{"error": "A price recalculation is already running", "run": *h.run})
+		return
+	}
+
+	if err := h.Pricing.Reload(); err != nil {
+		log.Printf("[ERROR] RecalculatePrices: Failed to load pricing rules: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load pricing rules"})
+		return
+	}
+	if err := h.Currency.Reload(); err != nil {
+		log.Printf("[ERROR] RecalculatePrices: Failed to load exchange rates: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load exchange rates"})
+		return
+	}
+
+	run := &repriceRun{
+		ID:        uuid.NewString(),
+		Store:     store,
+		State:     repriceRunning,
+		StartedAt: time.Now(),
+	}
+	h.run = run
+	go h.runReprice(run)
+
+	log.Printf("[INFO] RecalculatePrices: Started price recalculation %s", run.ID)
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":   "Price recalculation started",
+		"run":       *run,
+		"statusUrl": "/api/pricing/recalculate",
+	})
+}
+
+// GetRecalculation returns the progress of the latest price recalculation
+func (h *PricingHandler) GetRecalculation(c *gin.Context) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.run == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No price recalculation has been started"})
+		return
+	}
+	c.JSON(http.StatusOK, *h.run)
+}
+
+// runReprice reprices the products of a run, recording its progress after every batch
+func (h *PricingHandler) runReprice(run *repriceRun) {
+	scanned, updated, err := h.repriceProducts(run.Store, func(scanned, updated int) {
+		h.mu.Lock()
+		run.Scanned, run.Updated = scanned, updated
+		h.mu.Unlock()
+	})
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	run.Scanned, run.Updated, run.FinishedAt = scanned, updated, &now
+	if err != nil {
+		log.Printf("[ERROR] runReprice: Price recalculation %s failed: %v", run.ID, err)
+		run.State, run.Error = repriceFailed, err.Error()
+		return
+	}
+	run.State = repriceCompleted
+	log.Printf("[SUCCESS] runReprice: Repriced %d of %d products", updated, scanned)
+}
+
+// repriceProducts walks the products in ID order and writes the changed derived prices
+// batch by batch, so a large catalog never has to fit in memory. progress is called
+// with the running totals after every batch.
+func (h *PricingHandler) repriceProducts(store string, progress func(scanned, updated int)) (int, int, error) {
+	scanned, updated := 0, 0
+	lastID := ""
+
+	for {
+		query := h.DB.Model(&models.Product{}).
+			Select("id, store, brand, category, currency, price, discounted_price, price_in_rubles, discounted_price_in_rubles, converted_prices").
+			Where("id > ?", lastID).
+			Order("id").
+			Limit(repriceBatchSize)
+		if store != "" {
+			query = query.Where("store = ?", store)
+		}
+
+		var products []models.Product
+		if err := query.Find(&products).Error; err != nil {
+			return scanned, updated, fmt.Errorf("failed to load products after %q: %w", lastID, err)
+		}
+		if len(products) == 0 {
+			return scanned, updated, nil
+		}
+		scanned += len(products)
+		lastID = products[len(products)-1].ID
+
+		now := time.Now()
+		changed := make([]models.Product, 0, len(products))
+		var history []models.PriceHistory
+		for _, product := range products {
+			oldPrice, oldDiscounted, oldConverted := product.PriceInRubles, product.DiscountedPriceInRubles, product.ConvertedPrices
+			h.Pricing.Apply(&product)
+			h.Currency.Apply(&product)
+
+			rublesChanged := !pricesEqual(oldPrice, product.PriceInRubles) || !pricesEqual(oldDiscounted, product.DiscountedPriceInRubles)
+			if rublesChanged {
+				history = append(history, models.NewPriceHistory(&product, now))
+			}
+			if rublesChanged || !jsonEqual(oldConverted, product.ConvertedPrices) {
+				changed = append(changed, product)
+			}
+		}
+
+		err := h.DB.Transaction(func(tx *gorm.DB) error {
+			if err := writePrices(tx, changed); err != nil {
+				return err
+			}
+			if len(history) == 0 {
+				return nil
+			}
+			return tx.Create(&history).Error
+		})
+		if err != nil {
+			return scanned, updated, err
+		}
+		updated += len(changed)
+		progress(scanned, updated)
+	}
+}
+
+// writePrices stores the derived prices of products with a single UPDATE ... FROM (VALUES ...)
+func writePrices(tx *gorm.DB, products []models.Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+
+	rows := make([]string, 0, len(products))
+	args := make([]interface{}, 0, len(products)*4)
+	for _, product := range products {
+		rows = append(rows, "(?, ?::numeric, ?::numeric, ?::jsonb)")
+		var convertedPrices interface{}
+		if len(product.ConvertedPrices) > 0 {
+			convertedPrices = string(product.ConvertedPrices)
+		}
+		args = append(args, product.ID, product.PriceInRubles, product.DiscountedPriceInRubles, convertedPrices)
+	}
+
+	sql := `UPDATE products p
+		SET price_in_rubles = v.price_in_rubles,
+			discounted_price_in_rubles = v.discounted_price_in_rubles,
+			converted_prices = v.converted_prices,
+			updated_at = NOW()
+		FROM (VALUES ` + strings.Join(rows, ", ") + `) AS v(id, price_in_rubles, discounted_price_in_rubles, converted_prices)
+		WHERE p.id = v.id`
+	if err := tx.Exec(sql, args...).Error; err != nil {
+		return fmt.Errorf("failed to write repriced products: %w", err)
+	}
+	return nil
+}
+
+// jsonEqual compares two JSON documents by value, ignoring formatting
+func jsonEqual(a, b datatypes.JSON) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == len(b)
+	}
+	var decodedA, decodedB interface{}
+	if json.Unmarshal(a, &decodedA) != nil || json.Unmarshal(b, &decodedB) != nil {
+		return false
+	}
+	return reflect.DeepEqual(decodedA, decodedB)
+}
+
+// findRule loads the rule named by the :id parameter, writing the error response itself
+func (h *PricingHandler) findRule(c *gin.Context) (models.PricingRule, bool) {
+	var rule models.PricingRule
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pricing rule ID"})
+		return rule, false
+	}
+
+	if err := h.DB.First(&rule, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Pricing rule not found"})
+			return rule, false
+		}
+		log.Printf("[ERROR] findRule: Failed to fetch pricing rule %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch pricing rule"})
+		return rule, false
+	}
+
+	return rule, true
+}
+
+// saveRule validates and stores a rule, then refreshes the engine.
+// It writes the error response itself and returns false on failure.
+func (h *PricingHandler) saveRule(c *gin.Context, rule *models.PricingRule, caller string) bool {
+	rule.Currency = strings.ToUpper(rule.Currency)
+	if reasons := rule.Validate(); len(reasons) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pricing rule", "reasons": reasons})
+		return false
+	}
+
+	if err := h.DB.Save(rule).Error; err != nil {
+		log.Printf("[ERROR] %s: Failed to save pricing rule: %v", caller, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save pricing rule"})
+		return false
+	}
+
+	h.reloadRules(caller)
+	return true
+}
+
+// reloadRules refreshes the engine after a rule change; the TTL catches up on failure
+func (h *PricingHandler) reloadRules(caller string) {
+	if err := h.Pricing.Reload(); err != nil {
+		log.Printf("[WARN] %s: Failed to reload pricing rules: %v", caller, err)
+	}
+}
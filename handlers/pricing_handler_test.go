@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"product-api/utils"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recalculate serves one request to a PricingHandler endpoint
+func recalculate(h *PricingHandler, method string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(method, "/api/pricing/recalculate?store=zara", nil)
+	if method == http.MethodPost {
+		h.RecalculatePrices(c)
+	} else {
+		h.GetRecalculation(c)
+	}
+	return recorder
+}
+
+// waitForRun polls the latest run until it leaves the running state
+func waitForRun(t *testing.T, h *PricingHandler) repriceRun {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		recorder := recalculate(h, http.MethodGet)
+		var run repriceRun
+		if err := json.Unmarshal(recorder.Body.Bytes(), &run); err != nil {
+			t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+		}
+		if run.State != repriceRunning {
+			return run
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("run still %s after 5s: %+v", run.State, run)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRecalculatePricesRunsInBackground(t *testing.T) {
+	release := make(chan struct{})
+	batches := 0
+	db, fake := newFakeDB(t, func(query string, args []interface{}) ([]string, [][]driver.Value) {
+		if !strings.Contains(query, `FROM "products"`) {
+			return nil, nil
+		}
+		batches++
+		if batches > 1 {
+			return nil, nil
+		}
+		// Hold the first batch so the request returns while the run is in progress
+		<-release
+		// No pricing rule matches, the stored ruble prices are cleared
+		return []string{"id", "store", "currency", "price", "price_in_rubles"}, [][]driver.Value{
+			{"p-1", "zara", "TRY", 100.0, 1299.0},
+			{"p-2", "zara", "TRY", 200.0, nil},
+		}
+	})
+	h := NewPricingHandler(db, utils.NewPricingEngine(db), utils.NewCurrencyConverter(db, nil))
+
+	if recorder := recalculate(h, http.MethodGet); recorder.Code != http.StatusNotFound {
+		t.Fatalf("status %d before the first run, want 404", recorder.Code)
+	}
+
+	recorder := recalculate(h, http.MethodPost)
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var started struct {
+		Run repriceRun `json:"run"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &started); err != nil {
+		t.Fatal(err)
+	}
+	if started.Run.ID == "" || started.Run.State != repriceRunning || started.Run.Store != "zara" {
+		t.Fatalf("started run %+v", started.Run)
+	}
+
+	if recorder := recalculate(h, http.MethodPost); recorder.Code != http.StatusConflict {
+		t.Fatalf("second run answered %d while the first is running, want 409", recorder.Code)
+	}
+	close(release)
+
+	run := waitForRun(t, h)
+	if run.ID != started.Run.ID || run.State != repriceCompleted || run.FinishedAt == nil {
+		t.Fatalf("finished run %+v", run)
+	}
+	if run.Scanned != 2 || run.Updated != 1 {
+		t.Fatalf("scanned %d and updated %d products, want 2 and 1", run.Scanned, run.Updated)
+	}
+	if len(fake.Find("UPDATE products p")) != 1 {
+		t.Fatal("changed prices were not written")
+	}
+
+	if recorder := recalculate(h, http.MethodPost); recorder.Code != http.StatusAccepted {
+		t.Fatalf("status %d after the run finished, want 202", recorder.Code)
+	}
+	waitForRun(t, h)
+}
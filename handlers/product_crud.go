@@ -0,0 +1,329 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"product-api/models"
+	"product-api/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Deactivation reasons of products changed through the single product endpoints
+const (
+	manualDeactivationReason = "deactivated manually"
+	deleteDeactivationReason = "deleted"
+)
+
+// productRequestError is a client error found while writing a single product
+type productRequestError struct {
+	Status  int
+	Message string
+	Reasons []string
+}
+
+func (e *productRequestError) Error() string {
+	return e.Message
+}
+
+// productETag is the entity tag of a product version, derived from UpdatedAt
+func productETag(product *models.Product) string {
+	return `"` + strconv.FormatInt(product.UpdatedAt.UnixMicro(), 36) + `"`
+}
+
+// etagMatches reports whether an If-Match / If-None-Match header lists etag. If-Match
+// uses the strong comparison of RFC 9110, where a weak tag never matches; weak allows
+// the weak comparison If-None-Match uses, ignoring the W/ prefix.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch enforces the optional If-Match header against the stored product
+func checkIfMatch(c *gin.Context, current *models.Product) error {
+	header := c.GetHeader("If-Match")
+	if header == "" || etagMatches(header, productETag(current), false) {
+		return nil
+	}
+	return &productRequestError{
+		Status:  http.StatusPreconditionFailed,
+		Message: "Product was modified since it was read, If-Match does not match its current ETag",
+	}
+}
+
+// GetProduct returns one product by ID, inactive products included.
+// The ETag header identifies the version for If-Match on later writes.
+func (h *ProductHandler) GetProduct(c *gin.Context) {
+	id := c.Param("id")
+	currency, ok := displayCurrency(c)
+	if !ok {
+		return
+	}
+
+	var product models.Product
+	if err := h.DB.Select(exportColumns).Where("id = ?", id).First(&product).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+			return
+		}
+		log.Printf("[ERROR] GetProduct: Failed to fetch product %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch product"})
+		return
+	}
+
+	etag := productETag(&product)
+	c.Header("ETag", etag)
+	if header := c.GetHeader("If-None-Match"); header != "" && etagMatches(header, etag, true) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	if currency != "" {
+		h.convertProduct(&product, currency)
+	}
+	c.JSON(http.StatusOK, product)
+}
+
+// ReplaceProduct replaces every editable field of a product (PUT).
+// The ID, creation time and derived prices are kept or recomputed by the server.
+func (h *ProductHandler) ReplaceProduct(c *gin.Context) {
+	h.writeProduct(c, "ReplaceProduct", func(current *models.Product, body []byte) (*models.Product, error) {
+		// A missing isActive means active, as for ingested products
+		product := models.Product{IsActive: true}
+		if err := json.Unmarshal(body, &product); err != nil {
+			return nil, &productRequestError{Status: http.StatusBadRequest, Message: "Invalid JSON: " + err.Error()}
+		}
+		return &product, nil
+	})
+}
+
+// PatchProduct applies a JSON merge patch (RFC 7396) to a product (PATCH).
+// Fields missing from the patch are kept, null clears a field; objects such as
+// stock are merged recursively while arrays such as sizes and colors are replaced.
+func (h *ProductHandler) PatchProduct(c *gin.Context) {
+	h.writeProduct(c, "PatchProduct", func(current *models.Product, body []byte) (*models.Product, error) {
+		var patch interface{}
+		if err := json.Unmarshal(body, &patch); err != nil {
+			return nil, &productRequestError{Status: http.StatusBadRequest, Message: "Invalid JSON: " + err.Error()}
+		}
+		if _, ok := patch.(map[string]interface{}); !ok {
+			return nil, &productRequestError{Status: http.StatusBadRequest, Message: "Merge patch must be a JSON object"}
+		}
+
+		encoded, err := json.Marshal(current)
+		if err != nil {
+			return nil, err
+		}
+		var document interface{}
+		if err := json.Unmarshal(encoded, &document); err != nil {
+			return nil, err
+		}
+
+		merged, err := json.Marshal(mergePatch(document, patch))
+		if err != nil {
+			return nil, err
+		}
+		var product models.Product
+		if err := json.Unmarshal(merged, &product); err != nil {
+			return nil, &productRequestError{Status: http.StatusBadRequest, Message: "Invalid patch: " + err.Error()}
+		}
+		return &product, nil
+	})
+}
+
+// writeProduct loads and locks the product, checks If-Match, builds the new version
+// from the request body and stores it. Prices are recomputed and, like ingestion,
+// price history and alerts are recorded when prices or stock changed.
+func (h *ProductHandler) writeProduct(c *gin.Context, funcName string, build func(current *models.Product, body []byte) (*models.Product, error)) {
+	id := c.Param("id")
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+
+	var saved models.Product
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		var current models.Product
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select(exportColumns).
+			Where("id = ?", id).First(&current).Error; err != nil {
+			return err
+		}
+		if err := checkIfMatch(c, &current); err != nil {
+			return err
+		}
+
+		product, err := build(&current, body)
+		if err != nil {
+			return err
+		}
+		if product.ID != "" && product.ID != current.ID {
+			return &productRequestError{Status: http.StatusBadRequest, Message: "_id cannot be changed"}
+		}
+		if reasons := product.Validate(); len(reasons) > 0 {
+			return &productRequestError{Status: http.StatusUnprocessableEntity, Message: "Invalid product", Reasons: reasons}
+		}
+
+		product.ID = current.ID
+		product.CreatedAt = current.CreatedAt
+		product.LastSeenAt = current.LastSeenAt
+		product.CanonicalURL = utils.CanonicalURL(product.ProductURL)
+		now := time.Now()
+		switch {
+		case product.IsActive:
+			product.DeactivatedAt = nil
+			product.DeactivationReason = ""
+		case current.IsActive:
+			product.DeactivatedAt = &now
+			product.DeactivationReason = manualDeactivationReason
+		default:
+			product.DeactivatedAt = current.DeactivatedAt
+			product.DeactivationReason = current.DeactivationReason
+		}
+		h.Pricing.Apply(product)
+		h.Currency.Apply(product)
+
+		if err := tx.Model(&models.Product{}).Where("id = ?", id).
+			Select("*").Omit("id", "created_at").Updates(product).Error; err != nil {
+			return err
+		}
+		refs := utils.ImageRefs{}
+		refs.Replace(current.Images, product.Images)
+		if err := refs.Apply(tx); err != nil {
+			return err
+		}
+
+		batch := []models.Product{*product}
+		replaced := []*models.Product{&current}
+		if history := priceHistoryEntries(batch, replaced, now); len(history) > 0 {
+			if err := tx.Create(&history).Error; err != nil {
+				return err
+			}
+		}
+		if err := queueAlerts(tx, batch, replaced, now); err != nil {
+			return err
+		}
+
+		return tx.Select(exportColumns).Where("id = ?", id).First(&saved).Error
+	})
+	if err != nil {
+		respondProductWriteError(c, funcName, id, err)
+		return
+	}
+
+	log.Printf("[SUCCESS] %s: Updated product %s", funcName, id)
+	c.Header("ETag", productETag(&saved))
+	c.JSON(http.StatusOK, saved)
+}
+
+// DeleteProduct deactivates a product; ?hard=true deletes it together with its
+// price history and alert subscriptions
+func (h *ProductHandler) DeleteProduct(c *gin.Context) {
+	id := c.Param("id")
+	hard, _ := strconv.ParseBool(c.Query("hard"))
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		var current models.Product
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id, is_active, updated_at, images").
+			Where("id = ?", id).First(&current).Error; err != nil {
+			return err
+		}
+		if err := checkIfMatch(c, &current); err != nil {
+			return err
+		}
+
+		if !hard {
+			return tx.Model(&models.Product{}).Where("id = ?", id).Updates(map[string]interface{}{
+				"is_active":           false,
+				"deactivated_at":      time.Now(),
+				"deactivation_reason": deleteDeactivationReason,
+			}).Error
+		}
+
+		if err := tx.Where("product_id = ?", id).Delete(&models.PriceHistory{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("product_id = ?", id).Delete(&models.AlertSubscription{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("product_id = ?", id).Delete(&models.ImageJob{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("id = ?", id).Delete(&models.Product{}).Error; err != nil {
+			return err
+		}
+		refs := utils.ImageRefs{}
+		refs.Replace(current.Images, nil)
+		return refs.Apply(tx)
+	})
+	if err != nil {
+		respondProductWriteError(c, "DeleteProduct", id, err)
+		return
+	}
+
+	if hard {
+		log.Printf("[INFO] DeleteProduct: Deleted product %s permanently", id)
+		c.JSON(http.StatusOK, gin.H{"message": "Product deleted", "id": id, "hard": true})
+		return
+	}
+	log.Printf("[INFO] DeleteProduct: Deactivated product %s", id)
+	c.JSON(http.StatusOK, gin.H{"message": "Product deactivated", "id": id, "hard": false})
+}
+
+// respondProductWriteError maps a failed single product write to its response
+func respondProductWriteError(c *gin.Context, funcName, id string, err error) {
+	var requestErr *productRequestError
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+	case errors.As(err, &requestErr):
+		response := gin.H{"error": requestErr.Message}
+		if len(requestErr.Reasons) > 0 {
+			response["reasons"] = requestErr.Reasons
+		}
+		c.JSON(requestErr.Status, response)
+	case errors.As(err, &pgErr) && pgErr.Code == "23505":
+		c.JSON(http.StatusConflict, gin.H{"error": "Another product already uses this productUrl (same canonical URL)"})
+	default:
+		log.Printf("[ERROR] %s: Failed to write product %s: %v", funcName, id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to write product %s", id)})
+	}
+}
+
+// mergePatch applies a JSON merge patch to a decoded JSON document (RFC 7396)
+func mergePatch(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = make(map[string]interface{})
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = mergePatch(targetObject[key], value)
+	}
+	return targetObject
+}
@@ -0,0 +1,26 @@
+package handlers
+
+import "testing"
+
+func TestETagMatches(t *testing.T) {
+	const etag = `"abc"`
+	tests := []struct {
+		header string
+		weak   bool
+		want   bool
+	}{
+		{`"abc"`, false, true},
+		{`"xyz", "abc"`, false, true},
+		{`*`, false, true},
+		{`W/"abc"`, false, false},
+		{`"xyz"`, false, false},
+		{`W/"abc"`, true, true},
+		{`"xyz", W/"abc"`, true, true},
+		{`W/"xyz"`, true, false},
+	}
+	for _, test := range tests {
+		if got := etagMatches(test.header, etag, test.weak); got != test.want {
+			t.Errorf("etagMatches(%q, weak=%v) = %v, want %v", test.header, test.weak, got, test.want)
+		}
+	}
+}
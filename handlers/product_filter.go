@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// priceFilterColumns maps the priceField parameter to the column minPrice/maxPrice apply to
+var priceFilterColumns = map[string]string{
+	"price":           "price",
+	"discountedPrice": "discounted_price",
+	"priceInRubles":   "price_in_rubles",
+}
+
+// productFilter holds the optional filters of the product list endpoints
+type productFilter struct {
+	Brands      []string
+	Categories  []string
+	PriceColumn string
+	MinPrice    *float64
+	MaxPrice    *float64
+	Sizes       []string
+	Colors      []string
+	InStock     *bool
+	HasDiscount *bool
+}
+
+// parseProductFilter reads the filter query parameters. brand, category, size and
+// color accept several values, repeated (brand=a&brand=b) or comma separated.
+func parseProductFilter(c *gin.Context) (productFilter, error) {
+	filter := productFilter{
+		Brands:     queryList(c, "brand"),
+		Categories: queryList(c, "category"),
+		Sizes:      queryList(c, "size"),
+		Colors:     queryList(c, "color"),
+	}
+
+	priceField := c.DefaultQuery("priceField", "price")
+	column, ok := priceFilterColumns[priceField]
+	if !ok {
+		return filter, fmt.Errorf("priceField must be one of price, discountedPrice, priceInRubles")
+	}
+	filter.PriceColumn = column
+
+	var err error
+	if filter.MinPrice, err = queryFloat(c, "minPrice"); err != nil {
+		return filter, err
+	}
+	if filter.MaxPrice, err = queryFloat(c, "maxPrice"); err != nil {
+		return filter, err
+	}
+	if filter.MinPrice != nil && filter.MaxPrice != nil && *filter.MinPrice > *filter.MaxPrice {
+		return filter, fmt.Errorf("minPrice must not exceed maxPrice")
+	}
+	if filter.InStock, err = queryBool(c, "inStock"); err != nil {
+		return filter, err
+	}
+	if filter.HasDiscount, err = queryBool(c, "hasDiscount"); err != nil {
+		return filter, err
+	}
+
+	return filter, nil
+}
+
+// apply adds the filter conditions to a products query
+func (f productFilter) apply(db *gorm.DB) *gorm.DB {
+	if len(f.Brands) > 0 {
+		db = db.Where("brand IN ?", f.Brands)
+	}
+	if len(f.Categories) > 0 {
+		db = db.Where("category IN ?", f.Categories)
+	}
+	if f.MinPrice != nil {
+		db = db.Where(f.PriceColumn+" >= ?", *f.MinPrice)
+	}
+	if f.MaxPrice != nil {
+		db = db.Where(f.PriceColumn+" <= ?", *f.MaxPrice)
+	}
+
+	// Containment (@>) on the JSONB arrays is served by the jsonb_path_ops GIN indexes
+	if len(f.Sizes) > 0 {
+		conditions := make([]string, 0, len(f.Sizes))
+		args := make([]interface{}, 0, len(f.Sizes))
+		for _, size := range f.Sizes {
+			conditions = append(conditions, "sizes @> ?::jsonb")
+			args = append(args, jsonArrayOf(map[string]interface{}{"sizeName": size, "onStock": true}))
+		}
+		db = db.Where("("+strings.Join(conditions, " OR ")+")", args...)
+	}
+	if len(f.Colors) > 0 {
+		var conditions []string
+		var args []interface{}
+		for _, color := range f.Colors {
+			conditions = append(conditions, "colors @> ?::jsonb")
+			args = append(args, jsonArrayOf(map[string]interface{}{"name": color}))
+			if strings.HasPrefix(color, "#") {
+				// Hex codes are stored as sent by the scrapers, in either case
+				for _, hex := range []string{strings.ToUpper(color), strings.ToLower(color)} {
+					conditions = append(conditions, "colors @> ?::jsonb")
+					args = append(args, jsonArrayOf(map[string]interface{}{"hex": hex}))
+				}
+			}
+		}
+		db = db.Where("("+strings.Join(conditions, " OR ")+")", args...)
+	}
+
+	// Same availability rule as alert evaluation: stock status first, then the stock object
+	inStock := "(stock_status IS DISTINCT FROM 'out_of_stock' AND (stock IS NULL OR stock->>'isInStock' IS DISTINCT FROM 'false'))"
+	if f.InStock != nil {
+		if *f.InStock {
+			db = db.Where(inStock)
+		} else {
+			db = db.Where("NOT " + inStock)
+		}
+	}
+
+	hasDiscount := "(discounted_price IS NOT NULL AND discounted_price < price)"
+	if f.HasDiscount != nil {
+		if *f.HasDiscount {
+			db = db.Where(hasDiscount)
+		} else {
+			db = db.Where("NOT " + hasDiscount)
+		}
+	}
+
+	return db
+}
+
+// queryList collects a multi-valued query parameter
+func queryList(c *gin.Context, key string) []string {
+	var values []string
+	for _, raw := range c.QueryArray(key) {
+		for _, value := range strings.Split(raw, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// queryFloat parses an optional non-negative number parameter
+func queryFloat(c *gin.Context, key string) (*float64, error) {
+	raw := c.Query(key)
+	if raw == "" {
+		return nil, nil
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil || value < 0 {
+		return nil, fmt.Errorf("%s must be a non-negative number", key)
+	}
+	return &value, nil
+}
+
+// queryBool parses an optional boolean parameter
+func queryBool(c *gin.Context, key string) (*bool, error) {
+	raw := c.Query(key)
+	if raw == "" {
+		return nil, nil
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be true or false", key)
+	}
+	return &value, nil
+}
+
+// jsonArrayOf encodes a one element JSON array for a containment match
+func jsonArrayOf(element map[string]interface{}) string {
+	encoded, _ := json.Marshal([]interface{}{element})
+	return string(encoded)
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"product-api/models"
+	"strings"
+	"testing"
+)
+
+func TestParseProductFilter(t *testing.T) {
+	tests := []struct {
+		name  string
+		query url.Values
+		want  string
+		err   string
+	}{
+		{"no filters", url.Values{}, "price [] [] <nil> <nil> [] [] <nil> <nil>", ""},
+		{"lists", url.Values{"brand": {"Zara, Bershka", "Mango"}, "category": {" , Dresses"}, "size": {"M,L"}, "color": {"Black,#FFF"}},
+			"price [Zara Bershka Mango] [Dresses] <nil> <nil> [M L] [Black #FFF] <nil> <nil>", ""},
+		{"price band", url.Values{"minPrice": {"10"}, "maxPrice": {"99.5"}}, "price [] [] 10 99.5 [] [] <nil> <nil>", ""},
+		{"equal bounds", url.Values{"minPrice": {"10"}, "maxPrice": {"10"}}, "price [] [] 10 10 [] [] <nil> <nil>", ""},
+		{"discounted price", url.Values{"priceField": {"discountedPrice"}}, "discounted_price [] [] <nil> <nil> [] [] <nil> <nil>", ""},
+		{"ruble price", url.Values{"priceField": {"priceInRubles"}}, "price_in_rubles [] [] <nil> <nil> [] [] <nil> <nil>", ""},
+		{"booleans", url.Values{"inStock": {"true"}, "hasDiscount": {"0"}}, "price [] [] <nil> <nil> [] [] true false", ""},
+
+		{"column name as priceField", url.Values{"priceField": {"price_in_rubles"}}, "", "priceField must be one of"},
+		{"expression as priceField", url.Values{"priceField": {"price OR 1=1"}}, "", "priceField must be one of"},
+		{"empty priceField", url.Values{"priceField": {""}}, "", "priceField must be one of"},
+		{"min above max", url.Values{"minPrice": {"100"}, "maxPrice": {"99.99"}}, "", "minPrice must not exceed maxPrice"},
+		{"negative min", url.Values{"minPrice": {"-1"}}, "", "minPrice must be a non-negative number"},
+		{"max not a number", url.Values{"maxPrice": {"cheap"}}, "", "maxPrice must be a non-negative number"},
+		{"inStock not a boolean", url.Values{"inStock": {"yes"}}, "", "inStock must be true or false"},
+		{"hasDiscount not a boolean", url.Values{"hasDiscount": {"maybe"}}, "", "hasDiscount must be true or false"},
+	}
+	deref := func(v interface{}) string {
+		switch v := v.(type) {
+		case *float64:
+			if v != nil {
+				return fmt.Sprint(*v)
+			}
+		case *bool:
+			if v != nil {
+				return fmt.Sprint(*v)
+			}
+		}
+		return "<nil>"
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := parseProductFilter(cursorContext(tt.query))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got %v, want error %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := fmt.Sprintf("%s %v %v %s %s %v %v %s %s", filter.PriceColumn, filter.Brands, filter.Categories,
+				deref(filter.MinPrice), deref(filter.MaxPrice), filter.Sizes, filter.Colors, deref(filter.InStock), deref(filter.HasDiscount))
+			if got != tt.want {
+				t.Fatalf("parsed as %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// filterQuery runs a products query filtered by query against a fake database and
+// returns the statement sent
+func filterQuery(t *testing.T, query url.Values) fakeStatement {
+	t.Helper()
+	filter, err := parseProductFilter(cursorContext(query))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, fake := newFakeDB(t, nil)
+	var products []models.Product
+	if err := filter.apply(db.Model(&models.Product{})).Find(&products).Error; err != nil {
+		t.Fatal(err)
+	}
+	statements := fake.Statements()
+	if len(statements) != 1 {
+		t.Fatalf("%d statements, want 1", len(statements))
+	}
+	return statements[0]
+}
+
+func TestProductFilterPriceColumn(t *testing.T) {
+	statement := filterQuery(t, url.Values{"priceField": {"priceInRubles"}, "minPrice": {"1000"}, "maxPrice": {"5000"}})
+	if !strings.Contains(statement.Query, "price_in_rubles >= $1 AND price_in_rubles <= $2") {
+		t.Fatalf("query %q does not filter the ruble price", statement.Query)
+	}
+	if fmt.Sprint(statement.Args) != "[1000 5000]" {
+		t.Fatalf("arguments %v", statement.Args)
+	}
+}
+
+func TestProductFilterSizesAndColors(t *testing.T) {
+	statement := filterQuery(t, url.Values{"size": {"M,L"}, "color": {"Black", "#aBc"}})
+
+	// Each value is a containment match, ORed within the parameter and ANDed across them
+	want := "((sizes @> $1::jsonb OR sizes @> $2::jsonb)) AND ((colors @> $3::jsonb OR colors @> $4::jsonb OR colors @> $5::jsonb OR colors @> $6::jsonb))"
+	if !strings.Contains(statement.Query, want) {
+		t.Fatalf("query %q lacks %q", statement.Query, want)
+	}
+	wantArgs := []string{
+		`[{"onStock":true,"sizeName":"M"}]`,
+		`[{"onStock":true,"sizeName":"L"}]`,
+		`[{"name":"Black"}]`,
+		`[{"name":"#aBc"}]`,
+		`[{"hex":"#ABC"}]`,
+		`[{"hex":"#abc"}]`,
+	}
+	if len(statement.Args) != len(wantArgs) {
+		t.Fatalf("arguments %v, want %v", statement.Args, wantArgs)
+	}
+	for i, arg := range wantArgs {
+		if statement.Args[i] != arg {
+			t.Fatalf("argument %d is %v, want %s", i+1, statement.Args[i], arg)
+		}
+	}
+}
+
+func TestProductFilterAvailability(t *testing.T) {
+	statement := filterQuery(t, url.Values{"inStock": {"false"}, "hasDiscount": {"true"}})
+	for _, fragment := range []string{
+		"NOT (stock_status IS DISTINCT FROM 'out_of_stock' AND (stock IS NULL OR stock->>'isInStock' IS DISTINCT FROM 'false'))",
+		"(discounted_price IS NOT NULL AND discounted_price < price)",
+	} {
+		if !strings.Contains(statement.Query, fragment) {
+			t.Fatalf("query %q lacks %q", statement.Query, fragment)
+		}
+	}
+	if strings.Contains(statement.Query, "NOT (discounted_price") {
+		t.Fatalf("query %q negates the discount filter", statement.Query)
+	}
+}
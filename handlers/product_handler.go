@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"product-api/config"
 	"product-api/models"
+	"product-api/utils"
 	"strconv"
 	"strings"
 	"time"
@@ -15,23 +17,42 @@ import (
 )
 
 type ProductHandler struct {
-	DB *gorm.DB
+	DB       *gorm.DB
+	Config   *config.Config
+	Pricing  *utils.PricingEngine
+	Currency *utils.CurrencyConverter
+	Images   *utils.ImageProcessor
+
+	// importWake nudges an idle import worker when a job is submitted
+	importWake chan struct{}
+	// imageWake nudges an idle image worker when downloads are queued
+	imageWake chan struct{}
+	// hostLimits bounds the image downloads per host
+	hostLimits *utils.HostLimiter
+
+	// facets caches facet counts
+	facets *facetCache
 }
 
 // NewProductHandler creates a new product handler
-func NewProductHandler(db *gorm.DB) *ProductHandler {
+func NewProductHandler(db *gorm.DB, cfg *config.Config, storage utils.Storage) *ProductHandler {
+	images := utils.NewImageProcessor(db, storage)
+	images.VariantWidths = cfg.ImageVariantWidths
+	images.Fetcher = utils.NewImageFetcher(cfg.ImageHostAllowlist, cfg.ImageMaxRedirects)
+
 	return &ProductHandler{
-		DB: db,
+		DB:         db,
+		Config:     cfg,
+		Pricing:    utils.NewPricingEngine(db),
+		Currency:   utils.NewCurrencyConverter(db, cfg.TargetCurrencies),
+		Images:     images,
+		importWake: make(chan struct{}, 1),
+		imageWake:  make(chan struct{}, 1),
+		hostLimits: utils.NewHostLimiter(cfg.ImageHostConcurrency, cfg.ImageHostRate),
+		facets:     newFacetCache(time.Duration(cfg.FacetCacheSeconds) * time.Second),
 	}
 }
 
-// checkIDExistsInDB checks if an ID already exists in the database
-func (h *ProductHandler) checkIDExistsInDB(id string) bool {
-	var count int64
-	h.DB.Model(&models.Product{}).Where("id = ?", id).Count(&count)
-	return count > 0
-}
-
 // BulkCreateProducts handles bulk insertion of products (optimized for millions of records)
 // Accepts both single product object and array of products
 func (h *ProductHandler) BulkCreateProducts(c *gin.Context) {
@@ -54,30 +75,15 @@ func (h *ProductHandler) BulkCreateProducts(c *gin.Context) {
 		log.Printf("[DEBUG] BulkCreateProducts: Raw body: %s", bodyStr)
 	}
 
-	var products []models.Product
-
-	// Try to parse as array first
-	if err := json.Unmarshal(rawBody, &products); err != nil {
-		log.Printf("[DEBUG] BulkCreateProducts: Array parsing failed, trying single object: %v", err)
-
-		// If array parsing fails, try parsing as single object
-		var singleProduct models.Product
-		if err := json.Unmarshal(rawBody, &singleProduct); err != nil {
-			log.Printf("[ERROR] BulkCreateProducts: Both array and single object parsing failed: %v", err)
-			log.Printf("[ERROR] BulkCreateProducts: Raw body that failed: %s", bodyStr)
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error":         "Invalid JSON format - must be either a product object or array of products",
-				"details":       err.Error(),
-				"received_body": bodyStr,
-			})
-			return
-		}
-
-		// Successfully parsed as single object, convert to array
-		products = []models.Product{singleProduct}
-		log.Printf("[DEBUG] BulkCreateProducts: Successfully parsed single product, converted to array")
-	} else {
-		log.Printf("[DEBUG] BulkCreateProducts: Successfully parsed as array with %d products", len(products))
+	products, err := parseProducts(rawBody)
+	if err != nil {
+		log.Printf("[ERROR] BulkCreateProducts: Raw body that failed: %s", bodyStr)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":         "Invalid JSON format - must be either a product object or array of products",
+			"details":       err.Error(),
+			"received_body": bodyStr,
+		})
+		return
 	}
 
 	if len(products) == 0 {
@@ -92,207 +98,140 @@ func (h *ProductHandler) BulkCreateProducts(c *gin.Context) {
 		log.Printf("[DEBUG] BulkCreateProducts: First product: %s", string(firstProduct))
 	}
 
-	// Implement upsert logic
-	log.Printf("[DEBUG] BulkCreateProducts: Starting upsert process for %d products", len(products))
-
-	// Generate unique IDs for products without ID and validate duplicates within the batch
-	seenIDs := make(map[string]bool)
-	seenNames := make(map[string]bool)
-	seenNameUrlCombos := make(map[string]bool) // Track name+productUrl combinations
-	var uniqueProducts []models.Product
-	duplicateCount := 0
-
-	for i := range products {
-		log.Printf("[DEBUG] BulkCreateProducts: Product %d - ID: %s, Name: %s, ProductURL: %s",
-			i, products[i].ID, products[i].Name, products[i].ProductURL)
-
-		// Check if ProductURL already exists in database
-		if products[i].ProductURL != "" {
-			var existingProduct models.Product
-			result := h.DB.Where("product_url = ?", products[i].ProductURL).First(&existingProduct)
-			if result.Error == nil {
-				log.Printf("[WARN] BulkCreateProducts: ProductURL already exists in database: %s, skipping product: %s", 
-					products[i].ProductURL, products[i].Name)
-				duplicateCount++
-				continue // Skip this product as ProductURL already exists
-			}
-		}
-
-		// Check for duplicate name+productUrl combination within the batch
-		nameUrlKey := fmt.Sprintf("%s|%s", products[i].Name, products[i].ProductURL)
-		if products[i].Name != "" && products[i].ProductURL != "" {
-			if seenNameUrlCombos[nameUrlKey] {
-				log.Printf("[WARN] BulkCreateProducts: Duplicate name+productUrl found in batch: %s + %s, skipping", 
-					products[i].Name, products[i].ProductURL)
-				duplicateCount++
-				continue // Skip this duplicate product
-			}
-			seenNameUrlCombos[nameUrlKey] = true
-		}
+	// Full store sync: the payload is the complete catalog of one store
+	syncStore, maxDeactivatePercent, ok := h.parseSyncOptions(c, products)
+	if !ok {
+		return
+	}
+	syncStart := time.Now()
 
-		// Generate unique ID if empty
-		if products[i].ID == "" {
-			if products[i].Name != "" {
-				// Clean Turkish characters and create base ID
-				baseID := strings.ToLower(products[i].Name)
-				// Replace Turkish characters
-				baseID = strings.ReplaceAll(baseID, "ç", "c")
-				baseID = strings.ReplaceAll(baseID, "ğ", "g")
-				baseID = strings.ReplaceAll(baseID, "ı", "i")
-				baseID = strings.ReplaceAll(baseID, "ö", "o")
-				baseID = strings.ReplaceAll(baseID, "ş", "s")
-				baseID = strings.ReplaceAll(baseID, "ü", "u")
-				// Replace spaces and special characters
-				baseID = strings.ReplaceAll(baseID, " ", "-")
-				baseID = strings.ReplaceAll(baseID, ".", "")
-				baseID = strings.ReplaceAll(baseID, ",", "")
-				baseID = strings.ReplaceAll(baseID, "(", "")
-				baseID = strings.ReplaceAll(baseID, ")", "")
-				baseID = strings.ReplaceAll(baseID, "/", "-")
-				baseID = strings.ReplaceAll(baseID, "\\", "-")
-				
-				counter := 1
-				newID := baseID
-				
-				// Check both in-memory and database for uniqueness
-				for seenIDs[newID] || h.checkIDExistsInDB(newID) {
-					newID = fmt.Sprintf("%s-%d", baseID, counter)
-					counter++
-				}
-				products[i].ID = newID
-				seenIDs[newID] = true
-			} else {
-				// Generate timestamp-based ID and ensure uniqueness
-				baseID := fmt.Sprintf("product-%d-%d", time.Now().Unix(), i)
-				counter := 1
-				newID := baseID
-				
-				for seenIDs[newID] || h.checkIDExistsInDB(newID) {
-					newID = fmt.Sprintf("%s-%d", baseID, counter)
-					counter++
-				}
-				products[i].ID = newID
-				seenIDs[products[i].ID] = true
-			}
-		} else {
-			// Check if provided ID already exists in batch or database
-			if seenIDs[products[i].ID] || h.checkIDExistsInDB(products[i].ID) {
-				log.Printf("[WARN] BulkCreateProducts: Duplicate ID found (batch or DB): %s, generating new ID", products[i].ID)
-				counter := 1
-				baseID := products[i].ID
-				newID := fmt.Sprintf("%s-%d", baseID, counter)
-				for seenIDs[newID] || h.checkIDExistsInDB(newID) {
-					counter++
-					newID = fmt.Sprintf("%s-%d", baseID, counter)
-				}
-				products[i].ID = newID
-			}
-			seenIDs[products[i].ID] = true
+	// Dry run: report what would be written without touching the database
+	if dryRun, _ := strconv.ParseBool(c.Query("dryRun")); dryRun {
+		log.Printf("[DEBUG] BulkCreateProducts: Dry run for %d products", len(products))
+		report, err := h.dryRunProducts(products)
+		if err == nil && syncStore != "" {
+			report["sync"], err = h.previewSync(syncStore, products, maxDeactivatePercent)
 		}
-
-		// Track names for duplicate detection
-		if products[i].Name != "" {
-			if seenNames[products[i].Name] {
-				log.Printf("[WARN] BulkCreateProducts: Duplicate name found in batch: %s", products[i].Name)
-			}
-			seenNames[products[i].Name] = true
+		if err != nil {
+			log.Printf("[ERROR] BulkCreateProducts: Dry run failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Failed to run dry run",
+				"details": err.Error(),
+			})
+			return
 		}
+		c.JSON(http.StatusOK, report)
+		return
+	}
 
-		// Calculate PriceInRubles based on Price
-		if products[i].Price > 0 {
-			price := products[i].Price
-			var multiplier float64
-
-			if price >= 1 && price <= 100 {
-				multiplier = 120
-			} else if price >= 101 && price <= 150 {
-				multiplier = 100
-			} else if price >= 151 && price <= 200 {
-				multiplier = 90
-			} else if price >= 201 && price <= 350 {
-				multiplier = 85
-			} else if price > 350 {
-				multiplier = 80
-			} else {
-				multiplier = 120
-			}
-
-			priceInRubles := price * multiplier
-			products[i].PriceInRubles = &priceInRubles
-
-			log.Printf("[DEBUG] BulkCreateProducts: Product %s - Price: %.2f, Multiplier: %.0f, PriceInRubles: %.2f",
-				products[i].ID, price, multiplier, priceInRubles)
-		}
+	// Implement upsert logic
+	log.Printf("[DEBUG] BulkCreateProducts: Starting upsert process for %d products", len(products))
 
-		// Add to unique products list
-		uniqueProducts = append(uniqueProducts, products[i])
+	stats, rejected, err := h.ingestProducts(products)
+	if err != nil {
+		log.Printf("[ERROR] BulkCreateProducts: Failed to upsert products: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":    "Failed to upsert products",
+			"details":  err.Error(),
+			"inserted": stats.Inserted,
+			"updated":  stats.Updated,
+			"rejected": rejected,
+		})
+		return
 	}
 
-	log.Printf("[INFO] BulkCreateProducts: Filtered %d duplicates from batch, processing %d unique products", 
-		duplicateCount, len(uniqueProducts))
+	count := stats.Inserted + stats.Updated
 
-	// Update products slice to only contain unique products
-	products = uniqueProducts
+	// Nothing could be stored, every item was invalid
+	if count == 0 && stats.Rejected > 0 {
+		log.Printf("[WARN] BulkCreateProducts: All %d products were rejected", stats.Rejected)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":          "All products were rejected",
+			"original_count": stats.Received,
+			"rejected_count": stats.Rejected,
+			"rejected":       rejected,
+		})
+		return
+	}
 
-	// Delete existing products where (name + productUrl) matches
-	for _, product := range products {
-		if product.Name != "" && product.ProductURL != "" {
-			deleteResult := h.DB.Where("name = ? AND product_url = ?", product.Name, product.ProductURL).
-				Delete(&models.Product{})
-			if deleteResult.Error != nil {
-				log.Printf("[ERROR] BulkCreateProducts: Failed to delete existing product (name=%s, url=%s): %v",
-					product.Name, product.ProductURL, deleteResult.Error)
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error":   "Failed to delete existing product",
-					"details": deleteResult.Error.Error(),
-				})
-				return
-			}
-			if deleteResult.RowsAffected > 0 {
-				log.Printf("[DEBUG] BulkCreateProducts: Deleted %d existing product(s) with (name=%s, url=%s)",
-					deleteResult.RowsAffected, product.Name, product.ProductURL)
+	log.Printf("[SUCCESS] BulkCreateProducts: Successfully upserted %d unique products (%d inserted, %d updated, %d rejected, filtered %d duplicates from original %d products)",
+		count, stats.Inserted, stats.Updated, stats.Rejected, stats.Duplicates, stats.Received)
+	response := gin.H{
+		"message":             "Products upserted successfully",
+		"count":               count,
+		"inserted":            stats.Inserted,
+		"updated":             stats.Updated,
+		"original_count":      stats.Received,
+		"duplicates_filtered": stats.Duplicates,
+		"rejected_count":      stats.Rejected,
+		"rejected":            rejected,
+	}
+
+	if syncStore != "" {
+		// Rejected items are still part of the snapshot and must not be deactivated
+		seenURLs := make([]string, 0, len(rejected))
+		for _, item := range rejected {
+			if url := utils.CanonicalURL(products[item.Index].ProductURL); url != "" {
+				seenURLs = append(seenURLs, url)
 			}
 		}
+
+		result, err := h.deactivateMissing(syncStore, syncStart, seenURLs, maxDeactivatePercent)
+		if err != nil {
+			log.Printf("[ERROR] BulkCreateProducts: Full sync of store %s failed: %v", syncStore, err)
+			response["error"] = "Products upserted, but deactivating missing products failed"
+			response["details"] = err.Error()
+			c.JSON(http.StatusInternalServerError, response)
+			return
+		}
+		response["sync"] = result
+		if result.Aborted {
+			response["error"] = fmt.Sprintf("Products upserted, but deactivation aborted: %.1f%% of active products are missing, above the %.1f%% threshold",
+				result.MissingPercent, result.MaxDeactivatePercent)
+			c.JSON(http.StatusConflict, response)
+			return
+		}
 	}
 
-	// Use batch size for optimal performance
-	batchSize := 1000
-	log.Printf("[DEBUG] BulkCreateProducts: Starting batch processing with batch size %d", batchSize)
+	c.JSON(http.StatusCreated, response)
+}
 
-	for i := 0; i < len(products); i += batchSize {
-		end := i + batchSize
-		if end > len(products) {
-			end = len(products)
-		}
+// parseProducts decodes a bulk payload that is either a product object or an array of products
+func parseProducts(rawBody []byte) ([]models.Product, error) {
+	var products []models.Product
 
-		batch := products[i:end]
-		log.Printf("[DEBUG] BulkCreateProducts: Processing batch %d-%d (%d products)", i, end-1, len(batch))
+	// Try to parse as array first
+	if err := json.Unmarshal(rawBody, &products); err != nil {
+		log.Printf("[DEBUG] parseProducts: Array parsing failed, trying single object: %v", err)
 
-		if err := h.DB.CreateInBatches(batch, batchSize).Error; err != nil {
-			log.Printf("[ERROR] BulkCreateProducts: Database error in batch %d-%d: %v", i, end-1, err)
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":       "Failed to insert products batch",
-				"details":     err.Error(),
-				"batch_start": i,
-				"batch_end":   end,
-			})
-			return
+		// If array parsing fails, try parsing as single object
+		var singleProduct models.Product
+		if err := json.Unmarshal(rawBody, &singleProduct); err != nil {
+			log.Printf("[ERROR] parseProducts: Both array and single object parsing failed: %v", err)
+			return nil, err
 		}
-		log.Printf("[DEBUG] BulkCreateProducts: Successfully inserted batch %d-%d", i, end-1)
+
+		// Successfully parsed as single object, convert to array
+		log.Printf("[DEBUG] parseProducts: Successfully parsed single product, converted to array")
+		return []models.Product{singleProduct}, nil
 	}
 
-	log.Printf("[SUCCESS] BulkCreateProducts: Successfully upserted %d unique products (filtered %d duplicates from original %d products)", 
-		len(products), duplicateCount, len(products)+duplicateCount)
-	c.JSON(http.StatusCreated, gin.H{
-		"message": "Products upserted successfully",
-		"count":   len(products),
-		"original_count": len(products) + duplicateCount,
-		"duplicates_filtered": duplicateCount,
-	})
+	log.Printf("[DEBUG] parseProducts: Successfully parsed as array with %d products", len(products))
+	return products, nil
 }
 
 // GetAllProducts retrieves all products with pagination
 func (h *ProductHandler) GetAllProducts(c *gin.Context) {
+	currency, ok := displayCurrency(c)
+	if !ok {
+		return
+	}
+	filter, err := parseProductFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Parse pagination parameters
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
@@ -306,41 +245,54 @@ func (h *ProductHandler) GetAllProducts(c *gin.Context) {
 	}
 	
 	offset := (page - 1) * limit
+
+	// ?sort= picks the order, ?cursor= switches to keyset pagination, page is ignored then
+	order, err := parseSort(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cursor, err := parseCursor(c, order)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	
-	var products []models.Product
 	var total int64
 	
 	// Select only necessary fields, exclude heavy images field for better performance
-	selectFields := "id, name, brand, price, currency, price_in_rubles, discounted_price, description, sizes, colors, product_url, store, category, processed_at, is_active, stock_status, stock, created_at, updated_at"
+	selectFields := "id, name, brand, price, currency, price_in_rubles, discounted_price, discounted_price_in_rubles, converted_prices, description, sizes, colors, product_url, canonical_url, external_id, store, category, processed_at, is_active, stock_status, stock, created_at, updated_at"
 	
 	// Get total count
-	if err := h.DB.Model(&models.Product{}).Where("is_active = ?", true).Count(&total).Error; err != nil {
-		log.Printf("[ERROR] GetAllProducts: Failed to count products: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count products"})
-		return
+	if cursor == nil {
+		if err := filter.apply(h.DB.Model(&models.Product{}).Where("is_active = ?", true)).Count(&total).Error; err != nil {
+			log.Printf("[ERROR] GetAllProducts: Failed to count products: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count products"})
+			return
+		}
 	}
 	
 	// Get products with pagination
-	if err := h.DB.Select(selectFields).Where("is_active = ?", true).
-		Order("created_at DESC").
-		Limit(limit).
-		Offset(offset).
-		Find(&products).Error; err != nil {
+	var rows []pageRow
+	if err := paginate(filter.apply(h.DB.Where("is_active = ?", true)), selectFields, order, cursor, limit, offset).
+		Find(&rows).Error; err != nil {
 		log.Printf("[ERROR] GetAllProducts: Failed to fetch products: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
 		return
 	}
 	
+	products, nextCursor, err := nextPage(rows, limit, order)
+	if err != nil {
+		log.Printf("[ERROR] GetAllProducts: Failed to build next page cursor: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
+		return
+	}
+	h.convertProducts(products, currency)
 	log.Printf("[DEBUG] GetAllProducts: Successfully fetched %d products (page %d, limit %d)", len(products), page, limit)
 	
 	c.JSON(http.StatusOK, gin.H{
-		"products": products,
-		"pagination": gin.H{
-			"page":       page,
-			"limit":      limit,
-			"total":      total,
-			"totalPages": (total + int64(limit) - 1) / int64(limit),
-		},
+		"products":   products,
+		"pagination": paginationResponse(page, limit, total, cursor, nextCursor),
 	})
 }
 
@@ -351,6 +303,15 @@ func (h *ProductHandler) GetProductsByStore(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Store parameter is required"})
 		return
 	}
+	currency, ok := displayCurrency(c)
+	if !ok {
+		return
+	}
+	filter, err := parseProductFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	
 	// Parse pagination parameters
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
@@ -365,74 +326,134 @@ func (h *ProductHandler) GetProductsByStore(c *gin.Context) {
 	}
 	
 	offset := (page - 1) * limit
+
+	// ?sort= picks the order, ?cursor= switches to keyset pagination, page is ignored then
+	order, err := parseSort(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cursor, err := parseCursor(c, order)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	
-	var products []models.Product
 	var total int64
 	
 	// Select only necessary fields, exclude heavy images field for better performance
-	selectFields := "id, name, brand, price, currency, price_in_rubles, discounted_price, description, sizes, colors, product_url, store, category, processed_at, is_active, stock_status, stock, created_at, updated_at"
+	selectFields := "id, name, brand, price, currency, price_in_rubles, discounted_price, discounted_price_in_rubles, converted_prices, description, sizes, colors, product_url, canonical_url, external_id, store, category, processed_at, is_active, stock_status, stock, created_at, updated_at"
 	
 	// Get total count for the store
-	if err := h.DB.Model(&models.Product{}).
-		Where("store = ? AND is_active = ?", store, true).
-		Count(&total).Error; err != nil {
-		log.Printf("[ERROR] GetProductsByStore: Failed to count products for store %s: %v", store, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count products"})
-		return
+	if cursor == nil {
+		if err := filter.apply(h.DB.Model(&models.Product{}).
+			Where("store = ? AND is_active = ?", store, true)).
+			Count(&total).Error; err != nil {
+			log.Printf("[ERROR] GetProductsByStore: Failed to count products for store %s: %v", store, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count products"})
+			return
+		}
 	}
 	
 	// Get products filtered by store with pagination
-	if err := h.DB.Select(selectFields).Where("store = ? AND is_active = ?", store, true).
-		Order("created_at DESC").
-		Limit(limit).
-		Offset(offset).
-		Find(&products).Error; err != nil {
+	var rows []pageRow
+	if err := paginate(filter.apply(h.DB.Where("store = ? AND is_active = ?", store, true)), selectFields, order, cursor, limit, offset).
+		Find(&rows).Error; err != nil {
 		log.Printf("[ERROR] GetProductsByStore: Failed to fetch products for store %s: %v", store, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
 		return
 	}
 	
+	products, nextCursor, err := nextPage(rows, limit, order)
+	if err != nil {
+		log.Printf("[ERROR] GetProductsByStore: Failed to build next page cursor: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
+		return
+	}
+	h.convertProducts(products, currency)
 	log.Printf("[DEBUG] GetProductsByStore: Successfully fetched %d products for store %s (page %d, limit %d)", len(products), store, page, limit)
 	
 	c.JSON(http.StatusOK, gin.H{
-		"products": products,
-		"store":    store,
-		"pagination": gin.H{
-			"page":       page,
-			"limit":      limit,
-			"total":      total,
-			"totalPages": (total + int64(limit) - 1) / int64(limit),
-		},
+		"products":   products,
+		"store":      store,
+		"pagination": paginationResponse(page, limit, total, cursor, nextCursor),
 	})
 }
 
-// GetProductsByStoreOrAll retrieves all products or filters by store without pagination
+// GetProductsByStoreOrAll streams all products or the products of one store without pagination.
+// Rows are read from the database and written to the client one at a time, so the full
+// catalog never has to fit in memory. ?format=ndjson (or Accept: application/x-ndjson)
+// writes one product per line instead of a JSON document.
 func (h *ProductHandler) GetProductsByStoreOrAll(c *gin.Context) {
 	store := c.Query("store")
-	
-	var products []models.Product
-	var result *gorm.DB
-	
+	currency, ok := displayCurrency(c)
+	if !ok {
+		return
+	}
+	filter, err := parseProductFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	order, err := parseSort(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	format := exportJSON
+	if c.Query("format") == exportNDJSON || strings.Contains(c.GetHeader("Accept"), "application/x-ndjson") {
+		format = exportNDJSON
+	}
+
+	query := h.DB.WithContext(c.Request.Context()).Model(&models.Product{}).Select(exportColumns)
 	if store != "" {
 		// Filter by store
-		result = h.DB.Where("store = ? AND is_active = ?", store, true).Order("created_at DESC").Find(&products)
+		query = query.Where("store = ? AND is_active = ?", store, true)
 	} else {
 		// Get all products
-		result = h.DB.Where("is_active = ?", true).Order("created_at DESC").Find(&products)
+		query = query.Where("is_active = ?", true)
 	}
-	
-	if result.Error != nil {
-		log.Printf("[ERROR] GetProductsByStoreOrAll: Failed to fetch products: %v", result.Error)
+
+	rows, err := filter.apply(query).Order(order.orderBy()).Rows()
+	if err != nil {
+		log.Printf("[ERROR] GetProductsByStoreOrAll: Failed to fetch products: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
 		return
 	}
-	
-	log.Printf("[DEBUG] GetProductsByStoreOrAll: Successfully fetched %d products", len(products))
-	
-	c.JSON(http.StatusOK, gin.H{
-		"products": products,
-		"count":    len(products),
+	defer rows.Close()
+
+	if format == exportNDJSON {
+		c.Header("Content-Type", "application/x-ndjson")
+	} else {
+		c.Header("Content-Type", "application/json; charset=utf-8")
+	}
+	c.Status(http.StatusOK)
+
+	unconverted := 0
+	count, err := writeProductStream(c.Writer, c.Writer.Flush, format, func(product *models.Product) (bool, error) {
+		if !rows.Next() {
+			return false, rows.Err()
+		}
+		if err := h.DB.ScanRows(rows, product); err != nil {
+			return false, err
+		}
+		if currency != "" && !h.convertProduct(product, currency) {
+			unconverted++
+		}
+		return true, nil
 	})
+	if err != nil {
+		log.Printf("[ERROR] GetProductsByStoreOrAll: Stream stopped after %d products: %v", count, err)
+		return
+	}
+	if unconverted > 0 {
+		log.Printf("[WARN] GetProductsByStoreOrAll: No exchange rate to %s for %d of %d products, kept their source currency",
+			currency, unconverted, count)
+	}
+
+	log.Printf("[DEBUG] GetProductsByStoreOrAll: Successfully streamed %d products", count)
 }
 
 // GetProductImages retrieves images for a specific product by ID
@@ -448,7 +469,7 @@ func (h *ProductHandler) GetProductImages(c *gin.Context) {
 	var product models.Product
 	
 	// Select only ID and images field
-	if err := h.DB.Select("id, images").Where("id = ? AND is_active = ?", productID, true).First(&product).Error; err != nil {
+	if err := h.DB.Select("id, images, original_images").Where("id = ? AND is_active = ?", productID, true).First(&product).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			log.Printf("[WARN] GetProductImages: Product not found with ID: %s", productID)
 			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
@@ -462,8 +483,9 @@ func (h *ProductHandler) GetProductImages(c *gin.Context) {
 	log.Printf("[DEBUG] GetProductImages: Successfully fetched images for product ID: %s", productID)
 	
 	c.JSON(http.StatusOK, gin.H{
-		"id":     product.ID,
-		"images": product.Images,
+		"id":             product.ID,
+		"images":         product.Images,
+		"originalImages": product.OriginalImages,
 	})
 }
 
@@ -518,6 +540,15 @@ func (h *ProductHandler) GetMultipleProductImages(c *gin.Context) {
 // GetProductsWithImages returns products with images included - optimized for when images are needed
 func (h *ProductHandler) GetProductsWithImages(c *gin.Context) {
 	store := c.Query("store")
+	currency, ok := displayCurrency(c)
+	if !ok {
+		return
+	}
+	filter, err := parseProductFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	limitStr := c.Query("limit")
 	offsetStr := c.Query("offset")
 	
@@ -539,25 +570,31 @@ func (h *ProductHandler) GetProductsWithImages(c *gin.Context) {
 			offset = parsedOffset
 		}
 	}
+
+	// ?sort= picks the order, ?cursor= switches to keyset pagination, offset is ignored then
+	order, err := parseSort(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cursor, err := parseCursor(c, order)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	
-	var products []models.Product
+	var rows []pageRow
 	var result *gorm.DB
 	
 	// Include ALL fields including images
 	if store != "" {
 		// Filter by store with pagination
-		result = h.DB.Where("store = ? AND is_active = ?", store, true).
-			Order("created_at DESC").
-			Limit(limit).
-			Offset(offset).
-			Find(&products)
+		result = paginate(filter.apply(h.DB.Where("store = ? AND is_active = ?", store, true)), "*", order, cursor, limit, offset).
+			Find(&rows)
 	} else {
 		// Get all products with pagination
-		result = h.DB.Where("is_active = ?", true).
-			Order("created_at DESC").
-			Limit(limit).
-			Offset(offset).
-			Find(&products)
+		result = paginate(filter.apply(h.DB.Where("is_active = ?", true)), "*", order, cursor, limit, offset).
+			Find(&rows)
 	}
 	
 	if result.Error != nil {
@@ -566,12 +603,24 @@ func (h *ProductHandler) GetProductsWithImages(c *gin.Context) {
 		return
 	}
 	
+	products, nextCursor, err := nextPage(rows, limit, order)
+	if err != nil {
+		log.Printf("[ERROR] GetProductsWithImages: Failed to build next page cursor: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
+		return
+	}
+	h.convertProducts(products, currency)
 	log.Printf("[DEBUG] GetProductsWithImages: Successfully fetched %d products with images (limit: %d, offset: %d)", len(products), limit, offset)
 	
-	c.JSON(http.StatusOK, gin.H{
-		"products": products,
-		"count":    len(products),
-		"limit":    limit,
-		"offset":   offset,
-	})
+	response := gin.H{
+		"products":   products,
+		"count":      len(products),
+		"limit":      limit,
+		"offset":     offset,
+		"nextCursor": nil,
+	}
+	if nextCursor != "" {
+		response["nextCursor"] = nextCursor
+	}
+	c.JSON(http.StatusOK, response)
 }
\ No newline at end of file
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"product-api/models"
+	"product-api/utils"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// maxLookupURLs bounds the URLs of one batch lookup
+const maxLookupURLs = 1000
+
+// urlLookupResult tells whether a URL is already stored, and as which product
+type urlLookupResult struct {
+	URL          string     `json:"url"`
+	CanonicalURL string     `json:"canonicalUrl"`
+	Exists       bool       `json:"exists"`
+	ID           string     `json:"id,omitempty"`
+	Store        string     `json:"store,omitempty"`
+	IsActive     *bool      `json:"isActive,omitempty"`
+	UpdatedAt    *time.Time `json:"updatedAt,omitempty"`
+	LastSeenAt   *time.Time `json:"lastSeenAt,omitempty"`
+}
+
+// GetProductByURL returns the product stored for ?url=, matched on its canonical
+// form so tracking parameters, a trailing slash or the scheme do not matter
+func (h *ProductHandler) GetProductByURL(c *gin.Context) {
+	canonical := utils.CanonicalURL(c.Query("url"))
+	if canonical == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url parameter must be an http(s) URL"})
+		return
+	}
+
+	var product models.Product
+	if err := h.DB.Select(exportColumns).Where("canonical_url = ?", canonical).First(&product).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found", "canonicalUrl": canonical})
+			return
+		}
+		log.Printf("[ERROR] GetProductByURL: Failed to look up %s: %v", canonical, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch product"})
+		return
+	}
+
+	c.Header("ETag", productETag(&product))
+	c.JSON(http.StatusOK, product)
+}
+
+// GetProductByExternalID returns the product a store stores under the retailer's own
+// product ID, ?store=&id=. Should several share it, the latest updated one wins.
+func (h *ProductHandler) GetProductByExternalID(c *gin.Context) {
+	store, externalID := c.Query("store"), strings.TrimSpace(c.Query("id"))
+	if store == "" || externalID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "store and id parameters are required"})
+		return
+	}
+
+	var product models.Product
+	if err := h.DB.Select(exportColumns).Where("store = ? AND external_id = ?", store, externalID).
+		Order("updated_at DESC").First(&product).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+			return
+		}
+		log.Printf("[ERROR] GetProductByExternalID: Failed to look up %s of store %s: %v", externalID, store, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch product"})
+		return
+	}
+
+	c.Header("ETag", productETag(&product))
+	c.JSON(http.StatusOK, product)
+}
+
+// LookupProductURLs tells, for up to maxLookupURLs URLs, which are already stored,
+// so scrapers can skip known pages before fetching them
+func (h *ProductHandler) LookupProductURLs(c *gin.Context) {
+	var request struct {
+		URLs []string `json:"urls" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+		return
+	}
+	if len(request.URLs) > maxLookupURLs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Too many URLs, at most 1000 per request"})
+		return
+	}
+
+	results := make([]urlLookupResult, len(request.URLs))
+	canonicals := make([]string, 0, len(request.URLs))
+	for i, url := range request.URLs {
+		results[i] = urlLookupResult{URL: url, CanonicalURL: utils.CanonicalURL(url)}
+		if results[i].CanonicalURL != "" {
+			canonicals = append(canonicals, results[i].CanonicalURL)
+		}
+	}
+
+	var stored []models.Product
+	if len(canonicals) > 0 {
+		if err := h.DB.Select("id, canonical_url, store, is_active, updated_at, last_seen_at").
+			Where("canonical_url IN ?", canonicals).Find(&stored).Error; err != nil {
+			log.Printf("[ERROR] LookupProductURLs: Failed to look up %d URLs: %v", len(canonicals), err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up URLs"})
+			return
+		}
+	}
+	byCanonical := make(map[string]*models.Product, len(stored))
+	for i := range stored {
+		byCanonical[stored[i].CanonicalURL] = &stored[i]
+	}
+
+	found := 0
+	for i := range results {
+		product, ok := byCanonical[results[i].CanonicalURL]
+		if !ok {
+			continue
+		}
+		found++
+		results[i].Exists = true
+		results[i].ID = product.ID
+		results[i].Store = product.Store
+		results[i].IsActive = &product.IsActive
+		results[i].UpdatedAt = &product.UpdatedAt
+		results[i].LastSeenAt = product.LastSeenAt
+	}
+
+	log.Printf("[DEBUG] LookupProductURLs: %d of %d URLs are known", found, len(results))
+	c.JSON(http.StatusOK, gin.H{
+		"count":   len(results),
+		"found":   found,
+		"results": results,
+	})
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"product-api/models"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// searchQuery matches the query against the tsvector in every indexed language.
+// websearch_to_tsquery accepts user input as typed: quotes, OR and -exclusions.
+const searchQuery = "(websearch_to_tsquery('turkish', @q) || websearch_to_tsquery('russian', @q) || websearch_to_tsquery('simple', @q))"
+
+// searchHighlight marks matches in highlighted fragments
+const searchHighlight = "StartSel=<mark>, StopSel=</mark>"
+
+// escapeHTMLSQL is the SQL escaping the HTML special characters of a text expression.
+// Highlights are HTML, so the text is escaped before ts_headline adds the <mark> tags;
+// the parser reads the entities as single tokens, which keeps the words matching.
+func escapeHTMLSQL(expression string) string {
+	return "replace(replace(replace(" + expression + ", '&', '&amp;'), '<', '&lt;'), '>', '&gt;')"
+}
+
+// searchResult is a product with its relevance and highlighted fragments
+type searchResult struct {
+	models.Product
+	Rank                 float64 `json:"rank"`
+	NameHighlight        string  `json:"nameHighlight"`
+	DescriptionHighlight string  `json:"descriptionHighlight,omitempty"`
+}
+
+// SearchProducts searches active products by name, brand, category and description.
+// Full-text matches are ranked by ts_rank_cd, trigram similarity on name and brand
+// catches typos. Accepts the list endpoint filters, ?store= and page/limit pagination.
+func (h *ProductHandler) SearchProducts(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q parameter is required"})
+		return
+	}
+	store := c.Query("store")
+	currency, ok := displayCurrency(c)
+	if !ok {
+		return
+	}
+	filter, err := parseProductFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+	offset := (page - 1) * limit
+
+	named := sql.Named("q", q)
+	matching := func(db *gorm.DB) *gorm.DB {
+		db = db.Where("is_active = ?", true).
+			Where("(search_vector @@ "+searchQuery+" OR @q <% name OR @q <% brand)", named)
+		if store != "" {
+			db = db.Where("store = ?", store)
+		}
+		return filter.apply(db)
+	}
+
+	var total int64
+	if err := h.DB.Model(&models.Product{}).Scopes(matching).Count(&total).Error; err != nil {
+		log.Printf("[ERROR] SearchProducts: Failed to count matches for %q: %v", q, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search products"})
+		return
+	}
+
+	// Highlight with the stemmer of the script the query is written in
+	headlineConfig := "turkish"
+	if hasCyrillic(q) {
+		headlineConfig = "russian"
+	}
+	headlineQuery := "websearch_to_tsquery('" + headlineConfig + "', @q)"
+
+	var results []searchResult
+	if err := h.DB.Model(&models.Product{}).Scopes(matching).
+		Select("id, name, brand, price, currency, price_in_rubles, discounted_price, discounted_price_in_rubles, converted_prices, "+
+			"description, sizes, colors, product_url, canonical_url, external_id, store, category, processed_at, is_active, stock_status, stock, created_at, updated_at, "+
+			"ts_rank_cd(search_vector, "+searchQuery+") + greatest(word_similarity(@q, name), similarity(@q, brand)) AS rank, "+
+			"ts_headline('"+headlineConfig+"', "+escapeHTMLSQL("name")+", "+headlineQuery+", 'HighlightAll=true, "+searchHighlight+"') AS name_highlight, "+
+			"ts_headline('"+headlineConfig+"', "+escapeHTMLSQL("coalesce(description, '')")+", "+headlineQuery+", 'MaxFragments=2, MaxWords=20, MinWords=5, "+searchHighlight+"') AS description_highlight",
+			named).
+		Order("rank DESC, created_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Scan(&results).Error; err != nil {
+		log.Printf("[ERROR] SearchProducts: Failed to search for %q: %v", q, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search products"})
+		return
+	}
+
+	if currency != "" {
+		products := make([]models.Product, len(results))
+		for i := range results {
+			products[i] = results[i].Product
+		}
+		h.convertProducts(products, currency)
+		for i := range results {
+			results[i].Product = products[i]
+		}
+	}
+
+	log.Printf("[DEBUG] SearchProducts: %d matches for %q (page %d, limit %d)", total, q, page, limit)
+
+	c.JSON(http.StatusOK, gin.H{
+		"query":    q,
+		"products": results,
+		"pagination": gin.H{
+			"page":       page,
+			"limit":      limit,
+			"total":      total,
+			"totalPages": (total + int64(limit) - 1) / int64(limit),
+		},
+	})
+}
+
+// hasCyrillic reports whether text contains Cyrillic letters
+func hasCyrillic(text string) bool {
+	for _, r := range text {
+		if unicode.Is(unicode.Cyrillic, r) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sortExpressions whitelists the sort fields and the SQL they sort by. Every
+// expression is non-null so keyset pagination can compare it with plain operators.
+var sortExpressions = map[string]string{
+	"createdAt":       "created_at",
+	"updatedAt":       "updated_at",
+	"price":           "price",
+	"effectivePrice":  "COALESCE(discounted_price, price)",
+	"priceInRubles":   "COALESCE(discounted_price_in_rubles, price_in_rubles, 0)",
+	"name":            "COALESCE(name, '')",
+	"brand":           "COALESCE(brand, '')",
+	"discountPercent": "(CASE WHEN discounted_price < price AND price > 0 THEN ROUND((price - discounted_price) * 100 / price, 2) ELSE 0 END)",
+}
+
+// maxSortKeys bounds the number of sort keys of one request
+const maxSortKeys = 4
+
+// sortKey is one field of a sort order
+type sortKey struct {
+	Field string
+	Desc  bool
+}
+
+// sortOrder is the parsed ?sort= parameter; the product ID is always the final tie-breaker
+type sortOrder []sortKey
+
+// defaultSortOrder is the newest first order the list endpoints always used
+var defaultSortOrder = sortOrder{{Field: "createdAt", Desc: true}}
+
+// parseSort reads ?sort=field,-field or ?sort=field:asc,field:desc
+func parseSort(c *gin.Context) (sortOrder, error) {
+	raw := c.Query("sort")
+	if raw == "" {
+		return defaultSortOrder, nil
+	}
+
+	var order sortOrder
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		key := sortKey{Field: part}
+		if strings.HasPrefix(part, "-") {
+			key = sortKey{Field: part[1:], Desc: true}
+		} else if field, direction, found := strings.Cut(part, ":"); found {
+			switch strings.ToLower(direction) {
+			case "asc":
+				key = sortKey{Field: field}
+			case "desc":
+				key = sortKey{Field: field, Desc: true}
+			default:
+				return nil, fmt.Errorf("sort direction %q must be asc or desc", direction)
+			}
+		}
+
+		if _, ok := sortExpressions[key.Field]; !ok {
+			return nil, fmt.Errorf("cannot sort by %q, allowed: createdAt, updatedAt, price, effectivePrice, priceInRubles, name, brand, discountPercent", key.Field)
+		}
+		if seen[key.Field] {
+			return nil, fmt.Errorf("sort field %q is listed twice", key.Field)
+		}
+		seen[key.Field] = true
+		order = append(order, key)
+	}
+
+	if len(order) == 0 {
+		return defaultSortOrder, nil
+	}
+	if len(order) > maxSortKeys {
+		return nil, fmt.Errorf("at most %d sort fields are allowed", maxSortKeys)
+	}
+	return order, nil
+}
+
+// expressions returns the SQL expression of every sort key
+func (o sortOrder) expressions() []string {
+	exprs := make([]string, len(o))
+	for i, key := range o {
+		exprs[i] = sortExpressions[key.Field]
+	}
+	return exprs
+}
+
+// idDesc is the direction of the ID tie-breaker, the direction of the first key
+func (o sortOrder) idDesc() bool {
+	return o[0].Desc
+}
+
+// orderBy returns the ORDER BY clause of the sort order
+func (o sortOrder) orderBy() string {
+	terms := make([]string, 0, len(o)+1)
+	for i, expr := range o.expressions() {
+		terms = append(terms, expr+direction(o[i].Desc))
+	}
+	return strings.Join(append(terms, "id"+direction(o.idDesc())), ", ")
+}
+
+// String returns the canonical form of the sort order, stored in cursors
+func (o sortOrder) String() string {
+	parts := make([]string, len(o))
+	for i, key := range o {
+		if key.Desc {
+			parts[i] = "-" + key.Field
+		} else {
+			parts[i] = key.Field
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// direction is the SQL keyword of a sort direction
+func direction(desc bool) string {
+	if desc {
+		return " DESC"
+	}
+	return " ASC"
+}
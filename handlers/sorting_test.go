@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseSort(t *testing.T) {
+	tests := []struct {
+		sort    string
+		want    string
+		orderBy string
+		err     string
+	}{
+		{"", "-createdAt", "created_at DESC, id DESC", ""},
+		{" , ", "-createdAt", "created_at DESC, id DESC", ""},
+		{"price", "price", "price ASC, id ASC", ""},
+		{"-price", "-price", "price DESC, id DESC", ""},
+		{"price:asc", "price", "price ASC, id ASC", ""},
+		{"price:DESC", "-price", "price DESC, id DESC", ""},
+		{" brand , -updatedAt ", "brand,-updatedAt", "COALESCE(brand, '') ASC, updated_at DESC, id ASC", ""},
+		// The ID tie-breaker follows the first key, whatever the others say
+		{"-effectivePrice,name", "-effectivePrice,name", "COALESCE(discounted_price, price) DESC, COALESCE(name, '') ASC, id DESC", ""},
+		{"name,-priceInRubles", "name,-priceInRubles", "COALESCE(name, '') ASC, COALESCE(discounted_price_in_rubles, price_in_rubles, 0) DESC, id ASC", ""},
+		{"price,-createdAt,name,brand", "price,-createdAt,name,brand", "", ""},
+		{"price,-createdAt,name,brand,updatedAt", "", "", "at most 4 sort fields"},
+		{"id", "", "", `cannot sort by "id"`},
+		{"price_in_rubles", "", "", `cannot sort by "price_in_rubles"`},
+		{"price; DROP TABLE products", "", "", "cannot sort by"},
+		{"Price", "", "", `cannot sort by "Price"`},
+		{"price:up", "", "", `sort direction "up" must be asc or desc`},
+		{"price,-price", "", "", `sort field "price" is listed twice`},
+		{"name:asc,name:desc", "", "", `sort field "name" is listed twice`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			order, err := parseSort(cursorContext(url.Values{"sort": {tt.sort}}))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got %v, %v, want error %q", order, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := order.String(); got != tt.want {
+				t.Fatalf("parsed as %q, want %q", got, tt.want)
+			}
+			if tt.orderBy != "" && order.orderBy() != tt.orderBy {
+				t.Fatalf("ORDER BY %q, want %q", order.orderBy(), tt.orderBy)
+			}
+		})
+	}
+}
+
+func TestSortExpressionsAreWhitelisted(t *testing.T) {
+	// Every field must be accepted by parseSort and listed in its error message
+	_, err := parseSort(cursorContext(url.Values{"sort": {"unknown"}}))
+	if err == nil {
+		t.Fatal("unknown field accepted")
+	}
+	for field := range sortExpressions {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("error %q does not list %s", err, field)
+		}
+		if _, err := parseSort(cursorContext(url.Values{"sort": {"-" + field}})); err != nil {
+			t.Errorf("sorting by %s: %v", field, err)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"product-api/models"
+	"product-api/utils"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// syncDeactivationReason is stored on products deactivated by a full store sync
+const syncDeactivationReason = "missing from full store sync"
+
+// syncResult reports the deactivation step of a full store sync
+type syncResult struct {
+	Store                string  `json:"store"`
+	Active               int64   `json:"active"`
+	Missing              int64   `json:"missing"`
+	MissingPercent       float64 `json:"missingPercent"`
+	MaxDeactivatePercent float64 `json:"maxDeactivatePercent"`
+	Deactivated          int64   `json:"deactivated"`
+	Aborted              bool    `json:"aborted"`
+}
+
+// parseSyncOptions reads ?sync=full&store=...&maxDeactivatePercent=... from the request.
+// A full sync payload may only hold products of the synced store; products without
+// a store are assigned to it. It writes the error response itself and returns ok=false
+// when the request is not a valid sync.
+func (h *ProductHandler) parseSyncOptions(c *gin.Context, products []models.Product) (store string, maxPercent float64, ok bool) {
+	mode := c.Query("sync")
+	if mode == "" {
+		return "", 0, true
+	}
+	if mode != "full" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sync must be 'full'"})
+		return "", 0, false
+	}
+
+	store = c.Query("store")
+	if store == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "store parameter is required for a full sync"})
+		return "", 0, false
+	}
+
+	maxPercent = h.Config.SyncMaxDeactivatePercent
+	if maxStr := c.Query("maxDeactivatePercent"); maxStr != "" {
+		parsed, err := strconv.ParseFloat(maxStr, 64)
+		if err != nil || parsed < 0 || parsed > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "maxDeactivatePercent must be a number between 0 and 100"})
+			return "", 0, false
+		}
+		maxPercent = parsed
+	}
+
+	for i := range products {
+		if products[i].Store == "" {
+			products[i].Store = store
+			continue
+		}
+		if products[i].Store != store {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("full sync of store %q cannot contain products of store %q", store, products[i].Store),
+				"index": i,
+			})
+			return "", 0, false
+		}
+	}
+
+	return store, maxPercent, true
+}
+
+// deactivateMissing finishes a full store sync started at syncStart: every active
+// product of the store that was not ingested since then is deactivated, unless that
+// would exceed maxPercent of the store's active products. seenURLs are the canonical
+// URLs of snapshot products that were rejected during ingestion; they still count as present.
+func (h *ProductHandler) deactivateMissing(store string, syncStart time.Time, seenURLs []string, maxPercent float64) (syncResult, error) {
+	result := syncResult{Store: store, MaxDeactivatePercent: maxPercent}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		for i := 0; i < len(seenURLs); i += ingestBatchSize {
+			end := i + ingestBatchSize
+			if end > len(seenURLs) {
+				end = len(seenURLs)
+			}
+			if err := tx.Model(&models.Product{}).
+				Where("store = ? AND canonical_url IN ?", store, seenURLs[i:end]).
+				UpdateColumn("last_seen_at", syncStart).Error; err != nil {
+				return fmt.Errorf("failed to mark rejected products as seen: %w", err)
+			}
+		}
+
+		if err := tx.Model(&models.Product{}).
+			Where("store = ? AND is_active = ?", store, true).
+			Count(&result.Active).Error; err != nil {
+			return fmt.Errorf("failed to count active products: %w", err)
+		}
+
+		missing := tx.Model(&models.Product{}).
+			Where("store = ? AND is_active = ? AND (last_seen_at IS NULL OR last_seen_at < ?)", store, true, syncStart)
+		if err := missing.Count(&result.Missing).Error; err != nil {
+			return fmt.Errorf("failed to count missing products: %w", err)
+		}
+
+		result.MissingPercent = percentOf(result.Missing, result.Active)
+		if result.MissingPercent > maxPercent {
+			result.Aborted = true
+			return nil
+		}
+		if result.Missing == 0 {
+			return nil
+		}
+
+		deactivated := tx.Model(&models.Product{}).
+			Where("store = ? AND is_active = ? AND (last_seen_at IS NULL OR last_seen_at < ?)", store, true, syncStart).
+			Updates(map[string]interface{}{
+				"is_active":           false,
+				"deactivated_at":      time.Now(),
+				"deactivation_reason": syncDeactivationReason,
+			})
+		if deactivated.Error != nil {
+			return fmt.Errorf("failed to deactivate missing products: %w", deactivated.Error)
+		}
+		result.Deactivated = deactivated.RowsAffected
+		return nil
+	})
+
+	if err == nil {
+		if result.Aborted {
+			log.Printf("[WARN] deactivateMissing: Aborted sync of store %s, %d of %d active products (%.1f%%) are missing, threshold %.1f%%",
+				store, result.Missing, result.Active, result.MissingPercent, maxPercent)
+		} else {
+			log.Printf("[INFO] deactivateMissing: Deactivated %d products of store %s missing from the snapshot", result.Deactivated, store)
+		}
+	}
+
+	return result, err
+}
+
+// previewSync counts, without writing, the active products of a store that a full
+// sync with the given snapshot would deactivate
+func (h *ProductHandler) previewSync(store string, products []models.Product, maxPercent float64) (syncResult, error) {
+	result := syncResult{Store: store, MaxDeactivatePercent: maxPercent}
+
+	urls := make([]string, 0, len(products))
+	for _, product := range products {
+		if url := utils.CanonicalURL(product.ProductURL); url != "" {
+			urls = append(urls, url)
+		}
+	}
+	// A single JSON parameter keeps large snapshots under the bind parameter limit
+	urlsJSON, err := json.Marshal(urls)
+	if err != nil {
+		return result, err
+	}
+
+	if err := h.DB.Model(&models.Product{}).
+		Where("store = ? AND is_active = ?", store, true).
+		Count(&result.Active).Error; err != nil {
+		return result, fmt.Errorf("failed to count active products: %w", err)
+	}
+	if err := h.DB.Model(&models.Product{}).
+		Where("store = ? AND is_active = ?", store, true).
+		Where("canonical_url NOT IN (SELECT jsonb_array_elements_text(?::jsonb))", string(urlsJSON)).
+		Count(&result.Missing).Error; err != nil {
+		return result, fmt.Errorf("failed to count missing products: %w", err)
+	}
+
+	result.MissingPercent = percentOf(result.Missing, result.Active)
+	result.Aborted = result.MissingPercent > maxPercent
+	if !result.Aborted {
+		result.Deactivated = result.Missing
+	}
+	return result, nil
+}
+
+// percentOf returns part as a percentage of total
+func percentOf(part, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) * 100 / float64(total)
+}
@@ -1,16 +1,21 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"product-api/config"
 	"product-api/database"
 	"product-api/routes"
+	"product-api/utils"
 )
 
 func main() {
 	// Load configuration
 	cfg := config.Load()
 
+	// Product URLs are canonicalized with the configured tracking parameters
+	utils.SetTrackingParams(cfg.TrackingParams)
+
 	// Initialize database
 	db, err := database.Initialize(cfg.DatabaseURL)
 	if err != nil {
@@ -22,12 +27,38 @@ func main() {
 		log.Fatal("Failed to run migrations:", err)
 	}
 
+	// Image storage
+	storage, err := newStorage(cfg)
+	if err != nil {
+		log.Fatal("Failed to set up storage:", err)
+	}
+
 	// Setup routes
-	r := routes.SetupRoutes(db)
+	r := routes.SetupRoutes(db, cfg, storage)
 
 	// Start server
 	log.Printf("Server starting on port %s", cfg.Port)
 	if err := r.Run(":" + cfg.Port); err != nil {
 		log.Fatal("Failed to start server:", err)
 	}
+}
+
+// newStorage creates the image storage selected by STORAGE_BACKEND
+func newStorage(cfg *config.Config) (utils.Storage, error) {
+	switch cfg.StorageBackend {
+	case "local":
+		return utils.NewLocalStorage(cfg.StorageLocalDir), nil
+	case "s3":
+		return utils.NewS3Storage(utils.S3Options{
+			Endpoint:        cfg.S3Endpoint,
+			Region:          cfg.S3Region,
+			Bucket:          cfg.S3Bucket,
+			AccessKeyID:     cfg.S3AccessKeyID,
+			SecretAccessKey: cfg.S3SecretAccessKey,
+			PathStyle:       cfg.S3PathStyle,
+			PublicURL:       cfg.S3PublicURL,
+		})
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q, expected local or s3", cfg.StorageBackend)
+	}
 }
\ No newline at end of file
@@ -0,0 +1,116 @@
+package models
+
+import (
+	"fmt"
+	"net/netip"
+	"net/url"
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// Alert types a subscription can watch for
+const (
+	AlertPriceDrop   = "price_drop"
+	AlertBackInStock = "back_in_stock"
+)
+
+// Alert delivery states
+const (
+	AlertDeliveryPending   = "pending"
+	AlertDeliveryDelivered = "delivered"
+	AlertDeliveryFailed    = "failed"
+	AlertDeliveryCancelled = "cancelled"
+)
+
+// AlertSubscription asks for a webhook when a product gets cheaper or comes back in stock.
+// The product is identified either by ProductID or by Store plus ProductURL.
+type AlertSubscription struct {
+	ID         uint   `json:"id" gorm:"primaryKey"`
+	ProductID  string `json:"productId,omitempty" gorm:"type:varchar(255);index"`
+	Store      string `json:"store,omitempty" gorm:"type:varchar(255)"`
+	ProductURL string `json:"productUrl,omitempty" gorm:"type:text;index"`
+	Type       string `json:"type" gorm:"type:varchar(30);not null"`
+	// MinDropPercent only fires price_drop alerts for drops of at least this many percent
+	MinDropPercent float64 `json:"minDropPercent" gorm:"type:decimal(5,2)"`
+	// TargetPrice only fires price_drop alerts once the price reaches it, in the product's currency
+	TargetPrice *float64  `json:"targetPrice,omitempty" gorm:"type:decimal(10,2)"`
+	WebhookURL  string    `json:"webhookUrl" gorm:"type:text;not null"`
+	Secret      string    `json:"-" gorm:"type:varchar(255);not null"`
+	IsActive    bool      `json:"isActive"`
+	CreatedAt   time.Time `json:"createdAt" gorm:"autoCreateTime"`
+	UpdatedAt   time.Time `json:"updatedAt" gorm:"autoUpdateTime"`
+}
+
+// Validate returns the reasons a subscription cannot be stored
+func (s *AlertSubscription) Validate() []string {
+	var reasons []string
+
+	if s.ProductID == "" && (s.Store == "" || s.ProductURL == "") {
+		reasons = append(reasons, "either productId or store and productUrl are required")
+	}
+	if s.Type != AlertPriceDrop && s.Type != AlertBackInStock {
+		reasons = append(reasons, fmt.Sprintf("type %q must be one of %s, %s", s.Type, AlertPriceDrop, AlertBackInStock))
+	}
+	if s.MinDropPercent < 0 || s.MinDropPercent >= 100 {
+		reasons = append(reasons, "minDropPercent must be between 0 and 100")
+	}
+	if s.TargetPrice != nil && *s.TargetPrice <= 0 {
+		reasons = append(reasons, "targetPrice must be greater than 0")
+	}
+	if parsed, err := url.Parse(s.WebhookURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		reasons = append(reasons, "webhookUrl must be an absolute http(s) URL")
+	} else if addr, err := netip.ParseAddr(parsed.Hostname()); err == nil && !IsPublicAddress(addr) {
+		// Names are checked when the webhook is sent, once resolved
+		reasons = append(reasons, fmt.Sprintf("webhookUrl must point to a public address, not %s", addr))
+	}
+
+	return reasons
+}
+
+// TableName specifies the table name for GORM
+func (AlertSubscription) TableName() string {
+	return "alert_subscriptions"
+}
+
+// AlertDelivery is one webhook call owed to a subscription. Deliveries are written
+// in the ingestion transaction and sent by the alert dispatcher.
+type AlertDelivery struct {
+	ID             uint           `json:"id" gorm:"primaryKey"`
+	SubscriptionID uint           `json:"subscriptionId" gorm:"not null;index"`
+	ProductID      string         `json:"productId" gorm:"type:varchar(255)"`
+	Event          string         `json:"event" gorm:"type:varchar(30)"`
+	Payload        datatypes.JSON `json:"payload" gorm:"type:jsonb"`
+	State          string         `json:"state" gorm:"type:varchar(20);not null"`
+	Attempts       int            `json:"attempts"`
+	NextAttemptAt  time.Time      `json:"nextAttemptAt"`
+	LastStatusCode int            `json:"lastStatusCode,omitempty"`
+	LastError      string         `json:"lastError,omitempty" gorm:"type:text"`
+	DeliveredAt    *time.Time     `json:"deliveredAt"`
+	CreatedAt      time.Time      `json:"createdAt" gorm:"autoCreateTime"`
+	UpdatedAt      time.Time      `json:"updatedAt" gorm:"autoUpdateTime"`
+}
+
+// TableName specifies the table name for GORM
+func (AlertDelivery) TableName() string {
+	return "alert_deliveries"
+}
+
+// AlertDeadLetter keeps a delivery that used up its attempts, for inspection and manual replay
+type AlertDeadLetter struct {
+	ID             uint           `json:"id" gorm:"primaryKey"`
+	DeliveryID     uint           `json:"deliveryId" gorm:"not null;uniqueIndex"`
+	SubscriptionID uint           `json:"subscriptionId" gorm:"not null;index"`
+	WebhookURL     string         `json:"webhookUrl" gorm:"type:text"`
+	Event          string         `json:"event" gorm:"type:varchar(30)"`
+	Payload        datatypes.JSON `json:"payload" gorm:"type:jsonb"`
+	Attempts       int            `json:"attempts"`
+	LastStatusCode int            `json:"lastStatusCode,omitempty"`
+	LastError      string         `json:"lastError" gorm:"type:text"`
+	CreatedAt      time.Time      `json:"createdAt" gorm:"autoCreateTime"`
+}
+
+// TableName specifies the table name for GORM
+func (AlertDeadLetter) TableName() string {
+	return "alert_dead_letters"
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAlertSubscriptionValidateWebhookURL(t *testing.T) {
+	tests := []struct {
+		url    string
+		reason string
+	}{
+		{"https://example.com/hooks/brendoo", ""},
+		{"http://hooks.example.com:8080/a?b=c", ""},
+		{"https://93.184.216.34/hook", ""},
+		{"ftp://example.com/hook", "absolute http(s) URL"},
+		{"/hooks/brendoo", "absolute http(s) URL"},
+		{"", "absolute http(s) URL"},
+		{"http://127.0.0.1:8080/hook", "public address"},
+		{"http://[::1]/hook", "public address"},
+		{"http://10.1.2.3/hook", "public address"},
+		{"http://192.168.0.10/hook", "public address"},
+		{"http://169.254.169.254/latest/meta-data/", "public address"},
+		{"http://100.100.100.200/", "public address"},
+		{"http://[fd00::1]/hook", "public address"},
+		{"http://[::ffff:127.0.0.1]/hook", "public address"},
+	}
+	for _, test := range tests {
+		subscription := AlertSubscription{ProductID: "p-1", Type: AlertPriceDrop, WebhookURL: test.url}
+		reasons := subscription.Validate()
+		switch {
+		case test.reason == "" && len(reasons) > 0:
+			t.Errorf("%q: unexpected reasons %v", test.url, reasons)
+		case test.reason != "" && (len(reasons) != 1 || !strings.Contains(reasons[0], test.reason)):
+			t.Errorf("%q: got %v, want a reason containing %q", test.url, reasons, test.reason)
+		}
+	}
+}
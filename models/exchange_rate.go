@@ -0,0 +1,53 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// ExchangeRate is the price of one unit of BaseCurrency in QuoteCurrency,
+// valid from EffectiveDate until a newer rate for the same pair takes over
+type ExchangeRate struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	BaseCurrency  string    `json:"baseCurrency" gorm:"type:varchar(10);not null;uniqueIndex:idx_exchange_rates_pair_date"`
+	QuoteCurrency string    `json:"quoteCurrency" gorm:"type:varchar(10);not null;uniqueIndex:idx_exchange_rates_pair_date"`
+	Rate          float64   `json:"rate" gorm:"type:decimal(18,8);not null"`
+	EffectiveDate time.Time `json:"effectiveDate" gorm:"type:date;not null;uniqueIndex:idx_exchange_rates_pair_date"`
+	Source        string    `json:"source,omitempty" gorm:"type:varchar(255)"`
+	CreatedAt     time.Time `json:"createdAt" gorm:"autoCreateTime"`
+	UpdatedAt     time.Time `json:"updatedAt" gorm:"autoUpdateTime"`
+}
+
+// ConvertedPrice holds the prices of a product expressed in another currency
+type ConvertedPrice struct {
+	Price           float64  `json:"price"`
+	DiscountedPrice *float64 `json:"discountedPrice,omitempty"`
+}
+
+// Validate returns the reasons an exchange rate cannot be stored
+func (r *ExchangeRate) Validate() []string {
+	var reasons []string
+
+	if !IsCurrencyCode(r.BaseCurrency) {
+		reasons = append(reasons, fmt.Sprintf("baseCurrency %q is not an ISO 4217 code", r.BaseCurrency))
+	}
+	if !IsCurrencyCode(r.QuoteCurrency) {
+		reasons = append(reasons, fmt.Sprintf("quoteCurrency %q is not an ISO 4217 code", r.QuoteCurrency))
+	}
+	if r.BaseCurrency == r.QuoteCurrency {
+		reasons = append(reasons, "baseCurrency and quoteCurrency must differ")
+	}
+	if r.Rate <= 0 {
+		reasons = append(reasons, "rate must be greater than 0")
+	}
+	if r.EffectiveDate.IsZero() {
+		reasons = append(reasons, "effectiveDate is required")
+	}
+
+	return reasons
+}
+
+// TableName specifies the table name for GORM
+func (ExchangeRate) TableName() string {
+	return "exchange_rates"
+}
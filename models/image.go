@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// Image is a stored image file, addressed by the SHA-256 of its content so the
+// same image used by several colors or stores is kept once. Products point to it
+// through its URL, /uploads/images/<path>.
+type Image struct {
+	Hash      string    `json:"hash" gorm:"primaryKey;type:char(64)"`
+	Path      string    `json:"path" gorm:"type:text;not null"`
+	MimeType  string    `json:"mimeType" gorm:"type:varchar(50)"`
+	Size      int64     `json:"size"`
+	Width     int       `json:"width"`
+	Height    int       `json:"height"`
+	RefCount  int       `json:"refCount" gorm:"not null;default:0"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Image job states
+const (
+	ImageJobPending = "pending"
+	ImageJobDone    = "done"
+	ImageJobFailed  = "failed"
+)
+
+// ImageJob is one product image queued for download by the image workers.
+// Once done, ImageURL is the stored copy that replaced SourceURL in the product's images.
+type ImageJob struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	ProductID     string    `json:"productId" gorm:"type:varchar(255);not null;uniqueIndex:idx_image_jobs_product_source,priority:1"`
+	Store         string    `json:"store" gorm:"type:varchar(255);index"`
+	SourceURL     string    `json:"sourceUrl" gorm:"type:text;not null;uniqueIndex:idx_image_jobs_product_source,priority:2;index"`
+	Host          string    `json:"host" gorm:"type:varchar(255)"`
+	Status        string    `json:"status" gorm:"type:varchar(20);not null"`
+	Attempts      int       `json:"attempts"`
+	NextAttemptAt time.Time `json:"nextAttemptAt"`
+	LastError     string    `json:"lastError,omitempty" gorm:"type:text"`
+	ImageURL      string    `json:"imageUrl,omitempty" gorm:"type:text"`
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// Import job states
+const (
+	ImportJobPending   = "pending"
+	ImportJobRunning   = "running"
+	ImportJobCompleted = "completed"
+	ImportJobFailed    = "failed"
+)
+
+// ImportJob is an asynchronous bulk insert submitted through POST /api/stock/jobs
+type ImportJob struct {
+	ID         string         `json:"id" gorm:"primaryKey;type:varchar(36)"`
+	State      string         `json:"state" gorm:"type:varchar(20);index"`
+	Total      int            `json:"total"`
+	Processed  int            `json:"processed"`
+	Inserted   int            `json:"inserted"`
+	Updated    int            `json:"updated"`
+	Skipped    int            `json:"skipped"`
+	Errors     datatypes.JSON `json:"errors" gorm:"type:jsonb"`
+	Error      string         `json:"error,omitempty" gorm:"type:text"`
+	Attempts   int            `json:"attempts"`
+	Payload    datatypes.JSON `json:"-" gorm:"type:jsonb"`
+	StartedAt  *time.Time     `json:"startedAt"`
+	FinishedAt *time.Time     `json:"finishedAt"`
+	CreatedAt  time.Time      `json:"createdAt" gorm:"autoCreateTime"`
+	UpdatedAt  time.Time      `json:"updatedAt" gorm:"autoUpdateTime"`
+
+	// NextAttemptAt delays a job requeued after a failed batch; nil is due at once
+	NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty"`
+}
+
+// TableName specifies the table name for GORM
+func (ImportJob) TableName() string {
+	return "import_jobs"
+}
@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// PriceHistory is one point of a product's price timeline, recorded when the
+// product is first stored and whenever one of its prices changes afterwards
+type PriceHistory struct {
+	ID                      uint      `json:"id" gorm:"primaryKey"`
+	ProductID               string    `json:"productId" gorm:"type:varchar(255);not null;index:idx_price_history_product_recorded,priority:1"`
+	Store                   string    `json:"store" gorm:"type:varchar(255)"`
+	Currency                string    `json:"currency" gorm:"type:varchar(10)"`
+	Price                   float64   `json:"price" gorm:"type:decimal(10,2)"`
+	DiscountedPrice         *float64  `json:"discountedPrice" gorm:"type:decimal(10,2)"`
+	PriceInRubles           *float64  `json:"priceInRubles" gorm:"type:decimal(10,2)"`
+	DiscountedPriceInRubles *float64  `json:"discountedPriceInRubles" gorm:"type:decimal(10,2)"`
+	RecordedAt              time.Time `json:"recordedAt" gorm:"not null;index:idx_price_history_product_recorded,priority:2"`
+}
+
+// NewPriceHistory captures the current prices of a product
+func NewPriceHistory(product *Product, recordedAt time.Time) PriceHistory {
+	return PriceHistory{
+		ProductID:               product.ID,
+		Store:                   product.Store,
+		Currency:                product.Currency,
+		Price:                   product.Price,
+		DiscountedPrice:         product.DiscountedPrice,
+		PriceInRubles:           product.PriceInRubles,
+		DiscountedPriceInRubles: product.DiscountedPriceInRubles,
+		RecordedAt:              recordedAt,
+	}
+}
+
+// EffectivePrice is the price a customer pays, the discounted price when there is one
+func (h *PriceHistory) EffectivePrice() float64 {
+	if h.DiscountedPrice != nil && *h.DiscountedPrice > 0 {
+		return *h.DiscountedPrice
+	}
+	return h.Price
+}
+
+// TableName specifies the table name for GORM
+func (PriceHistory) TableName() string {
+	return "price_history"
+}
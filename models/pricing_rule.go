@@ -0,0 +1,77 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Rounding modes of a pricing rule
+const (
+	RoundingNone     = "none"
+	RoundingInteger  = "integer"
+	RoundingNine     = "9"
+	RoundingNineNine = "99"
+	RoundingNines    = "999"
+)
+
+// PricingRule turns a product's source price into its ruble price.
+// Empty Store, Brand, Category and Currency match every product; the price band
+// MinPrice <= price < MaxPrice is open on a side left nil. When several rules
+// match, the highest Priority wins, then the most specific rule.
+type PricingRule struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	Name       string    `json:"name" gorm:"type:varchar(255)"`
+	Store      string    `json:"store" gorm:"type:varchar(255);index"`
+	Brand      string    `json:"brand" gorm:"type:varchar(255)"`
+	Category   string    `json:"category" gorm:"type:varchar(255)"`
+	Currency   string    `json:"currency" gorm:"type:varchar(10)"`
+	MinPrice   *float64  `json:"minPrice" gorm:"type:decimal(10,2)"`
+	MaxPrice   *float64  `json:"maxPrice" gorm:"type:decimal(10,2)"`
+	Multiplier float64   `json:"multiplier" gorm:"type:decimal(12,4)"`
+	Markup     float64   `json:"markup" gorm:"type:decimal(10,2)"`
+	Rounding   string    `json:"rounding" gorm:"type:varchar(10)"`
+	Priority   int       `json:"priority"`
+	IsActive   bool      `json:"isActive"`
+	CreatedAt  time.Time `json:"createdAt" gorm:"autoCreateTime"`
+	UpdatedAt  time.Time `json:"updatedAt" gorm:"autoUpdateTime"`
+}
+
+var roundingModes = map[string]bool{
+	"":               true,
+	RoundingNone:     true,
+	RoundingInteger:  true,
+	RoundingNine:     true,
+	RoundingNineNine: true,
+	RoundingNines:    true,
+}
+
+// Validate returns the reasons a pricing rule cannot be stored
+func (r *PricingRule) Validate() []string {
+	var reasons []string
+
+	if r.Multiplier <= 0 {
+		reasons = append(reasons, "multiplier must be greater than 0")
+	}
+	if r.Markup < 0 {
+		reasons = append(reasons, "markup must not be negative")
+	}
+	if r.Currency != "" && !IsCurrencyCode(r.Currency) {
+		reasons = append(reasons, fmt.Sprintf("currency %q is not an ISO 4217 code", r.Currency))
+	}
+	if r.MinPrice != nil && *r.MinPrice < 0 {
+		reasons = append(reasons, "minPrice must not be negative")
+	}
+	if r.MinPrice != nil && r.MaxPrice != nil && *r.MaxPrice <= *r.MinPrice {
+		reasons = append(reasons, "maxPrice must be greater than minPrice")
+	}
+	if !roundingModes[r.Rounding] {
+		reasons = append(reasons, fmt.Sprintf("rounding %q must be one of none, integer, 9, 99, 999", r.Rounding))
+	}
+
+	return reasons
+}
+
+// TableName specifies the table name for GORM
+func (PricingRule) TableName() string {
+	return "pricing_rules"
+}
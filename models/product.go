@@ -27,6 +27,34 @@ type Product struct {
 	Stock           datatypes.JSON `json:"stock" gorm:"type:jsonb"`
 	CreatedAt       time.Time      `json:"createdAt" gorm:"autoCreateTime"`
 	UpdatedAt       time.Time      `json:"updatedAt" gorm:"autoUpdateTime"`
+
+	// LastSeenAt is stamped every time the product is ingested; full store syncs
+	// deactivate products that were not seen during the sync
+	LastSeenAt         *time.Time `json:"lastSeenAt"`
+	DeactivatedAt      *time.Time `json:"deactivatedAt"`
+	DeactivationReason string     `json:"deactivationReason,omitempty" gorm:"type:varchar(255)"`
+
+	// DiscountedPriceInRubles is DiscountedPrice priced with the same rule as PriceInRubles
+	DiscountedPriceInRubles *float64 `json:"discountedPriceInRubles" gorm:"type:decimal(10,2)"`
+
+	// ConvertedPrices maps each configured target currency to the product's prices
+	// converted with the exchange rates in effect at ingestion
+	ConvertedPrices datatypes.JSON `json:"convertedPrices,omitempty" gorm:"type:jsonb"`
+
+	// CanonicalURL is ProductURL in canonical form (see utils.CanonicalURL); products
+	// are deduplicated on it, so tracking parameters or a trailing slash do not create copies
+	CanonicalURL string `json:"canonicalUrl" gorm:"type:text"`
+
+	// ExternalID is the retailer's own identifier of the product (SKU, article number),
+	// unique within its store when the scraper provides it
+	ExternalID string `json:"externalId,omitempty" gorm:"type:varchar(255)"`
+
+	// OriginalImages holds the images as received when Images were replaced by local copies
+	OriginalImages datatypes.JSON `json:"originalImages,omitempty" gorm:"type:jsonb"`
+
+	// Converted is only set on responses to ?currency=: false when no exchange rate
+	// to that currency is known and the prices are still in the source currency
+	Converted *bool `json:"converted,omitempty" gorm:"-"`
 }
 
 type Size struct {
@@ -0,0 +1,227 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strings"
+
+	"gorm.io/datatypes"
+)
+
+// Stock statuses accepted from scrapers
+const (
+	StockStatusInStock    = "in_stock"
+	StockStatusLowStock   = "low_stock"
+	StockStatusOutOfStock = "out_of_stock"
+)
+
+var allowedStockStatuses = map[string]bool{
+	StockStatusInStock:    true,
+	StockStatusLowStock:   true,
+	StockStatusOutOfStock: true,
+}
+
+// hexColorPattern matches #RGB and #RRGGBB colors
+var hexColorPattern = regexp.MustCompile(`^#([0-9A-Fa-f]{3}|[0-9A-Fa-f]{6})$`)
+
+// currencyCodes holds the active ISO 4217 currency codes
+var currencyCodes = map[string]bool{}
+
+func init() {
+	codes := `AED AFN ALL AMD ANG AOA ARS AUD AWG AZN BAM BBD BDT BGN BHD BIF BMD BND BOB BRL
+		BSD BTN BWP BYN BZD CAD CDF CHF CLP CNY COP CRC CUC CUP CVE CZK DJF DKK DOP DZD
+		EGP ERN ETB EUR FJD FKP GBP GEL GHS GIP GMD GNF GTQ GYD HKD HNL HTG HUF IDR ILS
+		INR IQD IRR ISK JMD JOD JPY KES KGS KHR KMF KPW KRW KWD KYD KZT LAK LBP LKR LRD
+		LSL LYD MAD MDL MGA MKD MMK MNT MOP MRU MUR MVR MWK MXN MYR MZN NAD NGN NIO NOK
+		NPR NZD OMR PAB PEN PGK PHP PKR PLN PYG QAR RON RSD RUB RWF SAR SBD SCR SDG SEK
+		SGD SHP SLE SLL SOS SRD SSP STN SVC SYP SZL THB TJS TMT TND TOP TRY TTD TWD TZS
+		UAH UGX USD UYU UZS VES VND VUV WST XAF XCD XCG XOF XPF YER ZAR ZMW ZWL`
+	for _, code := range strings.Fields(codes) {
+		currencyCodes[code] = true
+	}
+}
+
+// ItemError describes why a single item of a bulk payload was not stored
+type ItemError struct {
+	Index   int      `json:"index"`
+	ID      string   `json:"id,omitempty"`
+	Reasons []string `json:"reasons"`
+}
+
+// blockedPrefixes are the ranges never connected to on behalf of clients, on top
+// of loopback, private, link-local, multicast and unspecified addresses
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // "this" network
+	netip.MustParsePrefix("100.64.0.0/10"),   // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // documentation
+	netip.MustParsePrefix("198.18.0.0/15"),   // benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // documentation
+	netip.MustParsePrefix("203.0.113.0/24"),  // documentation
+	netip.MustParsePrefix("240.0.0.0/4"),     // reserved, broadcast
+	netip.MustParsePrefix("64:ff9b::/96"),    // NAT64, may reach IPv4 internals
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // local-use NAT64
+	netip.MustParsePrefix("2001:db8::/32"),   // documentation
+}
+
+// IsPublicAddress reports whether an IP address may be connected to on behalf of
+// clients, for image downloads and webhooks. IPv4-mapped IPv6 addresses are
+// judged as the IPv4 address they map.
+func IsPublicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() ||
+		addr.IsMulticast() {
+		return false
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsCurrencyCode reports whether code is an active ISO 4217 currency code
+func IsCurrencyCode(code string) bool {
+	return currencyCodes[code]
+}
+
+// Validate checks a product received from a scraper and returns the reasons it
+// cannot be stored. An empty result means the product is valid.
+func (p *Product) Validate() []string {
+	var reasons []string
+
+	// Required fields
+	if strings.TrimSpace(p.Name) == "" {
+		reasons = append(reasons, "name is required")
+	}
+	if strings.TrimSpace(p.Store) == "" {
+		reasons = append(reasons, "store is required")
+	}
+
+	// Currency and prices
+	if p.Currency == "" {
+		reasons = append(reasons, "currency is required")
+	} else if !IsCurrencyCode(p.Currency) {
+		reasons = append(reasons, fmt.Sprintf("currency %q is not an ISO 4217 code", p.Currency))
+	}
+	if p.Price < 0 {
+		reasons = append(reasons, "price must not be negative")
+	}
+	if p.DiscountedPrice != nil {
+		if *p.DiscountedPrice < 0 {
+			reasons = append(reasons, "discountedPrice must not be negative")
+		} else if p.Price > 0 && *p.DiscountedPrice > p.Price {
+			reasons = append(reasons, "discountedPrice must not exceed price")
+		}
+	}
+	if p.PriceInRubles != nil && *p.PriceInRubles < 0 {
+		reasons = append(reasons, "priceInRubles must not be negative")
+	}
+
+	if p.StockStatus != "" && !allowedStockStatuses[p.StockStatus] {
+		reasons = append(reasons, fmt.Sprintf("stockStatus %q must be one of in_stock, low_stock, out_of_stock", p.StockStatus))
+	}
+
+	// JSONB fields
+	reasons = append(reasons, validateImages(p.Images)...)
+	reasons = append(reasons, validateSizes(p.Sizes)...)
+	reasons = append(reasons, validateColors(p.Colors)...)
+	reasons = append(reasons, validateStock(p.Stock)...)
+
+	return reasons
+}
+
+// isEmptyJSON reports whether a JSON field was omitted or sent as null
+func isEmptyJSON(value datatypes.JSON) bool {
+	trimmed := bytes.TrimSpace(value)
+	return len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null"))
+}
+
+// decodeStrict decodes a JSON field into target, rejecting unknown keys
+func decodeStrict(value datatypes.JSON, target interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(target)
+}
+
+// validateImages checks that images is an array of non-empty strings
+func validateImages(images datatypes.JSON) []string {
+	if isEmptyJSON(images) {
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(images, &list); err != nil {
+		return []string{"images must be an array of strings"}
+	}
+	for i, image := range list {
+		if strings.TrimSpace(image) == "" {
+			return []string{fmt.Sprintf("images[%d] must not be empty", i)}
+		}
+	}
+	return nil
+}
+
+// validateSizes checks that sizes is an array of Size objects with a size name
+func validateSizes(sizes datatypes.JSON) []string {
+	if isEmptyJSON(sizes) {
+		return nil
+	}
+
+	var list []Size
+	if err := decodeStrict(sizes, &list); err != nil {
+		return []string{"sizes must be an array of {sizeName, onStock} objects: " + err.Error()}
+	}
+
+	var reasons []string
+	for i, size := range list {
+		if strings.TrimSpace(size.SizeName) == "" {
+			reasons = append(reasons, fmt.Sprintf("sizes[%d].sizeName is required", i))
+		}
+	}
+	return reasons
+}
+
+// validateColors checks that colors is an array of Color objects with hex colors
+func validateColors(colors datatypes.JSON) []string {
+	if isEmptyJSON(colors) {
+		return nil
+	}
+
+	var list []Color
+	if err := decodeStrict(colors, &list); err != nil {
+		return []string{"colors must be an array of {name, hex} objects: " + err.Error()}
+	}
+
+	var reasons []string
+	for i, color := range list {
+		if strings.TrimSpace(color.Name) == "" && color.Hex == "" {
+			reasons = append(reasons, fmt.Sprintf("colors[%d] needs a name or hex", i))
+		}
+		if color.Hex != "" && !hexColorPattern.MatchString(color.Hex) {
+			reasons = append(reasons, fmt.Sprintf("colors[%d].hex %q is not a #RGB or #RRGGBB color", i, color.Hex))
+		}
+	}
+	return reasons
+}
+
+// validateStock checks that stock is a Stock object with a non-negative quantity
+func validateStock(stock datatypes.JSON) []string {
+	if isEmptyJSON(stock) {
+		return nil
+	}
+
+	var value Stock
+	if err := decodeStrict(stock, &value); err != nil {
+		return []string{"stock must be a {quantity, isInStock} object: " + err.Error()}
+	}
+	if value.Quantity < 0 {
+		return []string{"stock.quantity must not be negative"}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package models
+
+import (
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gorm.io/datatypes"
+)
+
+func TestIsPublicAddress(t *testing.T) {
+	tests := []struct {
+		addr   string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"8.8.8.8", true},
+		{"2606:4700:4700::1111", true},
+		{"127.0.0.1", false},
+		{"127.8.8.8", false},
+		{"::1", false},
+		{"10.0.0.1", false},
+		{"172.16.5.4", false},
+		{"172.31.255.255", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"100.127.255.254", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"192.0.2.10", false},
+		{"198.18.0.1", false},
+		{"fc00::1", false},
+		{"fd12:3456:789a::1", false},
+		{"fe80::1", false},
+		{"ff02::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+		{"::ffff:93.184.216.34", true},
+		{"64:ff9b::a9fe:a9fe", false},
+		{"2001:db8::1", false},
+	}
+	for _, test := range tests {
+		if got := IsPublicAddress(netip.MustParseAddr(test.addr)); got != test.public {
+			t.Errorf("IsPublicAddress(%s) = %v, want %v", test.addr, got, test.public)
+		}
+	}
+	if IsPublicAddress(netip.Addr{}) {
+		t.Error("the zero address is public")
+	}
+}
+
+// validProduct returns a product every check accepts
+func validProduct() Product {
+	discounted := 79.99
+	rubles := 2499.0
+	return Product{
+		Name:            "Basic T-Shirt",
+		Store:           "zara",
+		Currency:        "TRY",
+		Price:           99.99,
+		DiscountedPrice: &discounted,
+		PriceInRubles:   &rubles,
+		StockStatus:     StockStatusInStock,
+		Images:          datatypes.JSON(`["https://static.zara.net/photos/a.jpg"]`),
+		Sizes:           datatypes.JSON(`[{"sizeName": "M", "onStock": true}]`),
+		Colors:          datatypes.JSON(`[{"name": "Black", "hex": "#000"}, {"name": "", "hex": "#1A2b3C"}]`),
+		Stock:           datatypes.JSON(`{"quantity": 3, "isInStock": true}`),
+	}
+}
+
+func TestProductValidate(t *testing.T) {
+	price := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name   string
+		modify func(p *Product)
+		want   []string
+	}{
+		{"valid", func(p *Product) {}, nil},
+		{"valid without optional fields", func(p *Product) {
+			*p = Product{Name: "Dress", Store: "zara", Currency: "USD"}
+		}, nil},
+		{"null JSON fields", func(p *Product) {
+			p.Images, p.Sizes, p.Colors, p.Stock = datatypes.JSON("null"), datatypes.JSON(" null "), nil, datatypes.JSON("")
+		}, nil},
+		{"discount without price", func(p *Product) { p.Price = 0 }, nil},
+		{"discount equal to price", func(p *Product) { p.DiscountedPrice = price(p.Price) }, nil},
+
+		{"name missing", func(p *Product) { p.Name = "  " }, []string{"name is required"}},
+		{"store missing", func(p *Product) { p.Store = "" }, []string{"store is required"}},
+		{"currency missing", func(p *Product) { p.Currency = "" }, []string{"currency is required"}},
+		{"currency not ISO 4217", func(p *Product) { p.Currency = "TL" }, []string{`currency "TL" is not an ISO 4217 code`}},
+		{"currency lower case", func(p *Product) { p.Currency = "try" }, []string{`currency "try" is not an ISO 4217 code`}},
+		{"negative price", func(p *Product) { p.Price, p.DiscountedPrice = -1, nil }, []string{"price must not be negative"}},
+		{"negative discounted price", func(p *Product) { p.DiscountedPrice = price(-1) }, []string{"discountedPrice must not be negative"}},
+		{"discount above price", func(p *Product) { p.DiscountedPrice = price(100) }, []string{"discountedPrice must not exceed price"}},
+		{"negative ruble price", func(p *Product) { p.PriceInRubles = price(-0.01) }, []string{"priceInRubles must not be negative"}},
+		{"unknown stock status", func(p *Product) { p.StockStatus = "available" }, []string{`stockStatus "available" must be one of in_stock, low_stock, out_of_stock`}},
+
+		{"images not an array", func(p *Product) { p.Images = datatypes.JSON(`"a.jpg"`) }, []string{"images must be an array of strings"}},
+		{"images not strings", func(p *Product) { p.Images = datatypes.JSON(`[{"url": "a.jpg"}]`) }, []string{"images must be an array of strings"}},
+		{"empty image", func(p *Product) { p.Images = datatypes.JSON(`["a.jpg", " "]`) }, []string{"images[1] must not be empty"}},
+		{"sizes not an array", func(p *Product) { p.Sizes = datatypes.JSON(`{"sizeName": "M"}`) }, []string{"sizes must be an array of {sizeName, onStock} objects"}},
+		{"size with unknown key", func(p *Product) { p.Sizes = datatypes.JSON(`[{"size": "M"}]`) }, []string{"sizes must be an array of {sizeName, onStock} objects"}},
+		{"size without name", func(p *Product) { p.Sizes = datatypes.JSON(`[{"sizeName": "S"}, {"onStock": true}]`) }, []string{"sizes[1].sizeName is required"}},
+		{"colors not an array", func(p *Product) { p.Colors = datatypes.JSON(`"black"`) }, []string{"colors must be an array of {name, hex} objects"}},
+		{"color with unknown key", func(p *Product) { p.Colors = datatypes.JSON(`[{"rgb": "0,0,0"}]`) }, []string{"colors must be an array of {name, hex} objects"}},
+		{"color without name or hex", func(p *Product) { p.Colors = datatypes.JSON(`[{"name": " "}]`) }, []string{"colors[0] needs a name or hex"}},
+		{"color hex not a color", func(p *Product) { p.Colors = datatypes.JSON(`[{"name": "Red", "hex": "red"}, {"hex": "#12345"}]`) }, []string{
+			`colors[0].hex "red" is not a #RGB or #RRGGBB color`,
+			`colors[1].hex "#12345" is not a #RGB or #RRGGBB color`,
+		}},
+		{"stock not an object", func(p *Product) { p.Stock = datatypes.JSON(`[3]`) }, []string{"stock must be a {quantity, isInStock} object"}},
+		{"stock with unknown key", func(p *Product) { p.Stock = datatypes.JSON(`{"qty": 3}`) }, []string{"stock must be a {quantity, isInStock} object"}},
+		{"negative stock", func(p *Product) { p.Stock = datatypes.JSON(`{"quantity": -1, "isInStock": false}`) }, []string{"stock.quantity must not be negative"}},
+
+		{"every reason is reported", func(p *Product) {
+			p.Name, p.Currency, p.Price, p.StockStatus = "", "XYZ", -5, "sold"
+			p.Sizes = datatypes.JSON(`[{"sizeName": ""}]`)
+		}, []string{
+			"name is required",
+			`currency "XYZ" is not an ISO 4217 code`,
+			"price must not be negative",
+			`stockStatus "sold" must be one of in_stock, low_stock, out_of_stock`,
+			"sizes[0].sizeName is required",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			product := validProduct()
+			tt.modify(&product)
+			reasons := product.Validate()
+
+			// Decoding errors end with the decoder's message, only the prefix is compared
+			got := make([]string, len(reasons))
+			for i, reason := range reasons {
+				got[i], _, _ = strings.Cut(reason, ": ")
+			}
+			if len(tt.want) == 0 && len(got) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("reasons %q, want %q", reasons, tt.want)
+			}
+		})
+	}
+}
@@ -1,13 +1,15 @@
 package routes
 
 import (
+	"product-api/config"
 	"product-api/handlers"
+	"product-api/utils"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
-func SetupRoutes(db *gorm.DB) *gin.Engine {
+func SetupRoutes(db *gorm.DB, cfg *config.Config, storage utils.Storage) *gin.Engine {
 	r := gin.Default()
 
 	// CORS middleware
@@ -37,8 +39,9 @@ func SetupRoutes(db *gorm.DB) *gin.Engine {
 			c.Header("Access-Control-Allow-Origin", "*") // Geliştirme için
 		}
 		
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match, If-None-Match")
+		c.Header("Access-Control-Expose-Headers", "ETag")
 		c.Header("Access-Control-Allow-Credentials", "true")
 		
 		if c.Request.Method == "OPTIONS" {
@@ -49,7 +52,18 @@ func SetupRoutes(db *gorm.DB) *gin.Engine {
 	})
 
 	// Initialize handlers
-	productHandler := handlers.NewProductHandler(db)
+	productHandler := handlers.NewProductHandler(db, cfg, storage)
+	pricingHandler := handlers.NewPricingHandler(db, productHandler.Pricing, productHandler.Currency)
+	exchangeRateHandler := handlers.NewExchangeRateHandler(db, productHandler.Currency)
+	alertHandler := handlers.NewAlertHandler(db)
+	imageHandler := handlers.NewImageHandler(db, productHandler.Images)
+
+	// Start background workers
+	productHandler.StartImportWorkers(cfg.ImportWorkers)
+	alertHandler.StartAlertDispatchers(cfg.AlertWorkers)
+	if cfg.MirrorImages {
+		productHandler.StartImageWorkers(cfg.ImageWorkers)
+	}
 
 	// API routes
 	api := r.Group("/api")
@@ -58,6 +72,15 @@ func SetupRoutes(db *gorm.DB) *gin.Engine {
 		{
 			// Bulk product insert
 			stock.POST("/add", productHandler.BulkCreateProducts)
+			// Streaming bulk insert, one product per line (application/x-ndjson)
+			stock.POST("/add/ndjson", productHandler.BulkCreateProductsNDJSON)
+
+			// Asynchronous bulk insert jobs
+			jobs := stock.Group("/jobs")
+			{
+				jobs.POST("", productHandler.SubmitImportJob)
+				jobs.GET("/:id", productHandler.GetImportJob)
+			}
 			
 			// Integration endpoints
 			integration := stock.Group("/integration")
@@ -69,6 +92,27 @@ func SetupRoutes(db *gorm.DB) *gin.Engine {
 			
 			// Store specific endpoints
 			stock.GET("/store/:store", productHandler.GetProductsByStore)
+
+			// Facet counts for filter sidebars
+			stock.GET("/facets", productHandler.GetFacets)
+
+			// Full-text and fuzzy product search
+			stock.GET("/search", productHandler.SearchProducts)
+
+			// Is this product URL already stored? Matched on the canonical URL
+			stock.GET("/products/by-url", productHandler.GetProductByURL)
+			stock.POST("/products/by-url", productHandler.LookupProductURLs)
+			// Product stored for a retailer's own product ID
+			stock.GET("/products/by-external-id", productHandler.GetProductByExternalID)
+
+			// Single product CRUD, If-Match with the ETag guards against lost updates
+			stock.GET("/products/:id", productHandler.GetProduct)
+			stock.PUT("/products/:id", productHandler.ReplaceProduct)
+			stock.PATCH("/products/:id", productHandler.PatchProduct)
+			stock.DELETE("/products/:id", productHandler.DeleteProduct)
+
+			// Price timeline of a single product
+			stock.GET("/products/:id/price-history", productHandler.GetPriceHistory)
 			
 			// NEW: Products WITH images - use when images are needed
 			stock.GET("/with-images", productHandler.GetProductsWithImages)
@@ -82,10 +126,49 @@ func SetupRoutes(db *gorm.DB) *gin.Engine {
 				images.POST("/batch", productHandler.GetMultipleProductImages)
 			}
 		}
+
+		pricing := api.Group("/pricing")
+		{
+			// Pricing rules used to compute ruble prices
+			rules := pricing.Group("/rules")
+			{
+				rules.GET("", pricingHandler.GetPricingRules)
+				rules.POST("", pricingHandler.CreatePricingRule)
+				rules.GET("/:id", pricingHandler.GetPricingRule)
+				rules.PUT("/:id", pricingHandler.UpdatePricingRule)
+				rules.DELETE("/:id", pricingHandler.DeletePricingRule)
+			}
+			// Reprice stored products after rules changed, in the background
+			pricing.POST("/recalculate", pricingHandler.RecalculatePrices)
+			pricing.GET("/recalculate", pricingHandler.GetRecalculation)
+		}
+
+		// Exchange rates used for converted prices and ?currency= on read endpoints
+		fx := api.Group("/fx")
+		{
+			fx.GET("/rates", exchangeRateHandler.GetExchangeRates)
+			fx.POST("/rates", exchangeRateHandler.UploadExchangeRates)
+		}
+
+		// Stored image records
+		api.GET("/images/:hash", imageHandler.GetImage)
+		// Background image download queue, per store
+		api.GET("/images/jobs/stats", productHandler.GetImageJobStats)
+
+		// Price drop and back-in-stock webhooks
+		alerts := api.Group("/alerts")
+		{
+			alerts.GET("/subscriptions", alertHandler.GetAlertSubscriptions)
+			alerts.POST("/subscriptions", alertHandler.CreateAlertSubscription)
+			alerts.DELETE("/subscriptions/:id", alertHandler.DeleteAlertSubscription)
+			alerts.GET("/dead-letters", alertHandler.GetAlertDeadLetters)
+			alerts.POST("/dead-letters/:id/retry", alertHandler.RetryAlertDeadLetter)
+		}
 	}
 
-	// Static file serving for uploaded images
-	r.Static("/uploads", "./uploads")
+	// Files of the image storage, ?w= serves a resized variant
+	r.GET("/uploads/*filepath", imageHandler.ServeUpload)
+	r.HEAD("/uploads/*filepath", imageHandler.ServeUpload)
 
 	// Health check
 	r.GET("/health", func(c *gin.Context) {
@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"net"
+	"net/url"
+	"strings"
+	"sync/atomic"
+)
+
+// DefaultTrackingParams are the query parameters dropped from canonical URLs unless
+// URL_TRACKING_PARAMS says otherwise. A trailing * matches any suffix.
+var DefaultTrackingParams = []string{
+	"utm_*", "gclid", "fbclid", "yclid", "msclkid", "igshid", "mc_cid", "mc_eid", "_openstat",
+}
+
+// URLCanonicalizer turns product URLs into the form products are deduplicated on
+type URLCanonicalizer struct {
+	exact    map[string]bool
+	prefixes []string
+}
+
+// NewURLCanonicalizer creates a canonicalizer dropping the given tracking parameters.
+// Names are case insensitive; a trailing * makes a name a prefix, e.g. "utm_*".
+func NewURLCanonicalizer(trackingParams []string) *URLCanonicalizer {
+	uc := &URLCanonicalizer{exact: make(map[string]bool, len(trackingParams))}
+	for _, param := range trackingParams {
+		param = strings.ToLower(strings.TrimSpace(param))
+		switch {
+		case param == "" || param == "*":
+			continue
+		case strings.HasSuffix(param, "*"):
+			uc.prefixes = append(uc.prefixes, strings.TrimSuffix(param, "*"))
+		default:
+			uc.exact[param] = true
+		}
+	}
+	return uc
+}
+
+// isTrackingParam reports whether a query parameter is dropped from canonical URLs
+func (uc *URLCanonicalizer) isTrackingParam(key string) bool {
+	key = strings.ToLower(key)
+	if uc.exact[key] {
+		return true
+	}
+	for _, prefix := range uc.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Canonical returns the form of a product URL used to recognise the same page:
+// https scheme, lowercase host without "www." and default port, no trailing slash,
+// no fragment, no tracking parameters and the remaining query parameters sorted.
+// Values that are not absolute http(s) URLs have no canonical form and give "",
+// so such products are never merged with one another.
+func (uc *URLCanonicalizer) Canonical(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return ""
+	}
+
+	candidate := raw
+	if !strings.Contains(candidate, "://") && !strings.HasPrefix(candidate, "/") {
+		// Scrapers sometimes drop the scheme, "zara.com/p-1"
+		candidate = "https://" + candidate
+	}
+	u, err := url.Parse(candidate)
+	if err != nil || u.Hostname() == "" {
+		return ""
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if scheme != "http" && scheme != "https" {
+		return ""
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	host = strings.TrimPrefix(host, "www.")
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		host = net.JoinHostPort(host, port)
+	}
+
+	path := strings.TrimRight(u.EscapedPath(), "/")
+
+	query := u.Query()
+	for key := range query {
+		if uc.isTrackingParam(key) {
+			query.Del(key)
+		}
+	}
+
+	canonical := "https://" + host + path
+	// Encode sorts the parameters by key
+	if encoded := query.Encode(); encoded != "" {
+		canonical += "?" + encoded
+	}
+	return canonical
+}
+
+// canonicalizer is the canonicalizer used by CanonicalURL
+var canonicalizer atomic.Pointer[URLCanonicalizer]
+
+func init() {
+	canonicalizer.Store(NewURLCanonicalizer(DefaultTrackingParams))
+}
+
+// SetTrackingParams replaces the tracking parameters CanonicalURL drops. Call it at
+// startup; canonical URLs stored with other settings are only rewritten by
+// cmd/backfill_canonical_urls.
+func SetTrackingParams(params []string) {
+	canonicalizer.Store(NewURLCanonicalizer(params))
+}
+
+// CanonicalURL canonicalizes a product URL with the configured tracking parameters
+func CanonicalURL(raw string) string {
+	return canonicalizer.Load().Canonical(raw)
+}
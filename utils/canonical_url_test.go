@@ -0,0 +1,73 @@
+package utils
+
+import "testing"
+
+func TestCanonicalURL(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"host case", "https://Shop.Example.COM/p/1", "https://shop.example.com/p/1"},
+		{"www stripped", "https://www.example.com/p/1", "https://example.com/p/1"},
+		{"trailing dot", "https://example.com./p/1", "https://example.com/p/1"},
+		{"default https port", "https://example.com:443/p/1", "https://example.com/p/1"},
+		{"default http port", "http://example.com:80/p/1", "https://example.com/p/1"},
+		{"other port kept", "https://example.com:8443/p/1", "https://example.com:8443/p/1"},
+		{"http upgraded", "http://example.com/p/1", "https://example.com/p/1"},
+		{"scheme case", "HTTPS://example.com/p/1", "https://example.com/p/1"},
+		{"missing scheme", "example.com/p/1", "https://example.com/p/1"},
+		{"fragment removed", "https://example.com/p/1#reviews", "https://example.com/p/1"},
+		{"trailing slash", "https://example.com/p/1/", "https://example.com/p/1"},
+		{"root", "https://example.com/", "https://example.com"},
+		{"path case kept", "https://example.com/P/Dress", "https://example.com/P/Dress"},
+		{"surrounding space", "  https://example.com/p/1  ", "https://example.com/p/1"},
+		{"exact tracking params", "https://example.com/p/1?gclid=x&fbclid=y&color=red", "https://example.com/p/1?color=red"},
+		{"tracking params ignore case", "https://example.com/p/1?GCLID=x&color=red", "https://example.com/p/1?color=red"},
+		{"prefix tracking params", "https://example.com/p/1?utm_source=a&utm_medium=b&size=m", "https://example.com/p/1?size=m"},
+		{"only tracking params", "https://example.com/p/1?utm_source=a", "https://example.com/p/1"},
+		{"query sorted", "https://example.com/p/1?size=m&color=red&a=1", "https://example.com/p/1?a=1&color=red&size=m"},
+		{"repeated values kept in order", "https://example.com/p/1?v=2&v=1", "https://example.com/p/1?v=2&v=1"},
+		{"empty", "", ""},
+		{"blank", "   ", ""},
+		{"relative path", "/p/1", ""},
+		{"other scheme", "ftp://example.com/p/1", ""},
+		{"no host", "https:///p/1", ""},
+		{"unparseable", "https://exa mple.com/%zz", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CanonicalURL(tt.raw); got != tt.want {
+				t.Errorf("CanonicalURL(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestURLCanonicalizerTrackingParams(t *testing.T) {
+	uc := NewURLCanonicalizer([]string{" Ref ", "ga_*", "", "*"})
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"https://example.com/p?ref=home&id=1", "https://example.com/p?id=1"},
+		{"https://example.com/p?ga_client=1&ga_session=2&id=1", "https://example.com/p?id=1"},
+		{"https://example.com/p?referrer=x", "https://example.com/p?referrer=x"},
+		// Only the configured names are dropped, not the defaults
+		{"https://example.com/p?utm_source=a&gclid=b", "https://example.com/p?gclid=b&utm_source=a"},
+	}
+	for _, tt := range tests {
+		if got := uc.Canonical(tt.raw); got != tt.want {
+			t.Errorf("Canonical(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestSetTrackingParams(t *testing.T) {
+	t.Cleanup(func() { SetTrackingParams(DefaultTrackingParams) })
+
+	SetTrackingParams([]string{"session"})
+	if got, want := CanonicalURL("https://example.com/p?session=1&utm_source=a"), "https://example.com/p?utm_source=a"; got != want {
+		t.Errorf("CanonicalURL with configured params = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"product-api/models"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// exchangeRatesTTL is how long loaded exchange rates are trusted before they are read again
+const exchangeRatesTTL = time.Minute
+
+// CurrencyConverter converts prices with the exchange rates currently in effect
+type CurrencyConverter struct {
+	db      *gorm.DB
+	targets []string
+
+	mu       sync.RWMutex
+	rates    map[string]map[string]float64
+	loadedAt time.Time
+}
+
+// NewCurrencyConverter creates a converter reading its rates from db.
+// targets are the currencies every ingested product gets converted prices in.
+func NewCurrencyConverter(db *gorm.DB, targets []string) *CurrencyConverter {
+	return &CurrencyConverter{db: db, targets: targets}
+}
+
+// Targets returns the currencies converted prices are stored in
+func (cc *CurrencyConverter) Targets() []string {
+	return cc.targets
+}
+
+// Reload reads the latest rate of every currency pair that is already in effect
+func (cc *CurrencyConverter) Reload() error {
+	var rows []models.ExchangeRate
+	if err := cc.db.Raw(`SELECT DISTINCT ON (base_currency, quote_currency) *
+		FROM exchange_rates
+		WHERE effective_date <= CURRENT_DATE
+		ORDER BY base_currency, quote_currency, effective_date DESC`).Scan(&rows).Error; err != nil {
+		return err
+	}
+
+	rates := make(map[string]map[string]float64)
+	set := func(from, to string, rate float64) {
+		if rates[from] == nil {
+			rates[from] = make(map[string]float64)
+		}
+		rates[from][to] = rate
+	}
+	// Inverse rates first, so an explicitly uploaded rate for the opposite pair wins
+	for _, row := range rows {
+		set(row.QuoteCurrency, row.BaseCurrency, 1/row.Rate)
+	}
+	for _, row := range rows {
+		set(row.BaseCurrency, row.QuoteCurrency, row.Rate)
+	}
+
+	cc.mu.Lock()
+	cc.rates = rates
+	cc.loadedAt = time.Now()
+	cc.mu.Unlock()
+
+	log.Printf("[DEBUG] CurrencyConverter: Loaded %d exchange rates", len(rows))
+	return nil
+}
+
+// currentRates returns the cached rates, reloading them once they are stale.
+// A failed reload keeps serving the previous rates.
+func (cc *CurrencyConverter) currentRates() map[string]map[string]float64 {
+	cc.mu.RLock()
+	rates, loadedAt := cc.rates, cc.loadedAt
+	cc.mu.RUnlock()
+
+	if time.Since(loadedAt) < exchangeRatesTTL {
+		return rates
+	}
+	if err := cc.Reload(); err != nil {
+		log.Printf("[ERROR] CurrencyConverter: Failed to reload exchange rates: %v", err)
+		return rates
+	}
+
+	cc.mu.RLock()
+	defer cc.mu.RUnlock()
+	return cc.rates
+}
+
+// Rate returns how many units of to one unit of from is worth. Pairs without a
+// direct or inverse rate are crossed through a currency both have a rate with;
+// when several could be used, the first in alphabetical order is, so the same
+// rates always give the same result.
+func (cc *CurrencyConverter) Rate(from, to string) (float64, bool) {
+	if from == to {
+		return 1, true
+	}
+
+	rates := cc.currentRates()
+	if rate, ok := rates[from][to]; ok {
+		return rate, true
+	}
+
+	pivots := make([]string, 0, len(rates[from]))
+	for via := range rates[from] {
+		if _, ok := rates[via][to]; ok {
+			pivots = append(pivots, via)
+		}
+	}
+	if len(pivots) == 0 {
+		return 0, false
+	}
+	sort.Strings(pivots)
+	via := pivots[0]
+	return rates[from][via] * rates[via][to], true
+}
+
+// Convert converts an amount and rounds it to two decimals
+func (cc *CurrencyConverter) Convert(amount float64, from, to string) (float64, bool) {
+	rate, ok := cc.Rate(from, to)
+	if !ok {
+		return 0, false
+	}
+	return math.Round(amount*rate*100) / 100, true
+}
+
+// ConvertProduct converts Price and DiscountedPrice of a product into another currency
+func (cc *CurrencyConverter) ConvertProduct(product *models.Product, to string) (models.ConvertedPrice, bool) {
+	price, ok := cc.Convert(product.Price, product.Currency, to)
+	if !ok {
+		return models.ConvertedPrice{}, false
+	}
+
+	converted := models.ConvertedPrice{Price: price}
+	if product.DiscountedPrice != nil {
+		discounted, _ := cc.Convert(*product.DiscountedPrice, product.Currency, to)
+		converted.DiscountedPrice = &discounted
+	}
+	return converted, true
+}
+
+// Apply sets ConvertedPrices of a product to its prices in every target currency
+// a rate is known for. It is cleared when no target can be converted.
+func (cc *CurrencyConverter) Apply(product *models.Product) {
+	product.ConvertedPrices = nil
+
+	convertedPrices := make(map[string]models.ConvertedPrice, len(cc.targets))
+	for _, target := range cc.targets {
+		if converted, ok := cc.ConvertProduct(product, target); ok {
+			convertedPrices[target] = converted
+		}
+	}
+	if len(convertedPrices) == 0 {
+		return
+	}
+
+	// encoding/json sorts map keys, so equal conversions always encode to equal bytes
+	encoded, err := json.Marshal(convertedPrices)
+	if err != nil {
+		log.Printf("[ERROR] CurrencyConverter: Failed to encode converted prices of %s: %v", product.ID, err)
+		return
+	}
+	product.ConvertedPrices = datatypes.JSON(encoded)
+}
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestCurrencyConverter creates a converter serving rates without a database
+func newTestCurrencyConverter(rates map[string]map[string]float64) *CurrencyConverter {
+	return &CurrencyConverter{rates: rates, loadedAt: time.Now().Add(time.Hour)}
+}
+
+func TestCurrencyConverterRateCrossesDeterministically(t *testing.T) {
+	// TRY reaches RUB both through EUR and through USD, at slightly different rates
+	cc := newTestCurrencyConverter(map[string]map[string]float64{
+		"TRY": {"USD": 0.03, "EUR": 0.028, "GBP": 0.024},
+		"USD": {"RUB": 90},
+		"EUR": {"RUB": 97},
+	})
+	want := 0.028 * 97
+	for i := 0; i < 100; i++ {
+		rate, ok := cc.Rate("TRY", "RUB")
+		if !ok || rate != want {
+			t.Fatalf("run %d: got %v, %v, want %v through EUR", i, rate, ok, want)
+		}
+	}
+}
+
+func TestCurrencyConverterRate(t *testing.T) {
+	cc := newTestCurrencyConverter(map[string]map[string]float64{
+		"TRY": {"RUB": 2.5, "USD": 0.03},
+		"USD": {"RUB": 90},
+	})
+	tests := []struct {
+		from, to string
+		rate     float64
+		ok       bool
+	}{
+		{"RUB", "RUB", 1, true},
+		{"TRY", "RUB", 2.5, true},
+		{"USD", "RUB", 90, true},
+		{"TRY", "EUR", 0, false},
+	}
+	for _, test := range tests {
+		if rate, ok := cc.Rate(test.from, test.to); rate != test.rate || ok != test.ok {
+			t.Errorf("Rate(%s, %s) = %v, %v, want %v, %v", test.from, test.to, rate, ok, test.rate, test.ok)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// HostLimiter bounds the requests running at the same time against each host and
+// the rate they start at, so downloads do not hammer a single CDN
+type HostLimiter struct {
+	concurrency int
+	rate        rate.Limit
+	burst       int
+
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+// hostState is the slots and start rate of one host
+type hostState struct {
+	slots   chan struct{}
+	limiter *rate.Limiter
+}
+
+// NewHostLimiter allows concurrency requests per host at once, started at no more
+// than perSecond per second; perSecond <= 0 leaves the rate unlimited
+func NewHostLimiter(concurrency int, perSecond float64) *HostLimiter {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	limit, burst := rate.Inf, 0
+	if perSecond > 0 {
+		limit, burst = rate.Limit(perSecond), concurrency
+	}
+	return &HostLimiter{
+		concurrency: concurrency,
+		rate:        limit,
+		burst:       burst,
+		hosts:       make(map[string]*hostState),
+	}
+}
+
+// state returns the slots and limiter of host, creating them on first use
+func (hl *HostLimiter) state(host string) *hostState {
+	hl.mu.Lock()
+	defer hl.mu.Unlock()
+	state, ok := hl.hosts[host]
+	if !ok {
+		state = &hostState{
+			slots:   make(chan struct{}, hl.concurrency),
+			limiter: rate.NewLimiter(hl.rate, hl.burst),
+		}
+		hl.hosts[host] = state
+	}
+	return state
+}
+
+// Acquire waits for a free slot of host and for the host's rate to allow another
+// request. Every successful Acquire must be followed by Release.
+func (hl *HostLimiter) Acquire(ctx context.Context, host string) error {
+	state := hl.state(host)
+	select {
+	case state.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if err := state.limiter.Wait(ctx); err != nil {
+		<-state.slots
+		return err
+	}
+	return nil
+}
+
+// Release frees the slot of host taken by Acquire
+func (hl *HostLimiter) Release(host string) {
+	<-hl.state(host).slots
+}
+
+// Saturated returns the hosts without a free slot, which are better skipped when
+// picking the next request
+func (hl *HostLimiter) Saturated() []string {
+	hl.mu.Lock()
+	defer hl.mu.Unlock()
+	var hosts []string
+	for host, state := range hl.hosts {
+		if len(state.slots) >= cap(state.slots) {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
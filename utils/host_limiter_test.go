@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// acquireWithin calls Acquire with a deadline of wait
+func acquireWithin(hl *HostLimiter, host string, wait time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), wait)
+	defer cancel()
+	return hl.Acquire(ctx, host)
+}
+
+func TestHostLimiterConcurrency(t *testing.T) {
+	hl := NewHostLimiter(2, 0)
+
+	for i := 0; i < 2; i++ {
+		if err := acquireWithin(hl, "cdn.example.com", time.Second); err != nil {
+			t.Fatalf("slot %d: %v", i, err)
+		}
+	}
+	if err := acquireWithin(hl, "cdn.example.com", 20*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("third request of a host got %v, want it to wait", err)
+	}
+	if busy := hl.Saturated(); len(busy) != 1 || busy[0] != "cdn.example.com" {
+		t.Fatalf("saturated hosts %v", busy)
+	}
+
+	// Other hosts have their own slots
+	if err := acquireWithin(hl, "img.example.org", 20*time.Millisecond); err != nil {
+		t.Fatalf("other host: %v", err)
+	}
+	hl.Release("img.example.org")
+
+	hl.Release("cdn.example.com")
+	if busy := hl.Saturated(); len(busy) != 0 {
+		t.Fatalf("saturated hosts %v after a release", busy)
+	}
+	if err := acquireWithin(hl, "cdn.example.com", 20*time.Millisecond); err != nil {
+		t.Fatalf("released slot: %v", err)
+	}
+}
+
+func TestHostLimiterRate(t *testing.T) {
+	hl := NewHostLimiter(1, 20)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := acquireWithin(hl, "cdn.example.com", time.Second); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		hl.Release("cdn.example.com")
+	}
+	// The first request uses the burst, the other four wait 50ms each
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("5 requests at 20/s started within %v", elapsed)
+	}
+}
+
+func TestHostLimiterRateWaitGivesSlotBack(t *testing.T) {
+	hl := NewHostLimiter(1, 0.1)
+	if err := acquireWithin(hl, "cdn.example.com", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	hl.Release("cdn.example.com")
+
+	// The next start is 10s away, beyond the deadline
+	if err := acquireWithin(hl, "cdn.example.com", 50*time.Millisecond); err == nil {
+		t.Fatal("acquired a slot the rate does not allow yet")
+	}
+	if busy := hl.Saturated(); len(busy) != 0 {
+		t.Fatalf("a failed Acquire kept its slot: %v", busy)
+	}
+}
+
+func TestNewHostLimiterAllowsOneRequestAtLeast(t *testing.T) {
+	hl := NewHostLimiter(0, 0)
+	if err := acquireWithin(hl, "cdn.example.com", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := acquireWithin(hl, "cdn.example.com", 20*time.Millisecond); err == nil {
+		t.Fatal("a limiter created with concurrency 0 allowed two requests")
+	}
+}
@@ -0,0 +1,224 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"product-api/models"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Errors returned for images that are not fetched
+var (
+	ErrInvalidImageURL  = errors.New("invalid image URL")
+	ErrBlockedAddress   = errors.New("address is not public")
+	ErrHostNotAllowed   = errors.New("host is not allowed")
+	ErrTooManyRedirects = errors.New("too many redirects")
+	ErrImageTooLarge    = fmt.Errorf("image size exceeds maximum allowed size of %d bytes", MaxImageSize)
+	ErrNotAnImage       = errors.New("content is not a JPEG, PNG, GIF or WebP image")
+)
+
+// HTTPStatusError is an image download answered with another status than 200
+type HTTPStatusError struct {
+	StatusCode int
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("failed to download image: HTTP %d", e.StatusCode)
+}
+
+// IsPermanentFetchError reports whether downloading an image again cannot succeed:
+// the URL is refused, the content is not an image or the server answered with a
+// client error other than 408 and 429
+func IsPermanentFetchError(err error) bool {
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		code := statusErr.StatusCode
+		return code >= 400 && code < 500 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests
+	}
+	for _, permanent := range []error{ErrInvalidImageURL, ErrBlockedAddress, ErrHostNotAllowed, ErrTooManyRedirects, ErrImageTooLarge, ErrNotAnImage} {
+		if errors.Is(err, permanent) {
+			return true
+		}
+	}
+	return false
+}
+
+// SniffImage tells the format of image data from its leading bytes, whatever
+// headers or file names claim. ok is false for anything but JPEG, PNG, GIF and WebP.
+func SniffImage(data []byte) (mimeType, extension string, ok bool) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return "image/jpeg", "jpg", true
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png", "png", true
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return "image/gif", "gif", true
+	case len(data) >= 12 && bytes.Equal(data[0:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")):
+		return "image/webp", "webp", true
+	default:
+		return "", "", false
+	}
+}
+
+// ImageFetcher downloads images from public addresses only. Addresses are checked
+// on every connection, after DNS resolution, so neither redirects nor DNS answers
+// changing between check and use reach internal services.
+type ImageFetcher struct {
+	client *http.Client
+
+	// allowedHosts are the image hosts per lowercase store name; "*" applies to
+	// stores without their own entry. Stores without any entry may use any host.
+	allowedHosts map[string][]string
+}
+
+// newPublicTransport creates a transport that only connects to public addresses.
+// The check runs on the resolved address actually dialed, for every connection.
+func newPublicTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		// Control sees the resolved address actually dialed
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil || !models.IsPublicAddress(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", ErrBlockedAddress, address)
+			}
+			return nil
+		},
+	}
+	return &http.Transport{
+		// A proxy would be dialed instead of the target host, so none is used
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 15 * time.Second,
+		MaxIdleConnsPerHost:   4,
+		IdleConnTimeout:       90 * time.Second,
+	}
+}
+
+// checkPublicURL rejects URLs that are not http(s), reported as invalid, or point to
+// an IP literal outside the public ranges. Names are checked once resolved, when dialed.
+func checkPublicURL(u *url.URL, invalid error) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: unsupported scheme %q", invalid, u.Scheme)
+	}
+	host := u.Hostname()
+	if host == "" {
+		return fmt.Errorf("%w: missing host", invalid)
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !models.IsPublicAddress(addr) {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+	}
+	return nil
+}
+
+// NewImageFetcher creates a fetcher following at most maxRedirects redirects and
+// restricting stores to the hosts of allowedHosts. A host "*.example.com" matches
+// the subdomains of example.com.
+func NewImageFetcher(allowedHosts map[string][]string, maxRedirects int) *ImageFetcher {
+	hosts := make(map[string][]string, len(allowedHosts))
+	for store, list := range allowedHosts {
+		hosts[strings.ToLower(store)] = list
+	}
+	fetcher := &ImageFetcher{allowedHosts: hosts}
+	fetcher.client = &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: newPublicTransport(),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxRedirects {
+				return fmt.Errorf("%w, stopped after %d", ErrTooManyRedirects, maxRedirects)
+			}
+			store, _ := req.Context().Value(fetchStoreKey{}).(string)
+			return fetcher.checkURL(store, req.URL)
+		},
+	}
+	return fetcher
+}
+
+// fetchStoreKey carries the store of a fetch to the redirect check
+type fetchStoreKey struct{}
+
+// hostAllowed reports whether store may fetch images from host
+func (f *ImageFetcher) hostAllowed(store, host string) bool {
+	patterns, ok := f.allowedHosts[strings.ToLower(store)]
+	if !ok {
+		if patterns, ok = f.allowedHosts["*"]; !ok {
+			return true
+		}
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if suffix, wildcard := strings.CutPrefix(pattern, "*."); wildcard {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// checkURL rejects URLs that are not http(s), point to an IP literal outside the
+// public ranges or to a host the store may not use
+func (f *ImageFetcher) checkURL(store string, u *url.URL) error {
+	if err := checkPublicURL(u, ErrInvalidImageURL); err != nil {
+		return err
+	}
+	if host := u.Hostname(); !f.hostAllowed(store, host) {
+		return fmt.Errorf("%w for store %q: %s", ErrHostNotAllowed, store, host)
+	}
+	return nil
+}
+
+// Fetch downloads an image of store from rawURL. The response must be at most
+// MaxImageSize bytes and start like a JPEG, PNG, GIF or WebP image.
+func (f *ImageFetcher) Fetch(ctx context.Context, store, rawURL string) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImageURL, err)
+	}
+	if err := f.checkURL(store, u); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(context.WithValue(ctx, fetchStoreKey{}, store), http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "image/jpeg, image/png, image/gif, image/webp")
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download image: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &HTTPStatusError{StatusCode: resp.StatusCode}
+	}
+	if resp.ContentLength > MaxImageSize {
+		return nil, ErrImageTooLarge
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxImageSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image data: %v", err)
+	}
+	if len(data) > MaxImageSize {
+		return nil, ErrImageTooLarge
+	}
+	if _, _, ok := SniffImage(data); !ok {
+		return nil, ErrNotAnImage
+	}
+	return data, nil
+}
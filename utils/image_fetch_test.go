@@ -0,0 +1,221 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// testJPEG starts like a JPEG, which is all SniffImage looks at
+var testJPEG = []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00")
+
+func TestSniffImage(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		extension string
+		ok        bool
+	}{
+		{"jpeg", string(testJPEG), "jpg", true},
+		{"png", "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", "png", true},
+		{"gif87a", "GIF87a\x01\x00", "gif", true},
+		{"gif89a", "GIF89a\x01\x00", "gif", true},
+		{"webp", "RIFF\x24\x00\x00\x00WEBPVP8 ", "webp", true},
+		{"riff but not webp", "RIFF\x24\x00\x00\x00WAVEfmt ", "", false},
+		{"html", "<!DOCTYPE html><html><body>Not found</body></html>", "", false},
+		{"svg", `<svg xmlns="http://www.w3.org/2000/svg"></svg>`, "", false},
+		{"empty", "", "", false},
+	}
+	for _, test := range tests {
+		_, extension, ok := SniffImage([]byte(test.data))
+		if ok != test.ok || extension != test.extension {
+			t.Errorf("%s: got %q, %v, want %q, %v", test.name, extension, ok, test.extension, test.ok)
+		}
+	}
+}
+
+// newImageTestServer serves testJPEG at /img.jpg, HTML claiming to be a JPEG at
+// /page.jpg, a redirect chain of n hops at /hop/<n> and a redirect to any URL at
+// /redirect?to=<url>. It counts the requests it answers.
+func newImageTestServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	var requests atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/img.jpg", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(testJPEG)
+	})
+	mux.HandleFunc("/page.jpg", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("<!DOCTYPE html><html><body>Access denied</body></html>"))
+	})
+	mux.HandleFunc("/hop/{n}", func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.PathValue("n"))
+		if n <= 0 {
+			http.Redirect(w, r, "/img.jpg", http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, "/hop/"+strconv.Itoa(n-1), http.StatusFound)
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, r.URL.Query().Get("to"), http.StatusFound)
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+// routedTransport connects to server whatever host a request is for. httptest only
+// listens on loopback, which the public transport refuses; swapping the transport
+// keeps the URL, allowlist and redirect checks of the client as in production.
+func routedTransport(server *httptest.Server) *http.Transport {
+	return &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, server.Listener.Addr().String())
+		},
+	}
+}
+
+// newRoutedImageFetcher creates a fetcher whose connections reach server
+func newRoutedImageFetcher(server *httptest.Server, allowedHosts map[string][]string, maxRedirects int) *ImageFetcher {
+	fetcher := NewImageFetcher(allowedHosts, maxRedirects)
+	fetcher.client.Transport = routedTransport(server)
+	return fetcher
+}
+
+func TestImageFetcherFetch(t *testing.T) {
+	server, _ := newImageTestServer(t)
+	fetcher := newRoutedImageFetcher(server, nil, 3)
+
+	data, err := fetcher.Fetch(context.Background(), "zara", "http://static.zara.net/img.jpg")
+	if err != nil || string(data) != string(testJPEG) {
+		t.Fatalf("got %q, %v", data, err)
+	}
+}
+
+func TestImageFetcherRejectsHTMLServedAsImage(t *testing.T) {
+	server, _ := newImageTestServer(t)
+	fetcher := newRoutedImageFetcher(server, nil, 3)
+
+	_, err := fetcher.Fetch(context.Background(), "zara", "http://static.zara.net/page.jpg")
+	if !errors.Is(err, ErrNotAnImage) || !IsPermanentFetchError(err) {
+		t.Fatalf("got %v, want a permanent ErrNotAnImage", err)
+	}
+}
+
+func TestImageFetcherRefusesRedirectToPrivateAddress(t *testing.T) {
+	server, _ := newImageTestServer(t)
+	fetcher := newRoutedImageFetcher(server, nil, 3)
+
+	for _, target := range []string{
+		"http://169.254.169.254/latest/meta-data/",
+		"http://127.0.0.1:8080/admin",
+		"http://10.0.0.5/internal.jpg",
+		"http://[::1]/img.jpg",
+		"http://[::ffff:192.168.0.1]/img.jpg",
+		"file:///etc/passwd",
+	} {
+		_, err := fetcher.Fetch(context.Background(), "zara", "http://static.zara.net/redirect?to="+target)
+		if !errors.Is(err, ErrBlockedAddress) && !errors.Is(err, ErrInvalidImageURL) {
+			t.Errorf("redirect to %s: got %v, want it refused", target, err)
+		}
+	}
+}
+
+func TestImageFetcherRefusesPrivateAddressAfterResolution(t *testing.T) {
+	server, requests := newImageTestServer(t)
+	// The real dialer, which checks the address a name resolves to
+	fetcher := NewImageFetcher(nil, 3)
+	port := server.Listener.Addr().(*net.TCPAddr).Port
+
+	for _, rawURL := range []string{
+		"http://localhost:" + strconv.Itoa(port) + "/img.jpg",
+		"http://127.0.0.1:" + strconv.Itoa(port) + "/img.jpg",
+	} {
+		if _, err := fetcher.Fetch(context.Background(), "zara", rawURL); !errors.Is(err, ErrBlockedAddress) {
+			t.Errorf("%s: got %v, want ErrBlockedAddress", rawURL, err)
+		}
+	}
+	if n := requests.Load(); n != 0 {
+		t.Fatalf("%d requests reached the loopback server", n)
+	}
+}
+
+func TestImageFetcherRedirectLimit(t *testing.T) {
+	server, _ := newImageTestServer(t)
+	fetcher := newRoutedImageFetcher(server, nil, 3)
+
+	// /hop/2 redirects three times: /hop/1, /hop/0, /img.jpg
+	if _, err := fetcher.Fetch(context.Background(), "zara", "http://static.zara.net/hop/2"); err != nil {
+		t.Fatalf("3 redirects: %v", err)
+	}
+	_, err := fetcher.Fetch(context.Background(), "zara", "http://static.zara.net/hop/3")
+	if !errors.Is(err, ErrTooManyRedirects) || !IsPermanentFetchError(err) {
+		t.Fatalf("4 redirects: got %v, want a permanent ErrTooManyRedirects", err)
+	}
+}
+
+func TestImageFetcherAllowlist(t *testing.T) {
+	server, _ := newImageTestServer(t)
+	fetcher := newRoutedImageFetcher(server, map[string][]string{
+		"Zara": {"*.zara.net", "images.inditex.com"},
+		"*":    {"cdn.shared.com"},
+	}, 3)
+
+	tests := []struct {
+		store   string
+		url     string
+		allowed bool
+	}{
+		{"zara", "http://static.zara.net/img.jpg", true},
+		{"ZARA", "http://a.b.zara.net/img.jpg", true},
+		{"zara", "http://IMAGES.INDITEX.COM./img.jpg", true},
+		{"zara", "http://zara.net/img.jpg", false},
+		{"zara", "http://evilzara.net/img.jpg", false},
+		{"zara", "http://cdn.shared.com/img.jpg", false},
+		{"mango", "http://cdn.shared.com/img.jpg", true},
+		{"mango", "http://static.zara.net/img.jpg", false},
+		{"", "http://cdn.shared.com/img.jpg", true},
+		// Redirects are held to the same hosts
+		{"zara", "http://static.zara.net/redirect?to=http://evil.example.com/img.jpg", false},
+		{"zara", "http://static.zara.net/redirect?to=http://cdn.zara.net/img.jpg", true},
+	}
+	for _, test := range tests {
+		_, err := fetcher.Fetch(context.Background(), test.store, test.url)
+		switch {
+		case test.allowed && err != nil:
+			t.Errorf("store %q, %s: %v", test.store, test.url, err)
+		case !test.allowed && !errors.Is(err, ErrHostNotAllowed):
+			t.Errorf("store %q, %s: got %v, want ErrHostNotAllowed", test.store, test.url, err)
+		}
+	}
+}
+
+func TestImageFetcherWithoutAllowlistAllowsAnyHost(t *testing.T) {
+	server, _ := newImageTestServer(t)
+	fetcher := newRoutedImageFetcher(server, map[string][]string{"zara": {"static.zara.net"}}, 3)
+
+	if _, err := fetcher.Fetch(context.Background(), "mango", "http://anything.example.org/img.jpg"); err != nil {
+		t.Fatalf("store without an entry and no \"*\": %v", err)
+	}
+	if _, err := fetcher.Fetch(context.Background(), "zara", "http://anything.example.org/img.jpg"); !errors.Is(err, ErrHostNotAllowed) {
+		t.Fatalf("store with an entry: got %v, want ErrHostNotAllowed", err)
+	}
+}
+
+func TestImageFetcherRejectsInvalidURLs(t *testing.T) {
+	fetcher := NewImageFetcher(nil, 3)
+	for _, rawURL := range []string{"ftp://example.com/a.jpg", "data:image/png;base64,AAAA", "http:///a.jpg", "://bad"} {
+		if _, err := fetcher.Fetch(context.Background(), "zara", rawURL); !errors.Is(err, ErrInvalidImageURL) {
+			t.Errorf("%s: got %v, want ErrInvalidImageURL", rawURL, err)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"log"
+	"net/url"
+	"path"
+	"product-api/models"
+	"sort"
+	"strings"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// contentPath is where an image with the given SHA-256 is stored below ImagesKeyPrefix,
+// sharded on its first two bytes: ab/cd/abcdef....jpg
+func contentPath(hash, extension string) string {
+	return path.Join(hash[0:2], hash[2:4], hash+"."+extension)
+}
+
+// ImageURL is where clients fetch the image stored as name, as the storage serves
+// it: below /uploads/images through the API, or below the storage's public URL
+func (ip *ImageProcessor) ImageURL(name string) string {
+	return ip.Storage.URL(imageKey(name))
+}
+
+// storeImage stores image data under its content hash and returns its URL.
+// Data already stored is neither written again nor given a second record.
+// Anything but JPEG, PNG, GIF and WebP images is refused.
+func (ip *ImageProcessor) storeImage(data []byte) (string, error) {
+	mimeType, extension, ok := SniffImage(data)
+	if !ok {
+		return "", ErrNotAnImage
+	}
+	sum := sha256.Sum256(data)
+	record := models.Image{
+		Hash:     hex.EncodeToString(sum[:]),
+		MimeType: mimeType,
+		Size:     int64(len(data)),
+	}
+	if config, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		record.Width, record.Height = config.Width, config.Height
+	}
+	record.Path = contentPath(record.Hash, extension)
+
+	if ip.db != nil {
+		// A record of the same content may carry another extension, its path wins
+		result := ip.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+		if result.Error != nil {
+			return "", fmt.Errorf("failed to record image: %v", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			if err := ip.db.Select("path").Where("hash = ?", record.Hash).Take(&record).Error; err != nil {
+				return "", fmt.Errorf("failed to load image record: %v", err)
+			}
+		}
+	}
+
+	ctx := context.Background()
+	key := imageKey(record.Path)
+	exists, err := ip.Storage.Exists(ctx, key)
+	if err != nil {
+		return "", fmt.Errorf("failed to check stored image: %v", err)
+	}
+	if exists {
+		log.Printf("[DEBUG] storeImage: Image %s is already stored", record.Hash)
+		return ip.ImageURL(record.Path), nil
+	}
+	if err := ip.Storage.Put(ctx, key, data, record.MimeType); err != nil {
+		return "", fmt.Errorf("failed to save image: %v", err)
+	}
+
+	// A broken variant must not lose the original, it is generated again on request
+	if err := ip.GenerateVariants(ctx, record.Path, data); err != nil {
+		log.Printf("[WARN] storeImage: Failed to generate variants of %s: %v", record.Path, err)
+	}
+	return ip.ImageURL(record.Path), nil
+}
+
+// ImageHash returns the content hash of a stored image URL, false for external
+// URLs and images stored before content addressing. The URL is recognised by its
+// path ending in images/ab/cd/<hash>.<ext>, whatever storage URL comes before it,
+// so images keep their references when the storage's public URL changes.
+func ImageHash(rawURL string) (string, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", false
+	}
+	parts := strings.Split(u.Path, "/")
+	if len(parts) < 4 {
+		return "", false
+	}
+	parts = parts[len(parts)-4:]
+	if parts[0] != ImagesKeyPrefix {
+		return "", false
+	}
+	hash := strings.TrimSuffix(parts[3], path.Ext(parts[3]))
+	if len(hash) != sha256.Size*2 || parts[1] != hash[0:2] || parts[2] != hash[2:4] {
+		return "", false
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", false
+	}
+	return hash, true
+}
+
+// imageHashes returns the distinct stored images referenced by a product's images
+func imageHashes(images datatypes.JSON) map[string]bool {
+	hashes := make(map[string]bool)
+	var urls []interface{}
+	if len(images) == 0 || json.Unmarshal(images, &urls) != nil {
+		return hashes
+	}
+	for _, url := range urls {
+		if s, ok := url.(string); ok {
+			if hash, ok := ImageHash(s); ok {
+				hashes[hash] = true
+			}
+		}
+	}
+	return hashes
+}
+
+// ImageRefs accumulates changes to the reference counts of stored images. An image
+// is referenced once by every product whose images contain it.
+type ImageRefs map[string]int
+
+// Replace records that a product's images changed from before to after;
+// nil before is a new product, nil after a deleted one
+func (r ImageRefs) Replace(before, after datatypes.JSON) {
+	old, current := imageHashes(before), imageHashes(after)
+	for hash := range old {
+		if !current[hash] {
+			r[hash]--
+		}
+	}
+	for hash := range current {
+		if !old[hash] {
+			r[hash]++
+		}
+	}
+}
+
+// Apply adds the accumulated changes to the images table in one statement
+func (r ImageRefs) Apply(tx *gorm.DB) error {
+	hashes := make([]string, 0, len(r))
+	for hash, delta := range r {
+		if delta != 0 {
+			hashes = append(hashes, hash)
+		}
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+	// A fixed order keeps concurrent batches from locking rows in opposite orders
+	sort.Strings(hashes)
+
+	values := make([]string, len(hashes))
+	args := make([]interface{}, 0, 2*len(hashes))
+	for i, hash := range hashes {
+		values[i] = "(?, ?::int)"
+		args = append(args, hash, r[hash])
+	}
+	return tx.Exec(`UPDATE images SET ref_count = GREATEST(images.ref_count + d.delta, 0), updated_at = now()
+		FROM (VALUES `+strings.Join(values, ", ")+`) AS d(hash, delta) WHERE images.hash = d.hash`, args...).Error
+}
@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// testPNG returns a small PNG and its content hash
+func testPNG(t *testing.T) ([]byte, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return buf.Bytes(), hex.EncodeToString(sum[:])
+}
+
+func TestStoreImageReturnsStorageURL(t *testing.T) {
+	data, hash := testPNG(t)
+	name := hash[0:2] + "/" + hash[2:4] + "/" + hash + ".png"
+
+	server := newFakeS3(t)
+	s3, err := NewS3Storage(S3Options{
+		Endpoint:        server.URL,
+		Bucket:          testBucket,
+		AccessKeyID:     awsExampleAccessKeyID,
+		SecretAccessKey: awsExampleSecretAccessKey,
+		PathStyle:       true,
+		PublicURL:       "https://cdn.example.com/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3.Client = server.Client()
+
+	tests := []struct {
+		name    string
+		storage Storage
+		want    string
+	}{
+		{"local", NewLocalStorage(t.TempDir()), "/uploads/images/" + name},
+		{"s3 with public URL", s3, "https://cdn.example.com/images/" + name},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip := NewImageProcessor(nil, tt.storage)
+			// The second store finds the image already there
+			for i := 0; i < 2; i++ {
+				url, err := ip.storeImage(data)
+				if err != nil {
+					t.Fatalf("store %d: %v", i, err)
+				}
+				if url != tt.want {
+					t.Fatalf("store %d returned %q, want %q", i, url, tt.want)
+				}
+				if got, ok := ImageHash(url); !ok || got != hash {
+					t.Fatalf("ImageHash(%q) = %q, %v", url, got, ok)
+				}
+			}
+		})
+	}
+}
+
+func TestImageHash(t *testing.T) {
+	_, hash := testPNG(t)
+	shards := hash[0:2] + "/" + hash[2:4] + "/"
+
+	tests := []struct {
+		name string
+		url  string
+		ok   bool
+	}{
+		{"served by the API", "/uploads/images/" + shards + hash + ".png", true},
+		{"public URL", "https://cdn.example.com/images/" + shards + hash + ".png", true},
+		{"public URL with a path", "https://example.com/static/images/" + shards + hash + ".jpg", true},
+		{"query string", "https://cdn.example.com/images/" + shards + hash + ".png?v=2", true},
+		{"variant", "/uploads/images/variants/400/" + shards + hash + ".jpg", false},
+		{"wrong shards", "/uploads/images/00/00/" + hash + ".png", false},
+		{"not under images", "https://cdn.example.com/photos/" + shards + hash + ".png", false},
+		{"before content addressing", "/uploads/images/1696357947_abc.jpg", false},
+		{"short hash", "/uploads/images/ab/cd/abcd.jpg", false},
+		{"not hex", "/uploads/images/gg/gg/" + strings.Repeat("g", 64) + ".png", false},
+		{"external", "https://static.zara.net/photos/a.jpg", false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ImageHash(tt.url)
+			if ok != tt.ok || (ok && got != hash) {
+				t.Fatalf("ImageHash(%q) = %q, %v, want ok %v", tt.url, got, ok, tt.ok)
+			}
+		})
+	}
+}
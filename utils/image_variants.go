@@ -0,0 +1,195 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	_ "image/gif" // registers the GIF decoder
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"mime"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // registers the WebP decoder
+	"golang.org/x/sync/singleflight"
+)
+
+// ImagesKeyPrefix is the storage key prefix of images, served under /uploads/images
+const ImagesKeyPrefix = "images"
+
+// VariantsDir holds the resized variants below ImagesKeyPrefix, one directory per width
+const VariantsDir = "variants"
+
+// variantJPEGQuality is the quality resized JPEG variants are encoded with
+const variantJPEGQuality = 85
+
+// variantGroup collapses concurrent requests generating the same variant
+var variantGroup singleflight.Group
+
+// MaxImagePixels is the largest image, in pixels, that is decoded. A few KB of
+// compressed data can declare dimensions whose decoded bitmap takes gigabytes.
+const MaxImagePixels = 50_000_000
+
+// ErrImageTooManyPixels is returned for images whose dimensions exceed MaxImagePixels
+var ErrImageTooManyPixels = fmt.Errorf("image exceeds the maximum of %d pixels", MaxImagePixels)
+
+// DecodeImage decodes a JPEG, PNG, GIF (first frame) or WebP image. The header is
+// read first so images above MaxImagePixels are refused before any pixel is allocated.
+func DecodeImage(data []byte) (image.Image, string, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image: %v", err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || int64(config.Width)*int64(config.Height) > MaxImagePixels {
+		return nil, "", fmt.Errorf("%w: %dx%d", ErrImageTooManyPixels, config.Width, config.Height)
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image: %v", err)
+	}
+	return img, format, nil
+}
+
+// ResizeToWidth scales an image down to width keeping its aspect ratio.
+// Images that are not wider than width are returned unchanged.
+func ResizeToWidth(img image.Image, width int) image.Image {
+	bounds := img.Bounds()
+	if width <= 0 || bounds.Dx() <= width {
+		return img
+	}
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+	resized := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(resized, resized.Bounds(), img, bounds, draw.Over, nil)
+	return resized
+}
+
+// variantExtension is the file extension variants of an image are stored with,
+// following the extension of the image. There is no WebP encoder in Go, so WebP
+// becomes JPEG; GIF becomes PNG (first frame only).
+func variantExtension(name string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".png", ".gif":
+		return "png"
+	default:
+		return "jpg"
+	}
+}
+
+// EncodeVariant writes img in the format of extension, PNG or JPEG
+func EncodeVariant(w io.Writer, img image.Image, extension string) error {
+	if extension == "png" {
+		return png.Encode(w, img)
+	}
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: variantJPEGQuality})
+}
+
+// VariantWidth picks the configured width serving a requested width: the smallest
+// configured width not below it, or the largest one. Snapping keeps the number of
+// variants per image bounded whatever clients ask for.
+func VariantWidth(widths []int, requested int) int {
+	if len(widths) == 0 || requested <= 0 {
+		return 0
+	}
+	sorted := append([]int(nil), widths...)
+	sort.Ints(sorted)
+	for _, width := range sorted {
+		if width >= requested {
+			return width
+		}
+	}
+	return sorted[len(sorted)-1]
+}
+
+// imageKey is the storage key of an image stored as name
+func imageKey(name string) string {
+	return ImagesKeyPrefix + "/" + name
+}
+
+// variantKey is the storage key of the variant of the image stored as name
+func variantKey(name string, width int) string {
+	base := strings.TrimSuffix(name, path.Ext(name))
+	return path.Join(ImagesKeyPrefix, VariantsDir, strconv.Itoa(width), base+"."+variantExtension(name))
+}
+
+// GenerateVariants stores every configured width of the image stored as name
+func (ip *ImageProcessor) GenerateVariants(ctx context.Context, name string, data []byte) error {
+	if len(ip.VariantWidths) == 0 {
+		return nil
+	}
+	img, _, err := DecodeImage(data)
+	if err != nil {
+		return err
+	}
+	for _, width := range ip.VariantWidths {
+		if err := ip.writeVariant(ctx, name, img, width); err != nil {
+			return err
+		}
+	}
+	log.Printf("[DEBUG] GenerateVariants: Wrote %d variants of %s", len(ip.VariantWidths), name)
+	return nil
+}
+
+// writeVariant resizes img to width and stores it
+func (ip *ImageProcessor) writeVariant(ctx context.Context, name string, img image.Image, width int) error {
+	extension := variantExtension(name)
+	var buf bytes.Buffer
+	if err := EncodeVariant(&buf, ResizeToWidth(img, width), extension); err != nil {
+		return fmt.Errorf("failed to encode %dpx variant: %v", width, err)
+	}
+	if err := ip.Storage.Put(ctx, variantKey(name, width), buf.Bytes(), mime.TypeByExtension("."+extension)); err != nil {
+		return fmt.Errorf("failed to save %dpx variant: %v", width, err)
+	}
+	return nil
+}
+
+// Variant returns the storage key of the variant of the image stored as name for a
+// requested width, generating it when it does not exist yet. A zero width, or an
+// image processor without configured widths, returns the key of the original.
+func (ip *ImageProcessor) Variant(ctx context.Context, name string, requested int) (string, error) {
+	width := VariantWidth(ip.VariantWidths, requested)
+	if width == 0 {
+		return imageKey(name), nil
+	}
+
+	key := variantKey(name, width)
+	_, err, _ := variantGroup.Do(key, func() (interface{}, error) {
+		exists, err := ip.Storage.Exists(ctx, key)
+		if err != nil || exists {
+			return nil, err
+		}
+
+		reader, _, err := ip.Storage.Get(ctx, imageKey(name))
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(io.LimitReader(reader, MaxImageSize+1))
+		reader.Close()
+		if err != nil {
+			return nil, err
+		}
+		img, _, err := DecodeImage(data)
+		if err != nil {
+			return nil, err
+		}
+		if err := ip.writeVariant(ctx, name, img, width); err != nil {
+			return nil, err
+		}
+		log.Printf("[DEBUG] Variant: Generated %dpx variant of %s", width, name)
+		return nil, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return key, nil
+}
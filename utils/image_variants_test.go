@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"testing"
+)
+
+// pngHeader returns a PNG declaring width x height pixels, without any image data
+func pngHeader(width, height uint32) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], width)
+	binary.BigEndian.PutUint32(ihdr[4:], height)
+	ihdr[8], ihdr[9] = 8, 6 // 8 bit RGBA
+	chunk := append([]byte("IHDR"), ihdr...)
+	binary.Write(&buf, binary.BigEndian, uint32(len(ihdr)))
+	buf.Write(chunk)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	return buf.Bytes()
+}
+
+func TestDecodeImageRefusesTooManyPixels(t *testing.T) {
+	// 100000 x 100000 RGBA would be 40 GB once decoded
+	_, _, err := DecodeImage(pngHeader(100_000, 100_000))
+	if !errors.Is(err, ErrImageTooManyPixels) {
+		t.Fatalf("got %v, want ErrImageTooManyPixels", err)
+	}
+}
+
+func TestDecodeImage(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 40, 20))); err != nil {
+		t.Fatal(err)
+	}
+	img, format, err := DecodeImage(buf.Bytes())
+	if err != nil || format != "png" || img.Bounds().Dx() != 40 || img.Bounds().Dy() != 20 {
+		t.Fatalf("got %v, %q, %v", img.Bounds(), format, err)
+	}
+}
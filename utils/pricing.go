@@ -0,0 +1,170 @@
+package utils
+
+import (
+	"log"
+	"math"
+	"product-api/models"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// pricingRulesTTL is how long loaded rules are trusted before they are read again,
+// so changes made through another replica are picked up
+const pricingRulesTTL = time.Minute
+
+// PricingEngine computes ruble prices from the rules in the pricing_rules table
+type PricingEngine struct {
+	db *gorm.DB
+
+	mu       sync.RWMutex
+	rules    []models.PricingRule
+	loadedAt time.Time
+}
+
+// NewPricingEngine creates a pricing engine reading its rules from db
+func NewPricingEngine(db *gorm.DB) *PricingEngine {
+	return &PricingEngine{db: db}
+}
+
+// Reload reads the active rules from the database
+func (pe *PricingEngine) Reload() error {
+	var rules []models.PricingRule
+	if err := pe.db.Where("is_active = ?", true).Order("id").Find(&rules).Error; err != nil {
+		return err
+	}
+
+	pe.mu.Lock()
+	pe.rules = rules
+	pe.loadedAt = time.Now()
+	pe.mu.Unlock()
+
+	log.Printf("[DEBUG] PricingEngine: Loaded %d active pricing rules", len(rules))
+	return nil
+}
+
+// currentRules returns the cached rules, reloading them once they are stale.
+// A failed reload keeps serving the previous rules.
+func (pe *PricingEngine) currentRules() []models.PricingRule {
+	pe.mu.RLock()
+	rules, loadedAt := pe.rules, pe.loadedAt
+	pe.mu.RUnlock()
+
+	if time.Since(loadedAt) < pricingRulesTTL {
+		return rules
+	}
+	if err := pe.Reload(); err != nil {
+		log.Printf("[ERROR] PricingEngine: Failed to reload pricing rules: %v", err)
+		return rules
+	}
+
+	pe.mu.RLock()
+	defer pe.mu.RUnlock()
+	return pe.rules
+}
+
+// Apply sets PriceInRubles and DiscountedPriceInRubles of a product from the best
+// matching rule. The rule is chosen by the regular price and also applied to the
+// discounted price. Without a matching rule both fields are cleared.
+func (pe *PricingEngine) Apply(product *models.Product) {
+	product.PriceInRubles = nil
+	product.DiscountedPriceInRubles = nil
+	if product.Price <= 0 {
+		return
+	}
+
+	rule := MatchPricingRule(pe.currentRules(), product)
+	if rule == nil {
+		return
+	}
+
+	priceInRubles := ApplyPricingRule(rule, product.Price)
+	product.PriceInRubles = &priceInRubles
+	if product.DiscountedPrice != nil && *product.DiscountedPrice > 0 {
+		discountedInRubles := ApplyPricingRule(rule, *product.DiscountedPrice)
+		product.DiscountedPriceInRubles = &discountedInRubles
+	}
+}
+
+// MatchPricingRule returns the rule that prices a product, or nil when none matches.
+// The highest priority wins, then the rule with the most conditions, then the oldest.
+func MatchPricingRule(rules []models.PricingRule, product *models.Product) *models.PricingRule {
+	var best *models.PricingRule
+	bestSpecificity := -1
+
+	for i := range rules {
+		rule := &rules[i]
+		if !ruleMatches(rule, product) {
+			continue
+		}
+
+		specificity := ruleSpecificity(rule)
+		if best == nil ||
+			rule.Priority > best.Priority ||
+			(rule.Priority == best.Priority && specificity > bestSpecificity) {
+			best = rule
+			bestSpecificity = specificity
+		}
+	}
+
+	return best
+}
+
+// ruleMatches reports whether every condition of a rule holds for a product
+func ruleMatches(rule *models.PricingRule, product *models.Product) bool {
+	if rule.Store != "" && !strings.EqualFold(rule.Store, product.Store) {
+		return false
+	}
+	if rule.Brand != "" && !strings.EqualFold(rule.Brand, product.Brand) {
+		return false
+	}
+	if rule.Category != "" && !strings.EqualFold(rule.Category, product.Category) {
+		return false
+	}
+	if rule.Currency != "" && !strings.EqualFold(rule.Currency, product.Currency) {
+		return false
+	}
+	if rule.MinPrice != nil && product.Price < *rule.MinPrice {
+		return false
+	}
+	if rule.MaxPrice != nil && product.Price >= *rule.MaxPrice {
+		return false
+	}
+	return true
+}
+
+// ruleSpecificity counts the conditions a rule sets
+func ruleSpecificity(rule *models.PricingRule) int {
+	specificity := 0
+	for _, condition := range []string{rule.Store, rule.Brand, rule.Category, rule.Currency} {
+		if condition != "" {
+			specificity++
+		}
+	}
+	if rule.MinPrice != nil || rule.MaxPrice != nil {
+		specificity++
+	}
+	return specificity
+}
+
+// ApplyPricingRule converts a source price with a rule: multiply, add the markup, round
+func ApplyPricingRule(rule *models.PricingRule, price float64) float64 {
+	return RoundPrice(price*rule.Multiplier+rule.Markup, rule.Rounding)
+}
+
+// RoundPrice rounds a price with one of the pricing rule rounding modes.
+// The "9", "99" and "999" modes round up to the next price ending in those digits,
+// e.g. 1234 becomes 1239, 1299 or 1999.
+func RoundPrice(value float64, mode string) float64 {
+	switch mode {
+	case models.RoundingInteger:
+		return math.Round(value)
+	case models.RoundingNine, models.RoundingNineNine, models.RoundingNines:
+		step := math.Pow(10, float64(len(mode)))
+		return math.Ceil((math.Round(value)+1)/step)*step - 1
+	default:
+		return math.Round(value*100) / 100
+	}
+}
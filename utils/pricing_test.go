@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"product-api/models"
+	"testing"
+	"time"
+)
+
+// newTestPricingEngine creates an engine serving rules without a database
+func newTestPricingEngine(rules []models.PricingRule) *PricingEngine {
+	return &PricingEngine{rules: rules, loadedAt: time.Now().Add(time.Hour)}
+}
+
+// floatPtr returns a pointer to v
+func floatPtr(v float64) *float64 {
+	return &v
+}
+
+func TestMatchPricingRule(t *testing.T) {
+	product := &models.Product{Store: "zara", Brand: "Zara", Category: "Dresses", Currency: "TRY", Price: 250}
+
+	tests := []struct {
+		name  string
+		rules []models.PricingRule
+		want  uint
+	}{
+		{"no rules", nil, 0},
+		{"no rule matches", []models.PricingRule{
+			{ID: 1, Store: "bershka"},
+			{ID: 2, Currency: "USD"},
+			{ID: 3, MinPrice: floatPtr(300)},
+			{ID: 4, MaxPrice: floatPtr(250)},
+		}, 0},
+		{"conditions ignore case", []models.PricingRule{{ID: 1, Store: "ZARA", Brand: "zara", Category: "dresses", Currency: "try"}}, 1},
+		{"band includes its minimum", []models.PricingRule{{ID: 1, MaxPrice: floatPtr(250)}, {ID: 2, MinPrice: floatPtr(250), MaxPrice: floatPtr(500)}}, 2},
+		{"priority beats specificity", []models.PricingRule{
+			{ID: 1, Store: "zara", Brand: "Zara", Category: "Dresses", Currency: "TRY", Priority: 1},
+			{ID: 2, Priority: 5},
+		}, 2},
+		{"more conditions win at equal priority", []models.PricingRule{
+			{ID: 1, Currency: "TRY"},
+			{ID: 2, Store: "zara", Currency: "TRY"},
+			{ID: 3, Store: "zara"},
+		}, 2},
+		{"a price band counts once", []models.PricingRule{
+			{ID: 1, MinPrice: floatPtr(100), MaxPrice: floatPtr(500)},
+			{ID: 2, Store: "zara", Currency: "TRY"},
+		}, 2},
+		{"oldest wins a tie", []models.PricingRule{{ID: 1, Store: "zara"}, {ID: 2, Brand: "Zara"}}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := MatchPricingRule(tt.rules, product)
+			if tt.want == 0 {
+				if rule != nil {
+					t.Fatalf("matched rule %d, want none", rule.ID)
+				}
+				return
+			}
+			if rule == nil || rule.ID != tt.want {
+				t.Fatalf("matched %+v, want rule %d", rule, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoundPrice(t *testing.T) {
+	tests := []struct {
+		mode  string
+		value float64
+		want  float64
+	}{
+		{models.RoundingNone, 1234.5678, 1234.57},
+		{"", 1234.5678, 1234.57},
+		{models.RoundingInteger, 1234.5, 1235},
+		{models.RoundingInteger, 1234.49, 1234},
+		{models.RoundingNine, 1234, 1239},
+		{models.RoundingNine, 1239, 1239},
+		{models.RoundingNine, 1239.4, 1239},
+		{models.RoundingNine, 1239.6, 1249},
+		{models.RoundingNine, 0.2, 9},
+		{models.RoundingNineNine, 1234, 1299},
+		{models.RoundingNineNine, 1299, 1299},
+		{models.RoundingNineNine, 1300, 1399},
+		{models.RoundingNines, 1234, 1999},
+		{models.RoundingNines, 999, 999},
+		{models.RoundingNines, 1000, 1999},
+		{models.RoundingNines, 20000.4, 20999},
+	}
+	for _, tt := range tests {
+		if got := RoundPrice(tt.value, tt.mode); got != tt.want {
+			t.Errorf("RoundPrice(%v, %q) = %v, want %v", tt.value, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestPricingEngineApply(t *testing.T) {
+	pe := newTestPricingEngine([]models.PricingRule{
+		{ID: 1, Currency: "TRY", Multiplier: 3, Markup: 100, Rounding: models.RoundingNineNine},
+	})
+
+	product := &models.Product{Currency: "TRY", Price: 400, DiscountedPrice: floatPtr(300)}
+	pe.Apply(product)
+	if product.PriceInRubles == nil || *product.PriceInRubles != 1399 {
+		t.Fatalf("priceInRubles %v, want 1399", product.PriceInRubles)
+	}
+	// The discounted price uses the rule chosen for the regular price
+	if product.DiscountedPriceInRubles == nil || *product.DiscountedPriceInRubles != 1099 {
+		t.Fatalf("discountedPriceInRubles %v, want 1099", product.DiscountedPriceInRubles)
+	}
+}
+
+func TestPricingEngineApplyClearsRublePrices(t *testing.T) {
+	pe := newTestPricingEngine([]models.PricingRule{{ID: 1, Currency: "TRY", Multiplier: 3}})
+
+	tests := []struct {
+		name    string
+		product models.Product
+	}{
+		{"no matching rule", models.Product{Currency: "USD", Price: 40, DiscountedPrice: floatPtr(30)}},
+		{"no price", models.Product{Currency: "TRY", Price: 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			product := tt.product
+			product.PriceInRubles, product.DiscountedPriceInRubles = floatPtr(1299), floatPtr(999)
+			pe.Apply(&product)
+			if product.PriceInRubles != nil || product.DiscountedPriceInRubles != nil {
+				t.Fatalf("ruble prices kept: %v, %v", product.PriceInRubles, product.DiscountedPriceInRubles)
+			}
+		})
+	}
+
+	// Without a discounted price only the regular ruble price is set
+	product := models.Product{Currency: "TRY", Price: 10, DiscountedPriceInRubles: floatPtr(999)}
+	pe.Apply(&product)
+	if product.PriceInRubles == nil || *product.PriceInRubles != 30 || product.DiscountedPriceInRubles != nil {
+		t.Fatalf("got %v, %v, want 30 and no discounted price", product.PriceInRubles, product.DiscountedPriceInRubles)
+	}
+}
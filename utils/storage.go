@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// UploadsURLPrefix is the route the API serves stored files under
+const UploadsURLPrefix = "/uploads/"
+
+// ObjectInfo describes a stored file
+type ObjectInfo struct {
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// Storage keeps files under slash separated keys such as "images/ab/cd/<hash>.jpg".
+// Get, Delete and Exists report missing keys with an error wrapping fs.ErrNotExist.
+type Storage interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+	Exists(ctx context.Context, key string) (bool, error)
+	// URL is where clients fetch key from
+	URL(key string) string
+}
+
+// cleanKey normalises a key; cleaning it as a rooted path drops every "..",
+// so keys cannot point outside the storage
+func cleanKey(key string) string {
+	return strings.TrimPrefix(path.Clean("/"+key), "/")
+}
+
+// LocalStorage stores files on the local disk below Root, served by the API under /uploads
+type LocalStorage struct {
+	Root string
+}
+
+// NewLocalStorage creates a storage keeping files below root
+func NewLocalStorage(root string) *LocalStorage {
+	return &LocalStorage{Root: root}
+}
+
+// path is the file a key is stored in
+func (ls *LocalStorage) path(key string) string {
+	return filepath.Join(ls.Root, filepath.FromSlash(cleanKey(key)))
+}
+
+// Put writes data to key, replacing it atomically when it exists
+func (ls *LocalStorage) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	file := ls.path(key)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+	return writeFileAtomic(file, data)
+}
+
+// Get opens the file of key; the reader is an *os.File and can seek
+func (ls *LocalStorage) Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	file, err := os.Open(ls.path(key))
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, ObjectInfo{}, err
+	}
+	if stat.IsDir() {
+		file.Close()
+		return nil, ObjectInfo{}, &fs.PathError{Op: "open", Path: key, Err: fs.ErrNotExist}
+	}
+	return file, ObjectInfo{
+		Size:        stat.Size(),
+		ContentType: mime.TypeByExtension(path.Ext(key)),
+		ModTime:     stat.ModTime(),
+	}, nil
+}
+
+// Delete removes the file of key
+func (ls *LocalStorage) Delete(ctx context.Context, key string) error {
+	return os.Remove(ls.path(key))
+}
+
+// Exists reports whether key is stored
+func (ls *LocalStorage) Exists(ctx context.Context, key string) (bool, error) {
+	stat, err := os.Stat(ls.path(key))
+	switch {
+	case err == nil:
+		return !stat.IsDir(), nil
+	case os.IsNotExist(err):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// URL returns the path the API serves key under
+func (ls *LocalStorage) URL(key string) string {
+	return UploadsURLPrefix + cleanKey(key)
+}
+
+// writeFileAtomic writes data to a temporary file renamed to name, so readers
+// never see a partially written file
+func writeFileAtomic(name string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
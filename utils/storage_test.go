@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+)
+
+// testStorageRoundTrip puts, reads, checks and deletes a key in storage, and checks
+// that missing keys are reported with fs.ErrNotExist
+func testStorageRoundTrip(t *testing.T, storage Storage) {
+	ctx := context.Background()
+	const key = "images/ab/cd/abcdef.jpg"
+	data := []byte("\xff\xd8\xff\xe0 not really a jpeg")
+
+	if exists, err := storage.Exists(ctx, key); err != nil || exists {
+		t.Fatalf("Exists before Put: got %v, %v", exists, err)
+	}
+	if _, _, err := storage.Get(ctx, key); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Get before Put: got %v, want fs.ErrNotExist", err)
+	}
+
+	if err := storage.Put(ctx, key, data, "image/jpeg"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if exists, err := storage.Exists(ctx, key); err != nil || !exists {
+		t.Fatalf("Exists after Put: got %v, %v", exists, err)
+	}
+
+	reader, info, err := storage.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	got, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil || string(got) != string(data) {
+		t.Fatalf("Get returned %q, %v", got, err)
+	}
+	if info.Size != int64(len(data)) || info.ContentType != "image/jpeg" {
+		t.Fatalf("unexpected object info %+v", info)
+	}
+
+	// Keys are cleaned, so the same file is reachable however the key is spelled
+	if exists, err := storage.Exists(ctx, "/images/ab/../ab/cd/abcdef.jpg"); err != nil || !exists {
+		t.Fatalf("Exists with an uncleaned key: got %v, %v", exists, err)
+	}
+	if url := storage.URL("/" + key); url != UploadsURLPrefix+key {
+		t.Fatalf("URL returned %q", url)
+	}
+
+	if err := storage.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if exists, err := storage.Exists(ctx, key); err != nil || exists {
+		t.Fatalf("Exists after Delete: got %v, %v", exists, err)
+	}
+	if _, _, err := storage.Get(ctx, key); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Get after Delete: got %v, want fs.ErrNotExist", err)
+	}
+	// S3 accepts deletes of missing keys, the disk reports them
+	if err := storage.Delete(ctx, key); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Delete of a missing key: %v", err)
+	}
+}
+
+func TestLocalStorageRoundTrip(t *testing.T) {
+	testStorageRoundTrip(t, NewLocalStorage(t.TempDir()))
+}
+
+func TestLocalStorageGetDirectory(t *testing.T) {
+	storage := NewLocalStorage(t.TempDir())
+	if err := storage.Put(context.Background(), "images/a.jpg", []byte("x"), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := storage.Get(context.Background(), "images"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Get of a directory: got %v, want fs.ErrNotExist", err)
+	}
+	if exists, err := storage.Exists(context.Background(), "images"); err != nil || exists {
+		t.Fatalf("Exists of a directory: got %v, %v", exists, err)
+	}
+}
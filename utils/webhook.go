@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers sent with every webhook. The signature is
+// "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + body)).
+const (
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// webhookMaxRedirects is the number of redirects a webhook call follows
+const webhookMaxRedirects = 3
+
+// ErrInvalidWebhookURL is returned for webhook URLs, or redirects, that are not http(s)
+var ErrInvalidWebhookURL = errors.New("invalid webhook URL")
+
+// WebhookSender posts signed JSON payloads to subscriber endpoints
+type WebhookSender struct {
+	Client *http.Client
+}
+
+// NewWebhookSender creates a sender with a bounded request timeout. Subscribers
+// choose the URL, so like image downloads it only connects to public addresses,
+// checked after DNS resolution, and every redirect is checked the same way.
+// Replace Client to point it at a test server or a proxy.
+func NewWebhookSender() *WebhookSender {
+	return &WebhookSender{Client: &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: newPublicTransport(),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > webhookMaxRedirects {
+				return fmt.Errorf("%w, stopped after %d", ErrTooManyRedirects, webhookMaxRedirects)
+			}
+			return checkPublicURL(req.URL, ErrInvalidWebhookURL)
+		},
+	}}
+}
+
+// Send posts payload to url and returns the response status code.
+// Any status outside 2xx is returned as an error next to the code.
+func (ws *WebhookSender) Send(ctx context.Context, url, secret, event, deliveryID string, payload []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, event)
+	req.Header.Set(WebhookDeliveryHeader, deliveryID)
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(secret, timestamp, payload))
+
+	resp, err := ws.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drain a little of the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// SignWebhook computes the signature header value of a webhook body
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks a received signature in constant time, for receivers
+func VerifyWebhook(secret, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(SignWebhook(secret, timestamp, body)), []byte(signature))
+}
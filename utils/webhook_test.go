@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWebhookSenderSignsPayload(t *testing.T) {
+	const secret = "s3cr3t"
+	payload := []byte(`{"event":"price_drop","productId":"p-1"}`)
+
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sender := NewWebhookSender()
+	sender.Client = server.Client()
+	status, err := sender.Send(context.Background(), server.URL, secret, "price_drop", "42", payload)
+	if err != nil || status != http.StatusNoContent {
+		t.Fatalf("got status %d, err %v", status, err)
+	}
+
+	r, body := <-received, <-bodies
+	if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("got %s with Content-Type %q", r.Method, r.Header.Get("Content-Type"))
+	}
+	if r.Header.Get(WebhookEventHeader) != "price_drop" || r.Header.Get(WebhookDeliveryHeader) != "42" {
+		t.Fatalf("unexpected event or delivery headers: %v", r.Header)
+	}
+	if string(body) != string(payload) {
+		t.Fatalf("got body %q", body)
+	}
+	timestamp, signature := r.Header.Get(WebhookTimestampHeader), r.Header.Get(WebhookSignatureHeader)
+	if !VerifyWebhook(secret, timestamp, body, signature) {
+		t.Fatalf("signature %q does not verify", signature)
+	}
+	if VerifyWebhook("other", timestamp, body, signature) || VerifyWebhook(secret, timestamp, []byte(`{}`), signature) {
+		t.Fatal("signature verifies with another secret or body")
+	}
+}
+
+func TestWebhookSenderReportsErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	sender := NewWebhookSender()
+	sender.Client = server.Client()
+	status, err := sender.Send(context.Background(), server.URL, "secret", "back_in_stock", "1", []byte(`{}`))
+	if err == nil || status != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d, err %v", status, err)
+	}
+}
+
+func TestWebhookSenderRefusesPrivateAddresses(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	}))
+	defer server.Close()
+	port := strconv.Itoa(server.Listener.Addr().(*net.TCPAddr).Port)
+
+	// The default client, which checks the address a name resolves to
+	sender := NewWebhookSender()
+	for _, url := range []string{server.URL, "http://localhost:" + port + "/hook"} {
+		if _, err := sender.Send(context.Background(), url, "secret", "price_drop", "1", []byte(`{}`)); !errors.Is(err, ErrBlockedAddress) {
+			t.Errorf("%s: got %v, want ErrBlockedAddress", url, err)
+		}
+	}
+	if n := requests.Load(); n != 0 {
+		t.Fatalf("%d webhooks reached the loopback server", n)
+	}
+}
+
+func TestWebhookSenderRedirectPolicy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metadata":
+			http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusTemporaryRedirect)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusTemporaryRedirect)
+		case "/moved":
+			http.Redirect(w, r, "/hook", http.StatusTemporaryRedirect)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	sender := NewWebhookSender()
+	sender.Client.Transport = routedTransport(server)
+	send := func(path string) error {
+		_, err := sender.Send(context.Background(), "http://hooks.example.com"+path, "secret", "price_drop", "1", []byte(`{}`))
+		return err
+	}
+
+	if err := send("/moved"); err != nil {
+		t.Fatalf("redirect to a public host: %v", err)
+	}
+	if err := send("/metadata"); !errors.Is(err, ErrBlockedAddress) {
+		t.Fatalf("redirect to the metadata service: got %v, want ErrBlockedAddress", err)
+	}
+	if err := send("/loop"); !errors.Is(err, ErrTooManyRedirects) {
+		t.Fatalf("redirect loop: got %v, want ErrTooManyRedirects", err)
+	}
+}